@@ -0,0 +1,79 @@
+package amznode
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// attributeParamPrefix is the prefix of the query parameters which filter
+// listings by attribute, such as `attr.city=Berlin`
+const attributeParamPrefix = "attr."
+
+var errInvalidAttributes = errors.New("the attributes must be a json object")
+var errInvalidAttributeKey = errors.New("attribute keys must not be empty")
+
+// decodeAttributes decodes a json object of attributes from the request body.
+// A nil value of a key is kept, which removes the key when patching.
+func decodeAttributes(r io.Reader) (map[string]interface{}, error) {
+	br := bufio.NewReader(r)
+	first, err := peekNonSpace(br)
+	if err == io.EOF {
+		return nil, errEmptyBody
+	}
+	if err != nil {
+		return nil, err
+	}
+	if first != '{' {
+		return nil, errInvalidAttributes
+	}
+
+	var attrs map[string]interface{}
+	err = json.NewDecoder(br).Decode(&attrs)
+	if err != nil {
+		return nil, err
+	}
+	for key := range attrs {
+		if key == "" {
+			return nil, errInvalidAttributeKey
+		}
+	}
+	return attrs, nil
+}
+
+// urlParamAttributes reads the attributes to filter by from the `attr.<key>`
+// query parameters. Values are parsed as json, such that `attr.floor=3`
+// matches the number 3, and used as strings if they are not valid json.
+func urlParamAttributes(r *http.Request) (map[string]interface{}, error) {
+	var attrs map[string]interface{}
+	for param, values := range r.URL.Query() {
+		if !strings.HasPrefix(param, attributeParamPrefix) {
+			continue
+		}
+		key := strings.TrimPrefix(param, attributeParamPrefix)
+		if key == "" {
+			return nil, errInvalidAttributeKey
+		}
+		var value interface{}
+		if json.Unmarshal([]byte(values[0]), &value) != nil {
+			value = values[0]
+		}
+		if attrs == nil {
+			attrs = map[string]interface{}{}
+		}
+		attrs[key] = value
+	}
+	return attrs, nil
+}
+
+// respondAttributes responds the attributes of a node, which are an empty
+// object rather than null if the node has none
+func respondAttributes(w http.ResponseWriter, r *http.Request, attrs map[string]interface{}) {
+	if attrs == nil {
+		attrs = map[string]interface{}{}
+	}
+	respond(w, r, attrs, http.StatusOK)
+}
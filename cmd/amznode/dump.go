@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/blacksails/amznode"
+)
+
+// dumpVersion is the version of the format written by `dump`. Dumps of
+// version 1 are a list of the trees in the format of the export endpoint,
+// without the policies and the trash.
+const dumpVersion = 2
+
+// dumpFile is the format of a dump
+type dumpFile struct {
+	Version int             `json:"version"`
+	Trees   []*amznode.Node `json:"trees"`
+	// Policies are the policies of the trees which have one
+	Policies []dumpPolicy `json:"policies"`
+	// Trash holds the deleted nodes along with the decendants deleted along
+	// with them, most recently deleted first
+	Trash []*amznode.TrashedNode `json:"trash"`
+}
+
+// dumpPolicy is the policy of the tree with its root at the node with RootID
+type dumpPolicy struct {
+	RootID int `json:"root_id"`
+	amznode.Policy
+}
+
+// dump writes every tree of the storage to w along with the policies of the
+// trees and the trash.
+func dump(storage amznode.Storage, w io.Writer) error {
+	trees, err := storage.GetRootsRec(-1)
+	if err != nil {
+		return err
+	}
+	policies := []dumpPolicy{}
+	for _, root := range trees {
+		policy, err := storage.GetPolicy(root.ID)
+		if err != nil {
+			return err
+		}
+		if policy != (amznode.Policy{}) {
+			policies = append(policies, dumpPolicy{RootID: root.ID, Policy: policy})
+		}
+	}
+	trash, err := storage.GetTrashRec()
+	if err != nil {
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(dumpFile{
+		Version:  dumpVersion,
+		Trees:    trees,
+		Policies: policies,
+		Trash:    trash,
+	})
+}
+
+// restore creates the trees of a dump read from r as root nodes, and then
+// sets their policies and fills the trash.
+func restore(storage amznode.Storage, r io.Reader, opts amznode.ImportOptions) error {
+	d, err := readDump(r)
+	if err != nil {
+		return err
+	}
+
+	nodes, err := storage.Import(0, d.Trees, opts)
+	if err != nil {
+		return err
+	}
+	// ids maps the ids of the dump to the ids of the restored nodes
+	ids := map[int]int{}
+	mapIDs(ids, d.Trees, nodes)
+
+	for _, p := range d.Policies {
+		if err := storage.SetPolicy(ids[p.RootID], p.Policy); err != nil {
+			return err
+		}
+	}
+
+	// nodes deleted earlier can have been decendants of nodes deleted later,
+	// so the most recently deleted nodes are restored first
+	for _, trashed := range d.Trash {
+		parentID := 0
+		if trashed.ParentID != 0 {
+			var ok bool
+			parentID, ok = ids[trashed.ParentID]
+			if !ok {
+				return fmt.Errorf(
+					"the parent %d of the deleted node %d is not in the dump",
+					trashed.ParentID, trashed.ID)
+			}
+		}
+		tree := []*amznode.Node{&trashed.Node}
+		trashOpts := opts
+		trashOpts.DeletedAt = trashed.DeletedAt
+		nodes, err := storage.Import(parentID, tree, trashOpts)
+		if err != nil {
+			return err
+		}
+		mapIDs(ids, tree, nodes)
+	}
+
+	log.Printf("restored %d trees, %d policies and %d deleted nodes",
+		len(d.Trees), len(d.Policies), len(d.Trash))
+	return nil
+}
+
+// readDump reads a dump of any version
+func readDump(r io.Reader) (*dumpFile, error) {
+	var raw json.RawMessage
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return nil, err
+	}
+	if len(raw) > 0 && raw[0] == '[' {
+		d := &dumpFile{Version: 1}
+		return d, json.Unmarshal(raw, &d.Trees)
+	}
+
+	d := &dumpFile{}
+	if err := json.Unmarshal(raw, d); err != nil {
+		return nil, err
+	}
+	if d.Version < 2 || d.Version > dumpVersion {
+		return nil, fmt.Errorf("unsupported dump version %d", d.Version)
+	}
+	return d, nil
+}
+
+// mapIDs maps the ids of the dumped nodes to the ids of the restored nodes,
+// which differ unless the ids are preserved. The nodes are matched by name,
+// as the restored siblings are ordered by position rather than like the dump.
+func mapIDs(ids map[int]int, dumped, restored []*amznode.Node) {
+	byName := map[string]*amznode.Node{}
+	for _, n := range restored {
+		byName[n.Name] = n
+	}
+	for _, n := range dumped {
+		if r, ok := byName[n.Name]; ok {
+			ids[n.ID] = r.ID
+			mapIDs(ids, n.Children, r.Children)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/blacksails/amznode"
+	"github.com/blacksails/amznode/memory"
+)
+
+// createStore creates two trees with a policy, and deletes a few nodes such
+// that a node deleted earlier is a decendant of a node deleted later.
+//
+//	root (max height 4)
+//	  c1 (deleted after c3)
+//	    c3 (deleted)
+//	  c2
+//	other (deleted)
+func createStore(t *testing.T) *memory.Storage {
+	s := memory.New()
+	root, err := s.Create("root", 0, amznode.CreateOptions{})
+	require.NoError(t, err)
+	c1, err := s.Create("c1", root.ID, amznode.CreateOptions{})
+	require.NoError(t, err)
+	_, err = s.Create("c2", root.ID, amznode.CreateOptions{
+		Attributes: map[string]interface{}{"manager": "alice"},
+	})
+	require.NoError(t, err)
+	c3, err := s.Create("c3", c1.ID, amznode.CreateOptions{})
+	require.NoError(t, err)
+	other, err := s.Create("other", 0, amznode.CreateOptions{})
+	require.NoError(t, err)
+
+	require.NoError(t, s.SetPolicy(root.ID, amznode.Policy{MaxHeight: 4}))
+	require.NoError(t, s.Delete(c3.ID))
+	require.NoError(t, s.Delete(c1.ID))
+	require.NoError(t, s.Delete(other.ID))
+	return s
+}
+
+func TestDumpRestore(t *testing.T) {
+	var dumped bytes.Buffer
+	require.NoError(t, dump(createStore(t), &dumped))
+
+	s := memory.New()
+	opts := amznode.ImportOptions{PreserveIDs: true}
+	require.NoError(t, restore(s, bytes.NewReader(dumped.Bytes()), opts))
+
+	var restored bytes.Buffer
+	require.NoError(t, dump(s, &restored))
+	assert.Equal(t, dumped.String(), restored.String(), "the dump must round trip")
+}
+
+func TestRestoreNewIDs(t *testing.T) {
+	var dumped bytes.Buffer
+	require.NoError(t, dump(createStore(t), &dumped))
+
+	s := memory.New()
+	existing, err := s.Create("existing", 0, amznode.CreateOptions{})
+	require.NoError(t, err)
+	require.NoError(t, restore(s, &dumped, amznode.ImportOptions{}))
+
+	root, err := s.GetByPath("root")
+	require.NoError(t, err)
+	assert.NotEqual(t, existing.ID, root.ID, "the dumped ids must not be preserved")
+	policy, err := s.GetPolicy(root.ID)
+	require.NoError(t, err)
+	assert.Equal(t, amznode.Policy{MaxHeight: 4}, policy)
+
+	trash, err := s.GetTrashRec()
+	require.NoError(t, err)
+	require.Len(t, trash, 3)
+	assert.Equal(t, "other", trash[0].Name)
+	assert.Equal(t, "c1", trash[1].Name)
+	assert.Equal(t, root.ID, trash[1].ParentID)
+	assert.Equal(t, "c3", trash[2].Name)
+	assert.Equal(t, trash[1].ID, trash[2].ParentID)
+
+	node, err := s.Restore(trash[1].ID)
+	require.NoError(t, err)
+	assert.Empty(t, node.Children, "c3 was deleted before c1")
+}
+
+func TestRestoreVersion1(t *testing.T) {
+	s := memory.New()
+	dumped := `[{"id": 7, "name": "root", "children": [{"id": 9, "name": "c1"}]}]`
+	opts := amznode.ImportOptions{PreserveIDs: true}
+	require.NoError(t, restore(s, strings.NewReader(dumped), opts))
+
+	node, err := s.Get(9)
+	require.NoError(t, err)
+	assert.Equal(t, "c1", node.Name)
+	assert.Equal(t, 7, node.ParentID)
+
+	err = restore(s, strings.NewReader(`{"version": 3}`), opts)
+	assert.EqualError(t, err, "unsupported dump version 3")
+}
@@ -1,36 +1,90 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"time"
 
 	"github.com/blacksails/amznode"
 	"github.com/blacksails/amznode/pg"
 )
 
+const usage = `usage: amznode [command]
+
+commands:
+  serve                    serve the http api on :8080 (default)
+  dump                     write all trees, policies and deleted nodes as
+                           json to stdout
+  restore [-preserve-ids]  restore a dump read from stdin
+`
+
 func main() {
+	cmd := "serve"
+	args := os.Args[1:]
+	if len(args) > 0 {
+		cmd, args = args[0], args[1:]
+	}
+
+	var err error
+	switch cmd {
+	case "serve":
+		err = serve(connect())
+	case "dump":
+		err = dump(connect(), os.Stdout)
+	case "restore":
+		flags := flag.NewFlagSet("restore", flag.ExitOnError)
+		preserveIDs := flags.Bool(
+			"preserve-ids", false, "keep the ids of the dumped nodes")
+		flags.Parse(args)
+		opts := amznode.ImportOptions{PreserveIDs: *preserveIDs}
+		err = restore(connect(), os.Stdin, opts)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// connect connects to the storage, retrying for a while as the database might
+// still be starting up. The type schema is loaded from the json file given by
+// the AMZNODE_TYPES env variable, if it is set.
+func connect() amznode.Storage {
+	var schema *amznode.TypeSchema
+	if path := amznode.GetEnv("AMZNODE_TYPES", ""); path != "" {
+		var err error
+		schema, err = amznode.LoadTypeSchema(path)
+		if err != nil {
+			log.Fatalf("loading the type schema: %s", err)
+		}
+	}
+
 	var (
 		storage amznode.Storage
 		err     error
 	)
 	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
 	tries := 0
 	for {
 		<-ticker.C
 		storage, err = pg.NewFromEnv()
 		if err == nil {
-			break
+			storage.SetTypeSchema(schema)
+			return storage
 		}
 		tries++
 		if tries > 10 {
 			log.Fatal(err)
 		}
 	}
-	ticker.Stop()
+}
+
+func serve(storage amznode.Storage) error {
 	server := amznode.New(storage)
-	err = http.ListenAndServe(":8080", server.Handler())
-	if err != nil {
-		log.Fatal(err)
-	}
+	return http.ListenAndServe(":8080", server.Handler())
 }
@@ -0,0 +1,181 @@
+package amznode
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+const csvContentType = "text/csv"
+
+var errCSVColumns = errors.New(
+	"csv must have a header with either the columns 'id', 'parent_id' and 'name' or the column 'path'")
+var errCSVCycle = errors.New("the parent_id columns of the csv contains a cycle")
+
+// decodeCSVTrees decodes trees of nodes from csv. The header of the csv
+// determines how the trees are described: Either by rows of `id`, `parent_id`
+// and `name`, where `parent_id` is left empty for the top nodes, or by rows of
+// slash separated paths in a `path` column.
+func decodeCSVTrees(r io.Reader) ([]*Node, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, errEmptyBody
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cols := map[string]int{}
+	for i, col := range header {
+		cols[col] = i
+	}
+	rows, err := cr.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := cols["path"]; ok {
+		return decodeCSVPaths(rows, cols["path"])
+	}
+	idCol, hasID := cols["id"]
+	parentCol, hasParent := cols["parent_id"]
+	nameCol, hasName := cols["name"]
+	if !hasID || !hasParent || !hasName {
+		return nil, errCSVColumns
+	}
+	return decodeCSVAdjacencyList(rows, idCol, parentCol, nameCol)
+}
+
+func decodeCSVAdjacencyList(rows [][]string, idCol, parentCol, nameCol int) ([]*Node, error) {
+	nodes := map[int]*Node{}
+	parentIDs := make([]int, len(rows))
+	order := make([]*Node, len(rows))
+	for i, row := range rows {
+		// the header is the first line of the csv
+		line := i + 2
+		id, err := csvInt(row, idCol)
+		if err != nil || id <= 0 {
+			return nil, fmt.Errorf("line %d: the id must be a positive integer", line)
+		}
+		parentID, err := csvInt(row, parentCol)
+		if err != nil || parentID < 0 {
+			return nil, fmt.Errorf("line %d: the parent_id must be empty or a positive integer", line)
+		}
+		if _, ok := nodes[id]; ok {
+			return nil, fmt.Errorf("line %d: the id %d is used more than once", line, id)
+		}
+		node := &Node{ID: id, Name: csvField(row, nameCol)}
+		nodes[id] = node
+		parentIDs[i] = parentID
+		order[i] = node
+	}
+
+	trees := []*Node{}
+	for i, node := range order {
+		if parentIDs[i] == 0 {
+			trees = append(trees, node)
+			continue
+		}
+		parent, ok := nodes[parentIDs[i]]
+		if !ok {
+			return nil, fmt.Errorf(
+				"line %d: the parent_id %d is not the id of any of the rows", i+2, parentIDs[i])
+		}
+		parent.Children = append(parent.Children, node)
+	}
+
+	// every node has been attached to a parent, so nodes which can't be
+	// reached from the top nodes must be part of a cycle.
+	if countNodes(trees) != len(nodes) {
+		return nil, errCSVCycle
+	}
+	return trees, nil
+}
+
+func decodeCSVPaths(rows [][]string, pathCol int) ([]*Node, error) {
+	top := &Node{}
+	for i, row := range rows {
+		names := SplitPath(csvField(row, pathCol))
+		if len(names) == 0 {
+			return nil, fmt.Errorf("line %d: the path must not be empty", i+2)
+		}
+		parent := top
+		for _, name := range names {
+			parent = childByName(parent, name)
+		}
+	}
+	if top.Children == nil {
+		return []*Node{}, nil
+	}
+	return top.Children, nil
+}
+
+// childByName returns the child of the node with the given name, which is
+// added if the node has no such child.
+func childByName(node *Node, name string) *Node {
+	for _, child := range node.Children {
+		if child.Name == name {
+			return child
+		}
+	}
+	child := &Node{Name: name}
+	node.Children = append(node.Children, child)
+	return child
+}
+
+func countNodes(trees []*Node) int {
+	count := len(trees)
+	for _, tree := range trees {
+		count += countNodes(tree.Children)
+	}
+	return count
+}
+
+func csvField(row []string, col int) string {
+	if col >= len(row) {
+		return ""
+	}
+	return row[col]
+}
+
+// csvInt parses the field as an integer, where an empty field is 0
+func csvInt(row []string, col int) (int, error) {
+	field := csvField(row, col)
+	if field == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(field)
+}
+
+// encodeCSV writes the trees as rows of `id`, `parent_id` and `name`, which
+// can be imported again. Parents are always written before their children, and
+// the `parent_id` of the top nodes is left empty, such that subtrees are
+// imported as trees of their own.
+func encodeCSV(w io.Writer, trees []*Node) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"id", "parent_id", "name"}); err != nil {
+		return err
+	}
+	if err := writeCSVRows(cw, trees, ""); err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeCSVRows(cw *csv.Writer, nodes []*Node, parentID string) error {
+	for _, node := range nodes {
+		id := strconv.Itoa(node.ID)
+		if err := cw.Write([]string{id, parentID, node.Name}); err != nil {
+			return err
+		}
+		if err := writeCSVRows(cw, node.Children, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
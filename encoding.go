@@ -0,0 +1,591 @@
+package amznode
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Encoder encodes response bodies in one format. Encoders are made available
+// to the servers with `RegisterEncoder`.
+type Encoder struct {
+	// Format is the name used to select the encoder with the `format` query
+	// parameter
+	Format string
+	// MediaTypes are matched against the Accept header. The first is used as
+	// the Content-Type of the response.
+	MediaTypes []string
+	// Encode encodes a response, which is a node, a list of nodes or any
+	// of the response types of the package. It returns `ErrNotEncodable`
+	// when the value has no representation in the format, which is
+	// responded as `406 Not Acceptable`.
+	Encode func(w io.Writer, v interface{}) error
+	// TreesOnly is set by the formats which can only represent nodes, like
+	// the graph formats. Handlers which change the storage and respond
+	// other values, like attributes and policies, reject these formats
+	// before changing anything.
+	TreesOnly bool
+	// EncodeNode is set by the formats which can stream nodes one at a
+	// time. Endpoints which can read a lot of nodes walks the storage when
+	// it is set, instead of reading the whole trees before responding.
+	EncodeNode func(w io.Writer, node *Node) error
+}
+
+var jsonEncoder = &Encoder{Format: "json", MediaTypes: []string{"application/json"}, Encode: encodeJSON}
+
+// encoders are the formats responses can be encoded in. When several encoders
+// match an accepted media type the first one is used, and the first encoder
+// is used when the request doesn't tell what it accepts. The list is replaced
+// rather than changed when an encoder is registered, so it can be read
+// without holding the lock once it has been loaded.
+var (
+	encodersMu sync.RWMutex
+	encoders   = []*Encoder{
+		jsonEncoder,
+		{Format: "yaml", MediaTypes: []string{"application/yaml", "application/x-yaml", "text/yaml"}, Encode: encodeYAML},
+		{Format: "xml", MediaTypes: []string{"application/xml", "text/xml"}, Encode: encodeXML},
+		{Format: "csv", MediaTypes: []string{csvContentType}, Encode: encodeCSVValue, TreesOnly: true},
+		{Format: "text", MediaTypes: []string{"text/plain"}, Encode: encodeText, TreesOnly: true},
+		{Format: "dot", MediaTypes: []string{"text/vnd.graphviz"}, Encode: encodeDOT, TreesOnly: true},
+		{Format: "mermaid", MediaTypes: []string{"text/vnd.mermaid"}, Encode: encodeMermaid, TreesOnly: true},
+		{Format: "ndjson", MediaTypes: []string{"application/x-ndjson"}, Encode: encodeNDJSON, EncodeNode: encodeJSONLine},
+	}
+)
+
+// RegisterEncoder makes an encoder available to every server. An encoder with
+// the format of a registered encoder replaces it, other encoders are matched
+// against the Accept header after the registered ones. It panics if the
+// format, the media types or the Encode function is missing.
+func RegisterEncoder(enc Encoder) {
+	if enc.Format == "" || len(enc.MediaTypes) == 0 || enc.Encode == nil {
+		panic("amznode: RegisterEncoder needs a format, a media type and an Encode function")
+	}
+	encodersMu.Lock()
+	defer encodersMu.Unlock()
+	registered := make([]*Encoder, 0, len(encoders)+1)
+	replaced := false
+	for _, e := range encoders {
+		if e.Format == enc.Format {
+			e, replaced = &enc, true
+		}
+		registered = append(registered, e)
+	}
+	if !replaced {
+		registered = append(registered, &enc)
+	}
+	encoders = registered
+}
+
+// registeredEncoders returns the encoders which are registered right now
+func registeredEncoders() []*Encoder {
+	encodersMu.RLock()
+	defer encodersMu.RUnlock()
+	return encoders
+}
+
+// ErrNotEncodable is returned by encoders when the value has no representation
+// in their format
+var ErrNotEncodable = errors.New("the response can't be encoded in the requested format")
+
+// errInvalidFormat and errNotAcceptable are returned by negotiate. Their
+// messages list the registered encoders.
+type errInvalidFormat struct{ encoders []*Encoder }
+type errNotAcceptable struct{ encoders []*Encoder }
+
+func (err errInvalidFormat) Error() string {
+	names := make([]string, len(err.encoders))
+	for i, enc := range err.encoders {
+		names[i] = "'" + enc.Format + "'"
+	}
+	if len(names) == 1 {
+		return "format must be " + names[0]
+	}
+	return "format must be one of " +
+		strings.Join(names[:len(names)-1], ", ") + " or " + names[len(names)-1]
+}
+
+func (err errNotAcceptable) Error() string {
+	names := []string{}
+	seen := map[string]bool{}
+	for _, enc := range err.encoders {
+		for _, mediaType := range enc.MediaTypes {
+			if !seen[mediaType] {
+				seen[mediaType] = true
+				names = append(names, mediaType)
+			}
+		}
+	}
+	return "none of the accepted media types are supported, use one of " +
+		strings.Join(names, ", ")
+}
+
+func (enc *Encoder) contentType() string {
+	return enc.MediaTypes[0] + "; charset=utf-8"
+}
+
+// negotiate finds the encoder for the response to the request. The `format`
+// query parameter takes precedence over the Accept header.
+func negotiate(r *http.Request) (*Encoder, error) {
+	encoders := registeredEncoders()
+	if format := r.URL.Query().Get("format"); format != "" {
+		for _, enc := range encoders {
+			if enc.Format == format {
+				return enc, nil
+			}
+		}
+		return nil, errInvalidFormat{encoders}
+	}
+
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return encoders[0], nil
+	}
+	for _, mediaRange := range parseAccept(accept) {
+		if enc := matchEncoder(encoders, mediaRange); enc != nil {
+			return enc, nil
+		}
+	}
+	return nil, errNotAcceptable{encoders}
+}
+
+// matchEncoder returns the first encoder with a media type within the media
+// range, or nil if there is none. Ranges like `text/*` prefer the plain type
+// of the range, such that a client asking for any text gets plain text rather
+// than whichever text format happens to be registered first.
+func matchEncoder(encoders []*Encoder, mediaRange string) *Encoder {
+	if strings.HasSuffix(mediaRange, "/*") && mediaRange != "*/*" {
+		plain := strings.TrimSuffix(mediaRange, "*") + "plain"
+		for _, enc := range encoders {
+			if enc.matches(plain) {
+				return enc
+			}
+		}
+	}
+	for _, enc := range encoders {
+		if enc.matches(mediaRange) {
+			return enc
+		}
+	}
+	return nil
+}
+
+// matches returns true if one of the media types of the encoder is within the
+// media range, like `text/*`
+func (enc *Encoder) matches(mediaRange string) bool {
+	for _, mediaType := range enc.MediaTypes {
+		switch {
+		case mediaRange == "*/*", mediaRange == mediaType:
+			return true
+		case strings.HasSuffix(mediaRange, "/*") &&
+			strings.HasPrefix(mediaType, strings.TrimSuffix(mediaRange, "*")):
+			return true
+		}
+	}
+	return false
+}
+
+// parseAccept returns the media ranges of an Accept header ordered by their
+// quality. Ranges with a quality of 0 are left out, as they are not
+// acceptable.
+func parseAccept(accept string) []string {
+	type mediaRange struct {
+		mediaType string
+		q         float64
+	}
+	ranges := []mediaRange{}
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if qStr, ok := params["q"]; ok {
+			q, err = strconv.ParseFloat(qStr, 64)
+			if err != nil {
+				continue
+			}
+		}
+		if q > 0 {
+			ranges = append(ranges, mediaRange{mediaType: mediaType, q: q})
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].q > ranges[j].q
+	})
+
+	mediaTypes := make([]string, len(ranges))
+	for i, r := range ranges {
+		mediaTypes[i] = r.mediaType
+	}
+	return mediaTypes
+}
+
+type ctxKey int
+
+const encoderCtxKey ctxKey = iota
+
+// negotiateMiddleware negotiates the format of the response before the
+// request is handled, such that requests for unknown formats are rejected
+// before anything is changed. Whether the format can encode the response is
+// only known by the handler, see `checkEncodable`.
+func negotiateMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		enc, err := negotiate(r)
+		if _, ok := err.(errInvalidFormat); ok {
+			respondErr(w, r, err, http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			respondErr(w, r, err, http.StatusNotAcceptable)
+			return
+		}
+		ctx := context.WithValue(r.Context(), encoderCtxKey, enc)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requestEncoder returns the encoder negotiated for the request, which is the
+// json encoder if none has been negotiated.
+func requestEncoder(r *http.Request) *Encoder {
+	if enc, ok := r.Context().Value(encoderCtxKey).(*Encoder); ok {
+		return enc
+	}
+	return jsonEncoder
+}
+
+func encodeJSON(w io.Writer, v interface{}) error {
+	return json.NewEncoder(w).Encode(v)
+}
+
+// encodeNDJSON writes the nodes of trees as a line of json each, parents before
+// their children. Other values are written as a single line.
+func encodeNDJSON(w io.Writer, v interface{}) error {
+	if results, ok := v.([]*SearchResult); ok {
+		for _, result := range results {
+			if err := encodeJSON(w, result); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if trash, ok := v.([]*TrashedNode); ok {
+		for _, node := range trash {
+			if err := encodeJSON(w, node); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	trees, err := treesOf(v)
+	if err != nil {
+		return encodeJSON(w, v)
+	}
+	for level := trees; len(level) > 0; {
+		next := []*Node{}
+		for _, node := range level {
+			if err := encodeJSONLine(w, node); err != nil {
+				return err
+			}
+			next = append(next, node.Children...)
+		}
+		level = next
+	}
+	return nil
+}
+
+// encodeJSONLine writes the node without its children as a line of json
+func encodeJSONLine(w io.Writer, node *Node) error {
+	n := *node
+	n.Children = nil
+	return encodeJSON(w, &n)
+}
+
+func encodeYAML(w io.Writer, v interface{}) error {
+	return yaml.NewEncoder(w).Encode(v)
+}
+
+// xmlNode is the xml representation of a node, which encodes the attributes
+// of the node as elements holding the json encoding of their values, as xml
+// has no representation of arbitrary values.
+type xmlNode struct {
+	*Node
+	Attributes *xmlAttributes `xml:"attributes,omitempty"`
+	Children   []*xmlNode     `xml:"node"`
+}
+
+// xmlAttribute is the xml representation of an attribute
+type xmlAttribute struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+// xmlAttributes is the xml representation of the attributes of a node
+type xmlAttributes struct {
+	XMLName    xml.Name       `xml:"attributes"`
+	Attributes []xmlAttribute `xml:"attribute"`
+}
+
+// xmlNodes is the xml representation of a list of nodes
+type xmlNodes struct {
+	XMLName xml.Name   `xml:"nodes"`
+	Nodes   []*xmlNode `xml:"node"`
+}
+
+// xmlSearchResults is the xml representation of a list of search results
+type xmlSearchResults struct {
+	XMLName xml.Name          `xml:"results"`
+	Results []xmlSearchResult `xml:"result"`
+}
+
+type xmlSearchResult struct {
+	xmlNode
+	Path string `xml:"path,attr"`
+}
+
+// xmlTrashedNodes is the xml representation of the trash
+type xmlTrashedNodes struct {
+	XMLName xml.Name         `xml:"trash"`
+	Nodes   []xmlTrashedNode `xml:"node"`
+}
+
+type xmlTrashedNode struct {
+	xmlNode
+	DeletedAt time.Time `xml:"deleted_at,attr"`
+}
+
+// xmlAncestors is the xml representation of the ancestors of a node
+type xmlAncestors struct {
+	XMLName   xml.Name   `xml:"ancestors"`
+	Path      string     `xml:"path,attr"`
+	Ancestors []*xmlNode `xml:"node"`
+}
+
+func encodeXML(w io.Writer, v interface{}) error {
+	switch v := v.(type) {
+	case []*Node:
+		nodes, err := toXMLNodes(v)
+		if err != nil {
+			return err
+		}
+		return writeXML(w, xmlNodes{Nodes: nodes}, "")
+	case []*SearchResult:
+		results := make([]xmlSearchResult, len(v))
+		for i, result := range v {
+			node, err := toXMLNode(&result.Node)
+			if err != nil {
+				return err
+			}
+			results[i] = xmlSearchResult{xmlNode: *node, Path: result.Path}
+		}
+		return writeXML(w, xmlSearchResults{Results: results}, "")
+	case []*TrashedNode:
+		nodes := make([]xmlTrashedNode, len(v))
+		for i, trashed := range v {
+			node, err := toXMLNode(&trashed.Node)
+			if err != nil {
+				return err
+			}
+			nodes[i] = xmlTrashedNode{xmlNode: *node, DeletedAt: trashed.DeletedAt}
+		}
+		return writeXML(w, xmlTrashedNodes{Nodes: nodes}, "")
+	case *Node:
+		node, err := toXMLNode(v)
+		if err != nil {
+			return err
+		}
+		return writeXML(w, node, "node")
+	case Node:
+		return encodeXML(w, &v)
+	case AncestorsResponse:
+		ancestors, err := toXMLNodes(v.Ancestors)
+		if err != nil {
+			return err
+		}
+		return writeXML(w, xmlAncestors{Path: v.Path, Ancestors: ancestors}, "")
+	case map[string]interface{}:
+		attrs, err := toXMLAttributes(v)
+		if err != nil {
+			return err
+		}
+		return writeXML(w, xmlAttributes{Attributes: attrs}, "")
+	case ErrorResponse:
+		return writeXML(w, v, "error")
+	case Policy:
+		return writeXML(w, v, "policy")
+	}
+	return writeXML(w, v, "")
+}
+
+// toXMLNode converts the node and its decendants to their xml representation
+func toXMLNode(node *Node) (*xmlNode, error) {
+	attrs, err := toXMLAttributes(node.Attributes)
+	if err != nil {
+		return nil, err
+	}
+	children, err := toXMLNodes(node.Children)
+	if err != nil {
+		return nil, err
+	}
+	xmlNode := &xmlNode{Node: node, Children: children}
+	if len(attrs) > 0 {
+		xmlNode.Attributes = &xmlAttributes{Attributes: attrs}
+	}
+	return xmlNode, nil
+}
+
+func toXMLNodes(nodes []*Node) ([]*xmlNode, error) {
+	xmlNodes := make([]*xmlNode, len(nodes))
+	for i, node := range nodes {
+		xmlNode, err := toXMLNode(node)
+		if err != nil {
+			return nil, err
+		}
+		xmlNodes[i] = xmlNode
+	}
+	return xmlNodes, nil
+}
+
+// toXMLAttributes converts attributes to their xml representation, ordered by
+// key
+func toXMLAttributes(attrs map[string]interface{}) ([]xmlAttribute, error) {
+	keys := make([]string, 0, len(attrs))
+	for key := range attrs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	xmlAttrs := make([]xmlAttribute, len(keys))
+	for i, key := range keys {
+		value, err := json.Marshal(attrs[key])
+		if err != nil {
+			return nil, err
+		}
+		xmlAttrs[i] = xmlAttribute{Key: key, Value: string(value)}
+	}
+	return xmlAttrs, nil
+}
+
+// writeXML writes v as an xml document with the root element `name`, or the
+// default name of v if `name` is empty.
+func writeXML(w io.Writer, v interface{}, name string) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	var err error
+	if name == "" {
+		err = enc.Encode(v)
+	} else {
+		err = enc.EncodeElement(v, xml.StartElement{Name: xml.Name{Local: name}})
+	}
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+func encodeCSVValue(w io.Writer, v interface{}) error {
+	if errResp, ok := v.(ErrorResponse); ok {
+		cw := csv.NewWriter(w)
+		cw.WriteAll([][]string{{"error"}, {errResp.Error}})
+		return cw.Error()
+	}
+	trees, err := treesOf(v)
+	if err != nil {
+		return err
+	}
+	return encodeCSV(w, trees)
+}
+
+func encodeText(w io.Writer, v interface{}) error {
+	if errResp, ok := v.(ErrorResponse); ok {
+		_, err := fmt.Fprintf(w, "error: %s\n", errResp.Error)
+		return err
+	}
+	if results, ok := v.([]*SearchResult); ok {
+		for _, result := range results {
+			if _, err := fmt.Fprintln(w, result.Path); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	trees, err := treesOf(v)
+	if err != nil {
+		return err
+	}
+	return renderText(w, trees)
+}
+
+func encodeDOT(w io.Writer, v interface{}) error {
+	trees, err := treesOf(v)
+	if err != nil {
+		return err
+	}
+	return renderDOT(w, trees)
+}
+
+func encodeMermaid(w io.Writer, v interface{}) error {
+	trees, err := treesOf(v)
+	if err != nil {
+		return err
+	}
+	return renderMermaid(w, trees)
+}
+
+// treesOf returns the trees of nodes in a response value, for the formats
+// which can only represent trees. The ancestors of a node are returned as a
+// single branch from the root down to the node, and search results as the
+// found nodes.
+func treesOf(v interface{}) ([]*Node, error) {
+	switch v := v.(type) {
+	case []*Node:
+		return v, nil
+	case []*SearchResult:
+		nodes := make([]*Node, len(v))
+		for i, result := range v {
+			nodes[i] = &result.Node
+		}
+		return nodes, nil
+	case []*TrashedNode:
+		nodes := make([]*Node, len(v))
+		for i, trashed := range v {
+			nodes[i] = &trashed.Node
+		}
+		return nodes, nil
+	case *Node:
+		return []*Node{v}, nil
+	case AncestorsResponse:
+		var top, parent *Node
+		for _, ancestor := range v.Ancestors {
+			node := *ancestor
+			node.Children = nil
+			if parent == nil {
+				top = &node
+			} else {
+				parent.Children = []*Node{&node}
+			}
+			parent = &node
+		}
+		if top == nil {
+			return []*Node{}, nil
+		}
+		return []*Node{top}, nil
+	default:
+		return nil, ErrNotEncodable
+	}
+}
@@ -19,6 +19,20 @@ func (err *ErrNotFound) Error() string {
 	return fmt.Sprintf("Could not find node with ID %d", err.ID)
 }
 
+// ErrPathNotFound is returned when a node could not be found by its path
+type ErrPathNotFound struct {
+	Path string
+}
+
+// NewErrPathNotFound returns a new ErrPathNotFound
+func NewErrPathNotFound(path string) *ErrPathNotFound {
+	return &ErrPathNotFound{Path: path}
+}
+
+func (err *ErrPathNotFound) Error() string {
+	return fmt.Sprintf("Could not find node with path '%s'", err.Path)
+}
+
 // ErrNameTaken is returned when a chil node has a sibling with a conflicting
 // name.
 type ErrNameTaken struct {
@@ -35,6 +49,21 @@ func (err *ErrNameTaken) Error() string {
 	return fmt.Sprintf("the name '%s' has already been taken under the parent with id #%d", err.Name, err.ParentID)
 }
 
+// ErrIDTaken is returned when a node is created with an id which is already
+// in use.
+type ErrIDTaken struct {
+	ID int
+}
+
+// NewErrIDTaken instantiates a ErrIDTaken error
+func NewErrIDTaken(id int) *ErrIDTaken {
+	return &ErrIDTaken{ID: id}
+}
+
+func (err *ErrIDTaken) Error() string {
+	return fmt.Sprintf("the id %d has already been taken", err.ID)
+}
+
 // NewErrNodeIsDecendant instantiates a ErrNodeIsDecendant error
 func NewErrNodeIsDecendant(id, decendantID int) *ErrNodeIsDecendant {
 	return &ErrNodeIsDecendant{ID: id, DecendantID: decendantID}
@@ -54,17 +83,114 @@ func (err *ErrNodeIsDecendant) Error() string {
 	)
 }
 
+// ErrSchemaViolation is returned when a node would break the rules of the
+// `TypeSchema` of the storage, e.g. by being placed under a parent which does
+// not allow its type.
+type ErrSchemaViolation struct {
+	Reason string
+}
+
+// NewErrSchemaViolation instantiates a ErrSchemaViolation error
+func NewErrSchemaViolation(reason string) *ErrSchemaViolation {
+	return &ErrSchemaViolation{Reason: reason}
+}
+
+func (err *ErrSchemaViolation) Error() string {
+	return err.Reason
+}
+
+// ErrPolicyViolation is returned when a change would make the tree with the
+// root `RootID` break its `Policy`. `Limit` is either "height" or "children".
+type ErrPolicyViolation struct {
+	RootID int
+	Policy Policy
+	Limit  string
+}
+
+// NewErrPolicyViolation instantiates a ErrPolicyViolation error
+func NewErrPolicyViolation(rootID int, policy Policy, limit string) *ErrPolicyViolation {
+	return &ErrPolicyViolation{RootID: rootID, Policy: policy, Limit: limit}
+}
+
+func (err *ErrPolicyViolation) Error() string {
+	if err.Limit == "height" {
+		return fmt.Sprintf(
+			"the tree with root id %d allows a max height of %d",
+			err.RootID, err.Policy.MaxHeight,
+		)
+	}
+	return fmt.Sprintf(
+		"the tree with root id %d allows at most %d children per node",
+		err.RootID, err.Policy.MaxChildren,
+	)
+}
+
+// ErrNotRoot is returned when a node is used as a root node, but has a
+// parent.
+type ErrNotRoot struct {
+	ID int
+}
+
+// NewErrNotRoot instantiates a ErrNotRoot error
+func NewErrNotRoot(id int) *ErrNotRoot {
+	return &ErrNotRoot{ID: id}
+}
+
+func (err *ErrNotRoot) Error() string {
+	return fmt.Sprintf("the node with id %d is not a root node", err.ID)
+}
+
+// ErrNotSibling is returned when a node is placed next to a node which is not
+// one of its siblings, i.e. not a child of the node with `ParentID`.
+type ErrNotSibling struct {
+	ID       int
+	ParentID int
+}
+
+// NewErrNotSibling instantiates a ErrNotSibling error
+func NewErrNotSibling(id, parentID int) *ErrNotSibling {
+	return &ErrNotSibling{ID: id, ParentID: parentID}
+}
+
+func (err *ErrNotSibling) Error() string {
+	if err.ParentID == 0 {
+		return fmt.Sprintf("the node with id %d is not a sibling, as it is not a root node", err.ID)
+	}
+	return fmt.Sprintf(
+		"the node with id %d is not a sibling, as it is not a child of the node with id %d",
+		err.ID, err.ParentID,
+	)
+}
+
 func handleStorageError(w http.ResponseWriter, r *http.Request, err error) {
 	switch err.(type) {
 	case *ErrNotFound:
 		respondErr(w, r, err, http.StatusNotFound)
 		return
+	case *ErrPathNotFound:
+		respondErr(w, r, err, http.StatusNotFound)
+		return
 	case *ErrNameTaken:
 		respondErr(w, r, err, http.StatusBadRequest)
 		return
+	case *ErrIDTaken:
+		respondErr(w, r, err, http.StatusBadRequest)
+		return
 	case *ErrNodeIsDecendant:
 		respondErr(w, r, err, http.StatusBadRequest)
 		return
+	case *ErrSchemaViolation:
+		respondErr(w, r, err, http.StatusBadRequest)
+		return
+	case *ErrPolicyViolation:
+		respondErr(w, r, err, http.StatusBadRequest)
+		return
+	case *ErrNotRoot:
+		respondErr(w, r, err, http.StatusBadRequest)
+		return
+	case *ErrNotSibling:
+		respondErr(w, r, err, http.StatusBadRequest)
+		return
 	default:
 		respondErr(w, r, err, http.StatusInternalServerError)
 		return
@@ -21,6 +21,20 @@ func TestNewErrNotFound(t *testing.T) {
 	}
 }
 
+func TestNewErrPathNotFound(t *testing.T) {
+	expectedPath := "root/child"
+	expectedMsg := fmt.Sprintf("Could not find node with path '%s'", expectedPath)
+
+	err := amznode.NewErrPathNotFound("root/child")
+
+	if err.Path != expectedPath {
+		t.Errorf("expected path '%s' got '%s'", expectedPath, err.Path)
+	}
+	if errMsg := err.Error(); errMsg != expectedMsg {
+		t.Errorf("unexpected error message: expected '%s' got '%s'", expectedMsg, errMsg)
+	}
+}
+
 func TestNewErrNameTaken(t *testing.T) {
 	expectedName := "test"
 	expectedParentID := 42
@@ -42,6 +56,20 @@ func TestNewErrNameTaken(t *testing.T) {
 	}
 }
 
+func TestNewErrIDTaken(t *testing.T) {
+	expectedID := 42
+	expectedMsg := fmt.Sprintf("the id %d has already been taken", expectedID)
+
+	err := amznode.NewErrIDTaken(42)
+
+	if err.ID != expectedID {
+		t.Errorf("expected id %d got %d", expectedID, err.ID)
+	}
+	if errMsg := err.Error(); errMsg != expectedMsg {
+		t.Errorf("unexpected error message: expected '%s' got '%s'", expectedMsg, errMsg)
+	}
+}
+
 func TestNewNodeIsDecendant(t *testing.T) {
 	expectedID := 0
 	expectedDecendantID := 1
@@ -62,3 +90,76 @@ func TestNewNodeIsDecendant(t *testing.T) {
 		t.Errorf("unexpected error message: expected '%s' got '%s'", expectedMsg, errMsg)
 	}
 }
+
+func TestNewErrSchemaViolation(t *testing.T) {
+	expectedReason := "a node of type 'team' can't be a root node"
+
+	err := amznode.NewErrSchemaViolation(expectedReason)
+
+	if err.Reason != expectedReason {
+		t.Errorf("expected reason '%s' got '%s'", expectedReason, err.Reason)
+	}
+	if errMsg := err.Error(); errMsg != expectedReason {
+		t.Errorf("unexpected error message: expected '%s' got '%s'", expectedReason, errMsg)
+	}
+}
+
+func TestNewErrPolicyViolation(t *testing.T) {
+	policy := amznode.Policy{MaxHeight: 3, MaxChildren: 10}
+	cases := map[string]string{
+		"height":   "the tree with root id 42 allows a max height of 3",
+		"children": "the tree with root id 42 allows at most 10 children per node",
+	}
+
+	for limit, expectedMsg := range cases {
+		err := amznode.NewErrPolicyViolation(42, policy, limit)
+
+		if err.RootID != 42 {
+			t.Errorf("expected root id %d got %d", 42, err.RootID)
+		}
+		if err.Limit != limit {
+			t.Errorf("expected limit '%s' got '%s'", limit, err.Limit)
+		}
+		if errMsg := err.Error(); errMsg != expectedMsg {
+			t.Errorf("unexpected error message: expected '%s' got '%s'", expectedMsg, errMsg)
+		}
+	}
+}
+
+func TestNewErrNotRoot(t *testing.T) {
+	expectedID := 42
+	expectedMsg := fmt.Sprintf("the node with id %d is not a root node", expectedID)
+
+	err := amznode.NewErrNotRoot(42)
+
+	if err.ID != expectedID {
+		t.Errorf("expected id %d got %d", expectedID, err.ID)
+	}
+	if errMsg := err.Error(); errMsg != expectedMsg {
+		t.Errorf("unexpected error message: expected '%s' got '%s'", expectedMsg, errMsg)
+	}
+}
+
+func TestNewErrNotSibling(t *testing.T) {
+	expectedID, expectedParentID := 42, 7
+	expectedMsg := fmt.Sprintf(
+		"the node with id %d is not a sibling, as it is not a child of the node with id %d",
+		expectedID, expectedParentID)
+
+	err := amznode.NewErrNotSibling(42, 7)
+
+	if err.ID != expectedID {
+		t.Errorf("expected id %d got %d", expectedID, err.ID)
+	}
+	if err.ParentID != expectedParentID {
+		t.Errorf("expected parent id %d got %d", expectedParentID, err.ParentID)
+	}
+	if errMsg := err.Error(); errMsg != expectedMsg {
+		t.Errorf("unexpected error message: expected '%s' got '%s'", expectedMsg, errMsg)
+	}
+
+	expectedMsg = "the node with id 42 is not a sibling, as it is not a root node"
+	if errMsg := amznode.NewErrNotSibling(42, 0).Error(); errMsg != expectedMsg {
+		t.Errorf("unexpected error message: expected '%s' got '%s'", expectedMsg, errMsg)
+	}
+}
@@ -0,0 +1,13 @@
+package amznode
+
+// RestoreEncoders returns a function which unregisters the encoders registered
+// after it was called, such that tests can register encoders without
+// affecting other tests.
+func RestoreEncoders() func() {
+	registered := registeredEncoders()
+	return func() {
+		encodersMu.Lock()
+		defer encodersMu.Unlock()
+		encoders = registered
+	}
+}
@@ -6,4 +6,5 @@ require (
 	github.com/go-chi/chi v4.0.2+incompatible
 	github.com/lib/pq v1.0.0
 	github.com/stretchr/testify v1.3.0
+	gopkg.in/yaml.v2 v2.2.2
 )
@@ -2,6 +2,8 @@ package amznode
 
 import (
 	"net/http"
+
+	"github.com/go-chi/chi"
 )
 
 func (s *server) createHandler() http.HandlerFunc {
@@ -16,8 +18,13 @@ func (s *server) createHandler() http.HandlerFunc {
 			respondErr(w, r, err, http.StatusBadRequest)
 			return
 		}
+		opts, err := urlParamCreateOptions(r)
+		if err != nil {
+			respondErr(w, r, err, http.StatusBadRequest)
+			return
+		}
 
-		child, err := s.storage.Create(childName, parentID)
+		child, err := s.storage.Create(childName, parentID, opts)
 		if err != nil {
 			handleStorageError(w, r, err)
 			return
@@ -27,6 +34,99 @@ func (s *server) createHandler() http.HandlerFunc {
 	}
 }
 
+func (s *server) copyHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := urlParamID(r, "id")
+		if err != nil {
+			respondErr(w, r, err, http.StatusBadRequest)
+			return
+		}
+		parentID, err := urlParamID(r, "parentID")
+		if err != nil {
+			respondErr(w, r, err, http.StatusBadRequest)
+			return
+		}
+		name := ""
+		if r.URL.Query().Get("name") != "" {
+			name, err = urlParamName(r, "name")
+			if err != nil {
+				respondErr(w, r, err, http.StatusBadRequest)
+				return
+			}
+		}
+
+		node, err := s.storage.Copy(id, parentID, name)
+		if err != nil {
+			handleStorageError(w, r, err)
+			return
+		}
+
+		respond(w, r, node, http.StatusCreated)
+	}
+}
+
+func (s *server) importHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		parentID, err := urlParamID(r, "parentID")
+		if err != nil {
+			respondErr(w, r, err, http.StatusBadRequest)
+			return
+		}
+		preserveIDs, err := urlParamBool(r, "preserveIDs")
+		if err != nil {
+			respondErr(w, r, err, http.StatusBadRequest)
+			return
+		}
+		var (
+			trees  []*Node
+			single bool
+		)
+		if hasContentType(r, csvContentType) {
+			trees, err = decodeCSVTrees(r.Body)
+		} else {
+			trees, single, err = decodeTrees(r.Body)
+		}
+		if err != nil {
+			respondErr(w, r, err, http.StatusBadRequest)
+			return
+		}
+		if err := validateTrees(trees); err != nil {
+			respondErr(w, r, err, http.StatusBadRequest)
+			return
+		}
+
+		opts := ImportOptions{PreserveIDs: preserveIDs}
+		nodes, err := s.storage.Import(parentID, trees, opts)
+		if err != nil {
+			handleStorageError(w, r, err)
+			return
+		}
+
+		if single {
+			respond(w, r, nodes[0], http.StatusCreated)
+			return
+		}
+		respond(w, r, nodes, http.StatusCreated)
+	}
+}
+
+func (s *server) exportHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if streams(r) {
+			respondWalk(w, r, func(f func(*Node) error) error {
+				return s.storage.WalkRootsRec(-1, f)
+			})
+			return
+		}
+		roots, err := s.storage.GetRootsRec(-1)
+		if err != nil {
+			handleStorageError(w, r, err)
+			return
+		}
+		respondStream(w, r, roots, http.StatusOK)
+	}
+}
+
 func (s *server) getHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		id, err := urlParamID(r, "id")
@@ -34,9 +134,44 @@ func (s *server) getHandler() http.HandlerFunc {
 			respondErr(w, r, err, http.StatusBadRequest)
 			return
 		}
+		depth, err := urlParamDepth(r)
+		if err != nil {
+			respondErr(w, r, err, http.StatusBadRequest)
+			return
+		}
+
+		opts, paginated, err := urlParamListOptions(r)
+		if err != nil {
+			respondErr(w, r, err, http.StatusBadRequest)
+			return
+		}
+
+		if id != 0 && paginated {
+			respondErr(w, r, errNotPaginated, http.StatusBadRequest)
+			return
+		}
+		if paginated {
+			nodes, err := s.getPage(w, r, 0, depth, opts)
+			if err != nil {
+				handleStorageError(w, r, err)
+				return
+			}
+			respond(w, r, nodes, http.StatusOK)
+			return
+		}
+
+		if streams(r) {
+			respondWalk(w, r, func(f func(*Node) error) error {
+				if id == 0 {
+					return s.storage.WalkRootsRec(depth, f)
+				}
+				return s.storage.WalkRec(id, depth, f)
+			})
+			return
+		}
 
 		if id == 0 {
-			nodes, err := s.storage.GetRoots()
+			nodes, err := s.storage.GetRootsRec(depth)
 			if err != nil {
 				handleStorageError(w, r, err)
 				return
@@ -45,7 +180,7 @@ func (s *server) getHandler() http.HandlerFunc {
 			return
 		}
 
-		node, err := s.storage.Get(id)
+		node, err := s.storage.GetRec(id, depth)
 		if err != nil {
 			handleStorageError(w, r, err)
 			return
@@ -55,6 +190,235 @@ func (s *server) getHandler() http.HandlerFunc {
 	}
 }
 
+func (s *server) getChildrenHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := urlParamID(r, "id")
+		if err != nil {
+			respondErr(w, r, err, http.StatusBadRequest)
+			return
+		}
+		opts, _, err := urlParamListOptions(r)
+		if err != nil {
+			respondErr(w, r, err, http.StatusBadRequest)
+			return
+		}
+
+		nodes, err := s.getPage(w, r, id, 0, opts)
+		if err != nil {
+			handleStorageError(w, r, err)
+			return
+		}
+
+		respond(w, r, nodes, http.StatusOK)
+	}
+}
+
+func (s *server) searchHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q, opts, err := urlParamSearch(r)
+		if err != nil {
+			respondErr(w, r, err, http.StatusBadRequest)
+			return
+		}
+
+		results, err := s.storage.Search(q, opts)
+		if err != nil {
+			handleStorageError(w, r, err)
+			return
+		}
+
+		respond(w, r, results, http.StatusOK)
+	}
+}
+
+func (s *server) getAncestorsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := urlParamID(r, "id")
+		if err != nil {
+			respondErr(w, r, err, http.StatusBadRequest)
+			return
+		}
+
+		ancestors, err := s.storage.GetAncestors(id)
+		if err != nil {
+			handleStorageError(w, r, err)
+			return
+		}
+
+		names := make([]string, len(ancestors))
+		for i, ancestor := range ancestors {
+			names[i] = ancestor.Name
+		}
+
+		respond(w, r, AncestorsResponse{
+			Path:      JoinPath(names...),
+			Ancestors: ancestors,
+		}, http.StatusOK)
+	}
+}
+
+func (s *server) getAttributesHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := urlParamID(r, "id")
+		if err != nil {
+			respondErr(w, r, err, http.StatusBadRequest)
+			return
+		}
+
+		node, err := s.storage.Get(id)
+		if err != nil {
+			handleStorageError(w, r, err)
+			return
+		}
+
+		respondAttributes(w, r, node.Attributes)
+	}
+}
+
+func (s *server) setAttributesHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := urlParamID(r, "id")
+		if err != nil {
+			respondErr(w, r, err, http.StatusBadRequest)
+			return
+		}
+		attrs, err := decodeAttributes(r.Body)
+		if err != nil {
+			respondErr(w, r, err, http.StatusBadRequest)
+			return
+		}
+
+		if !checkEncodable(w, r) {
+			return
+		}
+
+		attrs, err = s.storage.SetAttributes(id, attrs)
+		if err != nil {
+			handleStorageError(w, r, err)
+			return
+		}
+
+		respondAttributes(w, r, attrs)
+	}
+}
+
+func (s *server) patchAttributesHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := urlParamID(r, "id")
+		if err != nil {
+			respondErr(w, r, err, http.StatusBadRequest)
+			return
+		}
+		patch, err := decodeAttributes(r.Body)
+		if err != nil {
+			respondErr(w, r, err, http.StatusBadRequest)
+			return
+		}
+
+		if !checkEncodable(w, r) {
+			return
+		}
+
+		attrs, err := s.storage.PatchAttributes(id, patch)
+		if err != nil {
+			handleStorageError(w, r, err)
+			return
+		}
+
+		respondAttributes(w, r, attrs)
+	}
+}
+
+func (s *server) deleteAttributeHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := urlParamID(r, "id")
+		if err != nil {
+			respondErr(w, r, err, http.StatusBadRequest)
+			return
+		}
+		key := chi.URLParam(r, "key")
+		if key == "" {
+			respondErr(w, r, errInvalidAttributeKey, http.StatusBadRequest)
+			return
+		}
+
+		if !checkEncodable(w, r) {
+			return
+		}
+
+		attrs, err := s.storage.PatchAttributes(id, map[string]interface{}{key: nil})
+		if err != nil {
+			handleStorageError(w, r, err)
+			return
+		}
+
+		respondAttributes(w, r, attrs)
+	}
+}
+
+func (s *server) getPolicyHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := urlParamID(r, "id")
+		if err != nil {
+			respondErr(w, r, err, http.StatusBadRequest)
+			return
+		}
+
+		policy, err := s.storage.GetPolicy(id)
+		if err != nil {
+			handleStorageError(w, r, err)
+			return
+		}
+
+		respond(w, r, policy, http.StatusOK)
+	}
+}
+
+func (s *server) setPolicyHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := urlParamID(r, "id")
+		if err != nil {
+			respondErr(w, r, err, http.StatusBadRequest)
+			return
+		}
+		policy, err := decodePolicy(r.Body)
+		if err != nil {
+			respondErr(w, r, err, http.StatusBadRequest)
+			return
+		}
+
+		if !checkEncodable(w, r) {
+			return
+		}
+
+		err = s.storage.SetPolicy(id, policy)
+		if err != nil {
+			handleStorageError(w, r, err)
+			return
+		}
+
+		respond(w, r, policy, http.StatusOK)
+	}
+}
+
+func (s *server) deletePolicyHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := urlParamID(r, "id")
+		if err != nil {
+			respondErr(w, r, err, http.StatusBadRequest)
+			return
+		}
+
+		err = s.storage.SetPolicy(id, Policy{})
+		if err != nil {
+			handleStorageError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
 func (s *server) changeParentHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		id, err := urlParamID(r, "id")
@@ -67,8 +431,63 @@ func (s *server) changeParentHandler() http.HandlerFunc {
 			respondErr(w, r, err, http.StatusBadRequest)
 			return
 		}
+		placement, err := urlParamPlacement(r)
+		if err != nil {
+			respondErr(w, r, err, http.StatusBadRequest)
+			return
+		}
 
-		err = s.storage.ChangeParent(id, parentID)
+		err = s.storage.ChangeParent(id, parentID, placement)
+		if err != nil {
+			handleStorageError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (s *server) setPositionHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := urlParamID(r, "id")
+		if err != nil {
+			respondErr(w, r, err, http.StatusBadRequest)
+			return
+		}
+		placement, err := urlParamPlacement(r)
+		if err != nil {
+			respondErr(w, r, err, http.StatusBadRequest)
+			return
+		}
+		if placement.IsZero() {
+			respondErr(w, r, errMissingPlacement, http.StatusBadRequest)
+			return
+		}
+
+		err = s.storage.SetPosition(id, placement)
+		if err != nil {
+			handleStorageError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (s *server) renameHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := urlParamID(r, "id")
+		if err != nil {
+			respondErr(w, r, err, http.StatusBadRequest)
+			return
+		}
+		name, err := urlParamName(r, "name")
+		if err != nil {
+			respondErr(w, r, err, http.StatusBadRequest)
+			return
+		}
+
+		err = s.storage.Rename(id, name)
 		if err != nil {
 			handleStorageError(w, r, err)
 			return
@@ -94,3 +513,103 @@ func (s *server) deleteHandler() http.HandlerFunc {
 		w.WriteHeader(http.StatusOK)
 	}
 }
+
+func (s *server) getTrashHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		trash, err := s.storage.GetTrash()
+		if err != nil {
+			handleStorageError(w, r, err)
+			return
+		}
+
+		respond(w, r, trash, http.StatusOK)
+	}
+}
+
+func (s *server) restoreHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := urlParamID(r, "id")
+		if err != nil {
+			respondErr(w, r, err, http.StatusBadRequest)
+			return
+		}
+
+		node, err := s.storage.Restore(id)
+		if err != nil {
+			handleStorageError(w, r, err)
+			return
+		}
+
+		respond(w, r, node, http.StatusOK)
+	}
+}
+
+func (s *server) purgeHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := urlParamID(r, "id")
+		if err != nil {
+			respondErr(w, r, err, http.StatusBadRequest)
+			return
+		}
+
+		if err := s.storage.Purge(id); err != nil {
+			handleStorageError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (s *server) createPathHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		path, err := urlParamPath(r)
+		if err != nil {
+			respondErr(w, r, err, http.StatusBadRequest)
+			return
+		}
+
+		node, err := s.storage.CreatePath(path)
+		if err != nil {
+			handleStorageError(w, r, err)
+			return
+		}
+
+		respond(w, r, node, http.StatusCreated)
+	}
+}
+
+func (s *server) getByPathHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		path, err := urlParamPath(r)
+		if err != nil {
+			respondErr(w, r, err, http.StatusBadRequest)
+			return
+		}
+
+		node, err := s.storage.GetByPath(path)
+		if err != nil {
+			handleStorageError(w, r, err)
+			return
+		}
+
+		respond(w, r, node, http.StatusOK)
+	}
+}
+
+func (s *server) deleteByPathHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		path, err := urlParamPath(r)
+		if err != nil {
+			respondErr(w, r, err, http.StatusBadRequest)
+			return
+		}
+
+		if err := s.storage.DeleteByPath(path); err != nil {
+			handleStorageError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
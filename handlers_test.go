@@ -3,67 +3,87 @@ package amznode_test
 import (
 	"database/sql"
 	"encoding/json"
+	"encoding/xml"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/blacksails/amznode"
+	"github.com/blacksails/amznode/memory"
 	"github.com/blacksails/amznode/pg"
 	"github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
 )
 
+// setup returns a handler along with a function which wraps tests, such that
+// they are run against an empty memory storage and an empty pg storage. The
+// pg runs are skipped if no database is reachable.
 func setup(t *testing.T) (http.Handler, func(func(*testing.T)) func(*testing.T)) {
-	dbUser := amznode.GetEnv("POSTGRES_USER", "postgres")
-	dbPass := amznode.GetEnv("POSTGRES_PASS", "postgres")
-	dbName := amznode.GetEnv("POSTGRES_DB", "postgres")
-	dbSchema := amznode.GetEnv("POSTGRES_SCHEMA", "amznode")
-	dbHost := amznode.GetEnv("POSTGRES_HOST", "localhost")
-	dbPort := amznode.GetEnv("POSTGRES_PORT", "5432")
-
-	dbConnStr := fmt.Sprintf(
-		"user=%s password=%s host=%s dbname=%s port=%s sslmode=disable",
-		dbUser, dbPass, dbHost, dbName, dbPort)
-	db, err := sql.Open("postgres", dbConnStr)
-	if err != nil {
-		t.Fatal(err)
-	}
+	var handler http.Handler
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeHTTP(w, r)
+	})
 
-	storage, err := pg.New(dbConnStr)
-	if err != nil {
-		t.Fatal(err)
+	withReset := func(test func(t *testing.T)) func(t *testing.T) {
+		return func(t *testing.T) {
+			t.Run("memory", func(t *testing.T) {
+				handler = amznode.New(memory.New()).Handler()
+				test(t)
+			})
+			t.Run("pg", func(t *testing.T) {
+				handler = amznode.New(resetPostgres(t)).Handler()
+				test(t)
+			})
+		}
 	}
-	server := amznode.New(storage)
 
-	withReset := func(test func(t *testing.T)) func(t *testing.T) {
-		schema := pq.QuoteIdentifier(dbSchema)
-		table := fmt.Sprintf("%s.%s", schema, pq.QuoteIdentifier(pg.TableName))
-		smts := []string{
-			fmt.Sprintf(`DROP SCHEMA IF EXISTS %s CASCADE`, schema),
-			fmt.Sprintf(`CREATE SCHEMA %s`, schema),
-			fmt.Sprintf(`
-				CREATE TABLE %s (
-					id SERIAL PRIMARY KEY,
-					parentID INTEGER REFERENCES %s (id) NULL,
-					name TEXT NOT NULL,
-					UNIQUE (parentID, name)
-				);`, table, table,
-			),
+	return h, withReset
+}
+
+var postgres struct {
+	once    sync.Once
+	db      *sql.DB
+	storage *pg.Storage
+	err     error
+}
+
+// resetPostgres returns the pg storage configured by the environment after
+// dropping its schema, or skips the test if no database is reachable.
+func resetPostgres(t *testing.T) *pg.Storage {
+	postgres.once.Do(func() {
+		dbConnStr := fmt.Sprintf(
+			"user=%s password=%s host=%s dbname=%s port=%s sslmode=disable",
+			amznode.GetEnv("POSTGRES_USER", "postgres"),
+			amznode.GetEnv("POSTGRES_PASS", "postgres"),
+			amznode.GetEnv("POSTGRES_HOST", "localhost"),
+			amznode.GetEnv("POSTGRES_DB", "postgres"),
+			amznode.GetEnv("POSTGRES_PORT", "5432"))
+		postgres.db, postgres.err = sql.Open("postgres", dbConnStr)
+		if postgres.err == nil {
+			postgres.err = postgres.db.Ping()
 		}
-		return func(t *testing.T) {
-			for _, smt := range smts {
-				_, err := db.Query(smt)
-				if err != nil {
-					t.Fatal(err)
-				}
-			}
-			test(t)
+		if postgres.err == nil {
+			postgres.storage, postgres.err = pg.New(dbConnStr)
 		}
+	})
+	if postgres.err != nil {
+		t.Skipf("could not connect to PostgreSQL: %s", postgres.err)
 	}
 
-	return server.Handler(), withReset
+	q := fmt.Sprintf(`DROP SCHEMA IF EXISTS %s CASCADE`, pq.QuoteIdentifier(pg.DefaultSchema))
+	if _, err := postgres.db.Exec(q); err != nil {
+		t.Fatal(err)
+	}
+	if err := postgres.storage.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	return postgres.storage
 }
 
 func TestCreate(t *testing.T) {
@@ -157,10 +177,76 @@ func testCreateByID(h http.Handler) func(*testing.T) {
 	}
 }
 
+func TestCreateTyped(t *testing.T) {
+	schema, err := amznode.ReadTypeSchema(strings.NewReader(`{
+		"roots": ["company"],
+		"types": {
+			"company": {"children": ["team"]},
+			"team": {"attributes": {"manager": {"type": "string", "required": true}}}
+		}
+	}`))
+	assert.NoError(t, err)
+	storage := memory.New()
+	storage.SetTypeSchema(schema)
+	h := amznode.New(storage).Handler()
+
+	tests := []struct {
+		path       string
+		body       string
+		resultCode int
+		result     string
+	}{
+		{
+			path:       "/acme?type=company",
+			resultCode: http.StatusCreated,
+			result:     `{"id":1,"name":"acme","type":"company","root_id":1,"height":0}` + "\n",
+		},
+		{
+			path:       "/1/emea?type=team",
+			body:       `{"manager":"alice"}`,
+			resultCode: http.StatusCreated,
+			result: `{"id":2,"parent_id":1,"name":"emea","type":"team","root_id":1,"height":1,` +
+				`"attributes":{"manager":"alice"}}` + "\n",
+		},
+		{
+			path:       "/1/apac?type=team",
+			resultCode: http.StatusBadRequest,
+			result:     `{"error":"the attribute 'manager' is required for a node of type 'team'"}` + "\n",
+		},
+		{
+			path:       "/2/dev",
+			resultCode: http.StatusBadRequest,
+			result:     `{"error":"an untyped node can't be a child of a node of type 'team'"}` + "\n",
+		},
+		{
+			path:       "/1/apac?type=te%20am",
+			resultCode: http.StatusBadRequest,
+			result:     `{"error":"type must match the regex /^[a-zA-Z\\d-_]+$/"}` + "\n",
+		},
+		{
+			path:       "/1/apac?type=team",
+			body:       `["alice"]`,
+			resultCode: http.StatusBadRequest,
+			result:     `{"error":"the attributes must be a json object"}` + "\n",
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			r := sendRequestBody(t, h, "POST", test.path, test.body)
+			assertStatusCode(t, r, test.resultCode)
+			body, err := ioutil.ReadAll(r.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, test.result, string(body))
+		})
+	}
+}
+
 func TestGet(t *testing.T) {
 	h, withReset := setup(t)
 	t.Run("roots", withReset(testGetRoots(h)))
 	t.Run("byID", withReset(withTestNodes(testGetByID(h), h)))
+	t.Run("recursive", withReset(withTestNodes(testGetRec(h), h)))
 }
 
 func testGetRoots(h http.Handler) func(*testing.T) {
@@ -301,86 +387,175 @@ func testGetByID(h http.Handler) func(*testing.T) {
 	}
 }
 
-func TestChangeParent(t *testing.T) {
-	h, withReset := setup(t)
+func testGetRec(h http.Handler) func(*testing.T) {
+	c6 := &amznode.Node{
+		ID:       7,
+		ParentID: 6,
+		Name:     "c6",
+		RootID:   1,
+		Height:   4,
+	}
+	c5 := &amznode.Node{
+		ID:       6,
+		ParentID: 5,
+		Name:     "c5",
+		RootID:   1,
+		Height:   3,
+		Children: []*amznode.Node{c6},
+	}
 
 	tests := []struct {
-		id           int
-		newParentID  int
-		resultCode   int
-		result       interface{}
-		resultParent interface{}
+		path       string
+		resultCode int
+		result     interface{}
 	}{
 		{
-			id: 1, newParentID: 42,
-			resultCode: http.StatusNotFound,
-			result: amznode.ErrorResponse{
-				Error: "Could not find node with ID 42",
+			path:       "/5?r=true",
+			resultCode: http.StatusOK,
+			result: amznode.Node{
+				ID:       5,
+				ParentID: 2,
+				Name:     "c4",
+				RootID:   1,
+				Height:   2,
+				Children: []*amznode.Node{c5},
 			},
 		},
 		{
-			id: 42, newParentID: 1,
-			resultCode: http.StatusNotFound,
-			result: amznode.ErrorResponse{
-				Error: "Could not find node with ID 42",
+			path:       "/5?depth=0",
+			resultCode: http.StatusOK,
+			result: amznode.Node{
+				ID:       5,
+				ParentID: 2,
+				Name:     "c4",
+				RootID:   1,
+				Height:   2,
 			},
 		},
 		{
-			id: 1, newParentID: 2,
-			resultCode: http.StatusBadRequest,
-			result: amznode.ErrorResponse{
-				Error: "the node with id 2 is a decendant of the node with id 1",
+			path:       "/5?depth=2&r=false",
+			resultCode: http.StatusOK,
+			result: amznode.Node{
+				ID:       5,
+				ParentID: 2,
+				Name:     "c4",
+				RootID:   1,
+				Height:   2,
+				Children: []*amznode.Node{c5},
 			},
 		},
 		{
-			id: 6, newParentID: 1,
-			resultCode: http.StatusOK,
-			resultParent: amznode.Node{
-				ID:       1,
-				ParentID: 0,
-				Name:     "root",
-				RootID:   1,
-				Height:   0,
-				Children: []*amznode.Node{
-					&amznode.Node{
-						ID:       2,
-						ParentID: 1,
-						Name:     "c1",
-						RootID:   1,
-						Height:   1,
-					},
-					&amznode.Node{
-						ID:       3,
-						ParentID: 1,
-						Name:     "c2",
-						RootID:   1,
-						Height:   1,
-					},
-					&amznode.Node{
-						ID:       6,
-						ParentID: 1,
-						Name:     "c5",
-						RootID:   1,
-						Height:   1,
-					},
-				},
+			path:       "/5?depth=-1",
+			resultCode: http.StatusBadRequest,
+			result: amznode.ErrorResponse{
+				Error: "depth must be greater than or equal 0",
 			},
 		},
 	}
 
-	testFunc := func(t *testing.T) {
+	return func(t *testing.T) {
 		for i, test := range tests {
 			t.Run(strconv.Itoa(i), func(t *testing.T) {
-				r := sendRequest(t, h, "PUT", fmt.Sprintf("/%d?parentID=%d", test.id, test.newParentID))
-
+				r := sendRequest(t, h, "GET", test.path)
 				assertResponse(t, r, test.resultCode, test.result)
+			})
+		}
 
-				if test.resultParent == nil {
-					return
-				}
+		r := sendRequest(t, h, "GET", "/?r=true")
+		assertStatusCode(t, r, http.StatusOK)
+		var roots []amznode.Node
+		err := json.NewDecoder(r.Body).Decode(&roots)
+		assert.NoError(t, err, "could not decode json")
+		if assert.Len(t, roots, 1) {
+			assert.Equal(t, c5, roots[0].Children[0].Children[1].Children[0])
+		}
+	}
+}
 
-				r = sendRequest(t, h, "GET", fmt.Sprintf("/%d", test.newParentID))
-				assertResponse(t, r, http.StatusOK, test.resultParent)
+func TestGetFormat(t *testing.T) {
+	h, withReset := setup(t)
+
+	tests := []struct {
+		path        string
+		accept      string
+		resultCode  int
+		contentType string
+		result      string
+	}{
+		{
+			path:        "/?r=true",
+			accept:      "text/plain",
+			resultCode:  http.StatusOK,
+			contentType: "text/plain; charset=utf-8",
+			result: "- root\n" +
+				"  - c1\n" +
+				"    - c3\n" +
+				"    - c4\n" +
+				"      - c5\n" +
+				"        - c6\n" +
+				"  - c2\n",
+		},
+		{
+			path:        "/5",
+			accept:      "text/plain, application/json;q=0.9",
+			resultCode:  http.StatusOK,
+			contentType: "text/plain; charset=utf-8",
+			result:      "- c4\n  - c5\n",
+		},
+		{
+			path:        "/5?format=dot&depth=0",
+			accept:      "text/plain",
+			resultCode:  http.StatusOK,
+			contentType: "text/vnd.graphviz; charset=utf-8",
+			result:      "digraph amznode {\n\tn5 [label=\"c4 (id 5)\"];\n}\n",
+		},
+		{
+			path:        "/5?format=dot&r=true",
+			resultCode:  http.StatusOK,
+			contentType: "text/vnd.graphviz; charset=utf-8",
+			result: "digraph amznode {\n" +
+				"\tn5 [label=\"c4 (id 5)\"];\n" +
+				"\tn5 -> n6;\n" +
+				"\tn6 [label=\"c5 (id 6)\"];\n" +
+				"\tn6 -> n7;\n" +
+				"\tn7 [label=\"c6 (id 7)\"];\n" +
+				"}\n",
+		},
+		{
+			path:        "/2?format=mermaid",
+			resultCode:  http.StatusOK,
+			contentType: "text/vnd.mermaid; charset=utf-8",
+			result: "graph TD\n" +
+				"\tn2[\"c1 (id 2)\"]\n" +
+				"\tn2 --> n4\n" +
+				"\tn2 --> n5\n" +
+				"\tn4[\"c3 (id 4)\"]\n" +
+				"\tn5[\"c4 (id 5)\"]\n",
+		},
+		{
+			path:        "/?format=mermaid&depth=0",
+			resultCode:  http.StatusOK,
+			contentType: "text/vnd.mermaid; charset=utf-8",
+			result:      "graph TD\n\tn1[\"root (id 1)\"]\n",
+		},
+		{
+			path:        "/5?format=svg",
+			resultCode:  http.StatusBadRequest,
+			contentType: "application/json; charset=utf-8",
+			result:      "{\"error\":\"format must be one of 'json', 'yaml', 'xml', 'csv', 'text', 'dot', 'mermaid' or 'ndjson'\"}\n",
+		},
+	}
+
+	testFunc := func(t *testing.T) {
+		for i, test := range tests {
+			t.Run(strconv.Itoa(i), func(t *testing.T) {
+				header := http.Header{"Accept": {test.accept}}
+				r := sendRequestHeader(t, h, "GET", test.path, "", header)
+				assertStatusCode(t, r, test.resultCode)
+				assert.Equal(t, test.contentType, r.Header.Get("Content-Type"))
+				body, err := ioutil.ReadAll(r.Body)
+				assert.NoError(t, err)
+				assert.Equal(t, test.result, string(body))
 			})
 		}
 	}
@@ -388,72 +563,1513 @@ func TestChangeParent(t *testing.T) {
 	withReset(withTestNodes(testFunc, h))(t)
 }
 
-func TestDelete(t *testing.T) {
+func TestContentNegotiation(t *testing.T) {
 	h, withReset := setup(t)
 
 	tests := []struct {
-		id         int
-		resultCode int
-		deletedIDs []int
+		path        string
+		accept      string
+		resultCode  int
+		contentType string
+		result      string
 	}{
-		{id: -1, resultCode: http.StatusBadRequest},
-		{id: 7, resultCode: http.StatusOK, deletedIDs: []int{7}},
-		{id: 5, resultCode: http.StatusOK, deletedIDs: []int{5, 6}},
-		{id: 1, resultCode: http.StatusOK, deletedIDs: []int{1, 2, 3, 4}},
+		{
+			path:        "/5?depth=0",
+			accept:      "application/yaml",
+			resultCode:  http.StatusOK,
+			contentType: "application/yaml; charset=utf-8",
+			result:      "id: 5\nparent_id: 2\nname: c4\nroot_id: 1\nheight: 2\n",
+		},
+		{
+			path:        "/6",
+			accept:      "application/xml",
+			resultCode:  http.StatusOK,
+			contentType: "application/xml; charset=utf-8",
+			result: xml.Header +
+				`<node id="6" parent_id="5" name="c5" root_id="1" height="3">` +
+				`<node id="7" parent_id="6" name="c6" root_id="1" height="4"></node>` +
+				"</node>\n",
+		},
+		{
+			path:        "/?depth=0",
+			accept:      "application/xml",
+			resultCode:  http.StatusOK,
+			contentType: "application/xml; charset=utf-8",
+			result: xml.Header +
+				`<nodes><node id="1" name="root" root_id="1" height="0"></node></nodes>` + "\n",
+		},
+		{
+			path:        "/2",
+			accept:      "text/csv",
+			resultCode:  http.StatusOK,
+			contentType: "text/csv; charset=utf-8",
+			result:      "id,parent_id,name\n2,,c1\n4,2,c3\n5,2,c4\n",
+		},
+		{
+			path:        "/6/ancestors",
+			accept:      "text/plain",
+			resultCode:  http.StatusOK,
+			contentType: "text/plain; charset=utf-8",
+			result:      "- root\n  - c1\n    - c4\n      - c5\n",
+		},
+		{
+			path:        "/5",
+			accept:      "text/*",
+			resultCode:  http.StatusOK,
+			contentType: "text/plain; charset=utf-8",
+			result:      "- c4\n  - c5\n",
+		},
+		{
+			path:        "/5?depth=0",
+			accept:      "text/vnd.mermaid",
+			resultCode:  http.StatusOK,
+			contentType: "text/vnd.mermaid; charset=utf-8",
+			result:      "graph TD\n\tn5[\"c4 (id 5)\"]\n",
+		},
+		{
+			path:        "/5?depth=0",
+			accept:      "image/png, */*;q=0.1",
+			resultCode:  http.StatusOK,
+			contentType: "application/json; charset=utf-8",
+			result:      `{"id":5,"parent_id":2,"name":"c4","root_id":1,"height":2}` + "\n",
+		},
+		{
+			path:        "/42",
+			accept:      "application/yaml",
+			resultCode:  http.StatusNotFound,
+			contentType: "application/yaml; charset=utf-8",
+			result:      "error: Could not find node with ID 42\n",
+		},
+		{
+			path:        "/2?r=true",
+			accept:      "application/x-ndjson",
+			resultCode:  http.StatusOK,
+			contentType: "application/x-ndjson; charset=utf-8",
+			result: `{"id":2,"parent_id":1,"name":"c1","root_id":1,"height":1}` + "\n" +
+				`{"id":4,"parent_id":2,"name":"c3","root_id":1,"height":2}` + "\n" +
+				`{"id":5,"parent_id":2,"name":"c4","root_id":1,"height":2}` + "\n" +
+				`{"id":6,"parent_id":5,"name":"c5","root_id":1,"height":3}` + "\n" +
+				`{"id":7,"parent_id":6,"name":"c6","root_id":1,"height":4}` + "\n",
+		},
+		{
+			path:        "/export?format=ndjson",
+			resultCode:  http.StatusOK,
+			contentType: "application/x-ndjson; charset=utf-8",
+			result: `{"id":1,"name":"root","root_id":1,"height":0}` + "\n" +
+				`{"id":2,"parent_id":1,"name":"c1","root_id":1,"height":1}` + "\n" +
+				`{"id":3,"parent_id":1,"name":"c2","root_id":1,"height":1}` + "\n" +
+				`{"id":4,"parent_id":2,"name":"c3","root_id":1,"height":2}` + "\n" +
+				`{"id":5,"parent_id":2,"name":"c4","root_id":1,"height":2}` + "\n" +
+				`{"id":6,"parent_id":5,"name":"c5","root_id":1,"height":3}` + "\n" +
+				`{"id":7,"parent_id":6,"name":"c6","root_id":1,"height":4}` + "\n",
+		},
+		{
+			path:        "/42?r=true",
+			accept:      "application/x-ndjson",
+			resultCode:  http.StatusNotFound,
+			contentType: "application/x-ndjson; charset=utf-8",
+			result:      `{"error":"Could not find node with ID 42"}` + "\n",
+		},
+		{
+			path:        "/42?format=dot",
+			resultCode:  http.StatusNotFound,
+			contentType: "application/json; charset=utf-8",
+			result:      `{"error":"Could not find node with ID 42"}` + "\n",
+		},
+		{
+			path:        "/5",
+			accept:      "image/png, application/json;q=0",
+			resultCode:  http.StatusNotAcceptable,
+			contentType: "application/json; charset=utf-8",
+			result: `{"error":"none of the accepted media types are supported, use one of ` +
+				`application/json, application/yaml, application/x-yaml, text/yaml, application/xml, ` +
+				`text/xml, text/csv, text/plain, text/vnd.graphviz, text/vnd.mermaid, application/x-ndjson"}` + "\n",
+		},
 	}
 
 	testFunc := func(t *testing.T) {
 		for i, test := range tests {
 			t.Run(strconv.Itoa(i), func(t *testing.T) {
-				r := sendRequest(t, h, "DELETE", fmt.Sprintf("/%d", test.id))
-
-				assertResponse(t, r, test.resultCode, nil)
-
-				for _, id := range test.deletedIDs {
-					r = sendRequest(t, h, "GET", fmt.Sprintf("/%d", id))
-					if r.StatusCode != http.StatusNotFound {
-						t.Errorf("expected node with id to be not found, got code: %d", r.StatusCode)
-					}
-				}
+				header := http.Header{"Accept": {test.accept}}
+				r := sendRequestHeader(t, h, "GET", test.path, "", header)
+				assertStatusCode(t, r, test.resultCode)
+				assert.Equal(t, test.contentType, r.Header.Get("Content-Type"))
+				body, err := ioutil.ReadAll(r.Body)
+				assert.NoError(t, err)
+				assert.Equal(t, test.result, string(body))
 			})
 		}
+
+		r := sendRequestHeader(t, h, "POST", "/1/c9", "", http.Header{"Accept": {"image/png"}})
+		assertStatusCode(t, r, http.StatusNotAcceptable)
+		r = sendRequest(t, h, "GET", "/path/root/c9")
+		assertStatusCode(t, r, http.StatusNotFound)
 	}
 
 	withReset(withTestNodes(testFunc, h))(t)
 }
 
-func createTestNodes(t *testing.T, h http.Handler) {
-	// 1(root)
-	//   2(c1)
-	//     4(c3)
-	//     5(c4)
-	//       6(c5)
-	//         7(c6)
-	//   3(c2)
-	nodes := []struct {
-		parentID int
-		name     string
-	}{
-		{parentID: 0, name: "root"}, // id: 1
-		{parentID: 1, name: "c1"},   // id: 2
-		{parentID: 1, name: "c2"},   // id: 3
-		{parentID: 2, name: "c3"},   // id: 4
-		{parentID: 2, name: "c4"},   // id: 5
-		{parentID: 5, name: "c5"},   // id: 6
-		{parentID: 6, name: "c6"},   // id: 7
-	}
+func TestRegisterEncoder(t *testing.T) {
+	defer amznode.RestoreEncoders()()
+	h, withReset := setup(t)
 
-	// create nodes
-	for i, node := range nodes {
-		r := sendRequest(t, h, "POST", fmt.Sprintf("/%d/%s", node.parentID, node.name))
-		assert.Equal(t, http.StatusCreated, r.StatusCode, i)
+	amznode.RegisterEncoder(amznode.Encoder{
+		Format:     "names",
+		MediaTypes: []string{"text/x-names"},
+		Encode: func(w io.Writer, v interface{}) error {
+			node, ok := v.(*amznode.Node)
+			if !ok {
+				return amznode.ErrNotEncodable
+			}
+			_, err := fmt.Fprintln(w, node.Name)
+			return err
+		},
+	})
+	amznode.RegisterEncoder(amznode.Encoder{
+		Format:     "text",
+		MediaTypes: []string{"text/plain"},
+		Encode: func(w io.Writer, v interface{}) error {
+			_, err := fmt.Fprintln(w, "replaced")
+			return err
+		},
+	})
+	assert.Panics(t, func() { amznode.RegisterEncoder(amznode.Encoder{Format: "empty"}) })
+
+	testFunc := func(t *testing.T) {
+		r := sendRequestHeader(t, h, "GET", "/5?depth=0", "", http.Header{"Accept": {"text/x-names"}})
+		assertStatusCode(t, r, http.StatusOK)
+		assert.Equal(t, "text/x-names; charset=utf-8", r.Header.Get("Content-Type"))
+		body, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "c4\n", string(body))
+
+		r = sendRequest(t, h, "GET", "/5/ancestors?format=names")
+		assertResponse(t, r, http.StatusNotAcceptable, amznode.ErrorResponse{
+			Error: amznode.ErrNotEncodable.Error(),
+		})
+
+		r = sendRequest(t, h, "GET", "/5?format=text")
+		assertStatusCode(t, r, http.StatusOK)
+		body, err = ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "replaced\n", string(body))
+
+		r = sendRequest(t, h, "GET", "/5?format=svg")
+		assertResponse(t, r, http.StatusBadRequest, amznode.ErrorResponse{
+			Error: "format must be one of 'json', 'yaml', 'xml', 'csv', 'text', 'dot', " +
+				"'mermaid', 'ndjson' or 'names'",
+		})
 	}
+
+	withReset(withTestNodes(testFunc, h))(t)
 }
 
-func sendRequest(t *testing.T, handler http.Handler, method, path string) *http.Response {
-	w := httptest.NewRecorder()
-	r, err := http.NewRequest(method, path, nil)
-	assert.NoError(t, err, "could not create http request")
+func TestGetChildren(t *testing.T) {
+	h, withReset := setup(t)
+
+	c1 := amznode.Node{ID: 2, ParentID: 1, Name: "c1", RootID: 1, Height: 1}
+	c2 := amznode.Node{ID: 3, ParentID: 1, Name: "c2", RootID: 1, Height: 1}
+	tests := []struct {
+		path       string
+		resultCode int
+		result     interface{}
+		link       string
+	}{
+		{
+			path:       "/1/children?limit=1",
+			resultCode: http.StatusOK,
+			result:     []amznode.Node{c1},
+			link:       `</1/children?cursor=YzE&limit=1>; rel="next"`,
+		},
+		{
+			path:       "/1/children?limit=1&cursor=YzE",
+			resultCode: http.StatusOK,
+			result:     []amznode.Node{c2},
+		},
+		{
+			path:       "/1/children",
+			resultCode: http.StatusOK,
+			result:     []amznode.Node{c1, c2},
+		},
+		{
+			path:       "/?limit=1",
+			resultCode: http.StatusOK,
+			result: []amznode.Node{
+				{
+					ID:       1,
+					Name:     "root",
+					RootID:   1,
+					Children: []*amznode.Node{&c1, &c2},
+				},
+			},
+		},
+		{
+			path:       "/1/children?limit=0",
+			resultCode: http.StatusBadRequest,
+			result: amznode.ErrorResponse{
+				Error: "limit must be between 1 and 1000",
+			},
+		},
+		{
+			path:       "/1/children?cursor=!!",
+			resultCode: http.StatusBadRequest,
+			result: amznode.ErrorResponse{
+				Error: "cursor is not valid",
+			},
+		},
+		{
+			path:       "/1?limit=1",
+			resultCode: http.StatusBadRequest,
+			result: amznode.ErrorResponse{
+				Error: "limit, cursor and attribute filters only apply to the roots and to the children of a node",
+			},
+		},
+		{
+			path:       "/1?attr.manager=alice",
+			resultCode: http.StatusBadRequest,
+			result: amznode.ErrorResponse{
+				Error: "limit, cursor and attribute filters only apply to the roots and to the children of a node",
+			},
+		},
+		{
+			path:       "/42/children",
+			resultCode: http.StatusNotFound,
+			result: amznode.ErrorResponse{
+				Error: "Could not find node with ID 42",
+			},
+		},
+	}
+
+	testFunc := func(t *testing.T) {
+		for i, test := range tests {
+			t.Run(strconv.Itoa(i), func(t *testing.T) {
+				r := sendRequest(t, h, "GET", test.path)
+				assertListResponse(t, r, test.resultCode, test.result)
+				assert.Equal(t, test.link, r.Header.Get("Link"))
+			})
+		}
+	}
+
+	withReset(withTestNodes(testFunc, h))(t)
+}
+
+func TestSearch(t *testing.T) {
+	h, withReset := setup(t)
+
+	tests := []struct {
+		path        string
+		accept      string
+		resultCode  int
+		contentType string
+		result      string
+	}{
+		{
+			path:        "/search?q=c5",
+			resultCode:  http.StatusOK,
+			contentType: "application/json; charset=utf-8",
+			result:      `[{"id":6,"parent_id":5,"name":"c5","root_id":1,"height":3,"path":"root/c1/c4/c5"}]` + "\n",
+		},
+		{
+			path:        "/search?q=c&match=prefix&limit=3&root=1",
+			accept:      "text/plain",
+			resultCode:  http.StatusOK,
+			contentType: "text/plain; charset=utf-8",
+			result:      "root/c1\nroot/c1/c3\nroot/c1/c4\n",
+		},
+		{
+			path:        "/search?q=c6&match=exact",
+			accept:      "application/yaml",
+			resultCode:  http.StatusOK,
+			contentType: "application/yaml; charset=utf-8",
+			result: "- id: 7\n  parent_id: 6\n  name: c6\n  root_id: 1\n  height: 4\n" +
+				"  path: root/c1/c4/c5/c6\n",
+		},
+		{
+			path:        "/search?q=c&match=fuzzy",
+			resultCode:  http.StatusBadRequest,
+			contentType: "application/json; charset=utf-8",
+			result:      `{"error":"unknown match 'fuzzy'"}` + "\n",
+		},
+		{
+			path:        "/search?q=c%25",
+			resultCode:  http.StatusBadRequest,
+			contentType: "application/json; charset=utf-8",
+			result:      `{"error":"q must be a part of a name matching the regex /^[a-zA-Z\\d-_]+$/"}` + "\n",
+		},
+		{
+			path:        "/search?q=c&root=42",
+			resultCode:  http.StatusNotFound,
+			contentType: "application/json; charset=utf-8",
+			result:      `{"error":"Could not find node with ID 42"}` + "\n",
+		},
+		{
+			path:        "/search?q=c&root=2",
+			resultCode:  http.StatusBadRequest,
+			contentType: "application/json; charset=utf-8",
+			result:      `{"error":"the node with id 2 is not a root node"}` + "\n",
+		},
+	}
+
+	testFunc := func(t *testing.T) {
+		for i, test := range tests {
+			t.Run(strconv.Itoa(i), func(t *testing.T) {
+				header := http.Header{"Accept": {test.accept}}
+				r := sendRequestHeader(t, h, "GET", test.path, "", header)
+				assertStatusCode(t, r, test.resultCode)
+				assert.Equal(t, test.contentType, r.Header.Get("Content-Type"))
+				body, err := ioutil.ReadAll(r.Body)
+				assert.NoError(t, err)
+				assert.Equal(t, test.result, string(body))
+			})
+		}
+	}
+
+	withReset(withTestNodes(testFunc, h))(t)
+}
+
+func TestGetAncestors(t *testing.T) {
+	h, withReset := setup(t)
+
+	testFunc := func(t *testing.T) {
+		r := sendRequest(t, h, "GET", "/6/ancestors")
+		assertStatusCode(t, r, http.StatusOK)
+		var respBody amznode.AncestorsResponse
+		err := json.NewDecoder(r.Body).Decode(&respBody)
+		assert.NoError(t, err, "could not decode json")
+		assert.Equal(t, amznode.AncestorsResponse{
+			Path: "root/c1/c4/c5",
+			Ancestors: []*amznode.Node{
+				{ID: 1, Name: "root", RootID: 1},
+				{ID: 2, ParentID: 1, Name: "c1", RootID: 1, Height: 1},
+				{ID: 5, ParentID: 2, Name: "c4", RootID: 1, Height: 2},
+				{ID: 6, ParentID: 5, Name: "c5", RootID: 1, Height: 3},
+			},
+		}, respBody)
+
+		r = sendRequest(t, h, "GET", "/42/ancestors")
+		assertResponse(t, r, http.StatusNotFound, amznode.ErrorResponse{
+			Error: "Could not find node with ID 42",
+		})
+	}
+
+	withReset(withTestNodes(testFunc, h))(t)
+}
+
+func TestChangeParent(t *testing.T) {
+	h, withReset := setup(t)
+
+	tests := []struct {
+		id           int
+		newParentID  int
+		resultCode   int
+		result       interface{}
+		resultParent interface{}
+	}{
+		{
+			id: 1, newParentID: 42,
+			resultCode: http.StatusNotFound,
+			result: amznode.ErrorResponse{
+				Error: "Could not find node with ID 42",
+			},
+		},
+		{
+			id: 42, newParentID: 1,
+			resultCode: http.StatusNotFound,
+			result: amznode.ErrorResponse{
+				Error: "Could not find node with ID 42",
+			},
+		},
+		{
+			id: 1, newParentID: 2,
+			resultCode: http.StatusBadRequest,
+			result: amznode.ErrorResponse{
+				Error: "the node with id 2 is a decendant of the node with id 1",
+			},
+		},
+		{
+			id: 6, newParentID: 1,
+			resultCode: http.StatusOK,
+			resultParent: amznode.Node{
+				ID:       1,
+				ParentID: 0,
+				Name:     "root",
+				RootID:   1,
+				Height:   0,
+				Children: []*amznode.Node{
+					&amznode.Node{
+						ID:       2,
+						ParentID: 1,
+						Name:     "c1",
+						RootID:   1,
+						Height:   1,
+					},
+					&amznode.Node{
+						ID:       3,
+						ParentID: 1,
+						Name:     "c2",
+						RootID:   1,
+						Height:   1,
+					},
+					&amznode.Node{
+						ID:       6,
+						ParentID: 1,
+						Name:     "c5",
+						RootID:   1,
+						Height:   1,
+					},
+				},
+			},
+		},
+	}
+
+	testFunc := func(t *testing.T) {
+		for i, test := range tests {
+			t.Run(strconv.Itoa(i), func(t *testing.T) {
+				r := sendRequest(t, h, "PUT", fmt.Sprintf("/%d?parentID=%d", test.id, test.newParentID))
+
+				assertResponse(t, r, test.resultCode, test.result)
+
+				if test.resultParent == nil {
+					return
+				}
+
+				r = sendRequest(t, h, "GET", fmt.Sprintf("/%d", test.newParentID))
+				assertResponse(t, r, http.StatusOK, test.resultParent)
+			})
+		}
+	}
+
+	withReset(withTestNodes(testFunc, h))(t)
+}
+
+func TestCopy(t *testing.T) {
+	h, withReset := setup(t)
+
+	tests := []struct {
+		path       string
+		resultCode int
+		result     interface{}
+	}{
+		{
+			path:       "/5/copy?parentID=3",
+			resultCode: http.StatusCreated,
+			result: amznode.Node{
+				ID:       8,
+				ParentID: 3,
+				Name:     "c4",
+				RootID:   1,
+				Height:   2,
+				Children: []*amznode.Node{
+					{
+						ID:       9,
+						ParentID: 8,
+						Name:     "c5",
+						RootID:   1,
+						Height:   3,
+					},
+				},
+			},
+		},
+		{
+			path:       "/5/copy?parentID=3",
+			resultCode: http.StatusBadRequest,
+			result: amznode.ErrorResponse{
+				Error: "the name 'c4' has already been taken under the parent with id #3",
+			},
+		},
+		{
+			path:       "/7/copy?name=c7",
+			resultCode: http.StatusCreated,
+			result: amznode.Node{
+				ID:     12,
+				Name:   "c7",
+				RootID: 12,
+			},
+		},
+		{
+			path:       "/7/copy?parentID=1&name=c$",
+			resultCode: http.StatusBadRequest,
+			result: amznode.ErrorResponse{
+				Error: "name must match the regex /^[a-zA-Z\\d-_]+$/",
+			},
+		},
+		{
+			path:       "/42/copy?parentID=1",
+			resultCode: http.StatusNotFound,
+			result: amznode.ErrorResponse{
+				Error: "Could not find node with ID 42",
+			},
+		},
+	}
+
+	testFunc := func(t *testing.T) {
+		for i, test := range tests {
+			t.Run(strconv.Itoa(i), func(t *testing.T) {
+				r := sendRequest(t, h, "POST", test.path)
+				assertResponse(t, r, test.resultCode, test.result)
+			})
+		}
+	}
+
+	withReset(withTestNodes(testFunc, h))(t)
+}
+
+func TestImport(t *testing.T) {
+	h, withReset := setup(t)
+
+	tests := []struct {
+		path       string
+		body       string
+		list       bool
+		resultCode int
+		result     interface{}
+	}{
+		{
+			path:       "/import?parentID=3",
+			body:       `{"name": "i1", "children": [{"name": "i2"}]}`,
+			resultCode: http.StatusCreated,
+			result: amznode.Node{
+				ID:       8,
+				ParentID: 3,
+				Name:     "i1",
+				RootID:   1,
+				Height:   2,
+				Children: []*amznode.Node{
+					{
+						ID:       9,
+						ParentID: 8,
+						Name:     "i2",
+						RootID:   1,
+						Height:   3,
+					},
+				},
+			},
+		},
+		{
+			path:       "/import",
+			body:       `[{"name": "i3"}]`,
+			list:       true,
+			resultCode: http.StatusCreated,
+			result: []amznode.Node{
+				{
+					ID:     10,
+					Name:   "i3",
+					RootID: 10,
+				},
+			},
+		},
+		{
+			path:       "/import?parentID=3",
+			body:       `{"name": "i1"}`,
+			resultCode: http.StatusBadRequest,
+			result: amznode.ErrorResponse{
+				Error: "the name 'i1' has already been taken under the parent with id #3",
+			},
+		},
+		{
+			path:       "/import?parentID=3",
+			body:       `{"name": "i4", "children": [{"name": "i$"}]}`,
+			resultCode: http.StatusBadRequest,
+			result: amznode.ErrorResponse{
+				Error: "name must match the regex /^[a-zA-Z\\d-_]+$/",
+			},
+		},
+		{
+			path:       "/import?parentID=3",
+			body:       `{"name": `,
+			resultCode: http.StatusBadRequest,
+			result: amznode.ErrorResponse{
+				Error: "unexpected EOF",
+			},
+		},
+		{
+			path:       "/import?parentID=3",
+			resultCode: http.StatusBadRequest,
+			result: amznode.ErrorResponse{
+				Error: "the request body must not be empty",
+			},
+		},
+		{
+			path:       "/import?parentID=42",
+			body:       `{"name": "i5"}`,
+			resultCode: http.StatusNotFound,
+			result: amznode.ErrorResponse{
+				Error: "Could not find node with ID 42",
+			},
+		},
+	}
+
+	testFunc := func(t *testing.T) {
+		for i, test := range tests {
+			t.Run(strconv.Itoa(i), func(t *testing.T) {
+				r := sendRequestBody(t, h, "POST", test.path, test.body)
+				if test.list {
+					assertListResponse(t, r, test.resultCode, test.result)
+					return
+				}
+				assertResponse(t, r, test.resultCode, test.result)
+			})
+		}
+	}
+
+	withReset(withTestNodes(testFunc, h))(t)
+}
+
+func TestExport(t *testing.T) {
+	h, withReset := setup(t)
+
+	export := func(t *testing.T) []amznode.Node {
+		r := sendRequest(t, h, "GET", "/export")
+		assertStatusCode(t, r, http.StatusOK)
+		var roots []amznode.Node
+		err := json.NewDecoder(r.Body).Decode(&roots)
+		assert.NoError(t, err, "could not decode json")
+		return roots
+	}
+
+	testFunc := func(t *testing.T) {
+		roots := export(t)
+		if assert.Len(t, roots, 1) {
+			c4 := roots[0].Children[0].Children[1]
+			assert.Equal(t, "c4", c4.Name)
+			assert.Equal(t, "c6", c4.Children[0].Children[0].Name)
+		}
+
+		body, err := json.Marshal(roots)
+		assert.NoError(t, err)
+		r := sendRequest(t, h, "DELETE", "/1")
+		assertStatusCode(t, r, http.StatusOK)
+		r = sendRequest(t, h, "DELETE", "/trash/1")
+		assertStatusCode(t, r, http.StatusOK)
+		r = sendRequestBody(t, h, "POST", "/import?preserveIDs=true", string(body))
+		assertStatusCode(t, r, http.StatusCreated)
+		assert.Equal(t, roots, export(t), "the export must round trip")
+
+		r = sendRequestBody(t, h, "POST", "/import?preserveIDs=true", string(body))
+		assertResponse(t, r, http.StatusBadRequest, amznode.ErrorResponse{
+			Error: "the id 1 has already been taken",
+		})
+	}
+
+	withReset(withTestNodes(testFunc, h))(t)
+}
+
+func TestImportCSV(t *testing.T) {
+	h, withReset := setup(t)
+	csvHeader := http.Header{"Content-Type": {"text/csv"}}
+
+	tests := []struct {
+		path       string
+		body       string
+		resultCode int
+		result     interface{}
+	}{
+		{
+			path:       "/import?parentID=3",
+			body:       "id,parent_id,name\n10,,org\n11,10,eng\n12,11,backend\n13,10,sales\n",
+			resultCode: http.StatusCreated,
+			result: []amznode.Node{
+				{
+					ID:       8,
+					ParentID: 3,
+					Name:     "org",
+					RootID:   1,
+					Height:   2,
+					Children: []*amznode.Node{
+						{
+							ID:       9,
+							ParentID: 8,
+							Name:     "eng",
+							RootID:   1,
+							Height:   3,
+							Children: []*amznode.Node{
+								{
+									ID:       10,
+									ParentID: 9,
+									Name:     "backend",
+									RootID:   1,
+									Height:   4,
+								},
+							},
+						},
+						{
+							ID:       11,
+							ParentID: 8,
+							Name:     "sales",
+							RootID:   1,
+							Height:   3,
+						},
+					},
+				},
+			},
+		},
+		{
+			path:       "/import",
+			body:       "path\nteams/eng\nteams/sales\n",
+			resultCode: http.StatusCreated,
+			result: []amznode.Node{
+				{
+					ID:     12,
+					Name:   "teams",
+					RootID: 12,
+					Children: []*amznode.Node{
+						{
+							ID:       13,
+							ParentID: 12,
+							Name:     "eng",
+							RootID:   12,
+							Height:   1,
+						},
+						{
+							ID:       14,
+							ParentID: 12,
+							Name:     "sales",
+							RootID:   12,
+							Height:   1,
+						},
+					},
+				},
+			},
+		},
+		{
+			path:       "/import",
+			body:       "id,name\n1,org\n",
+			resultCode: http.StatusBadRequest,
+			result: amznode.ErrorResponse{
+				Error: "csv must have a header with either the columns 'id', 'parent_id' and 'name' or the column 'path'",
+			},
+		},
+		{
+			path:       "/import",
+			body:       "id,parent_id,name\n1,,org\n2,3,eng\n3,2,sales\n",
+			resultCode: http.StatusBadRequest,
+			result: amznode.ErrorResponse{
+				Error: "the parent_id columns of the csv contains a cycle",
+			},
+		},
+		{
+			path:       "/import",
+			body:       "id,parent_id,name\n1,,org\n2,4,eng\n",
+			resultCode: http.StatusBadRequest,
+			result: amznode.ErrorResponse{
+				Error: "line 3: the parent_id 4 is not the id of any of the rows",
+			},
+		},
+		{
+			path:       "/import",
+			body:       "id,parent_id,name\nx,,org\n",
+			resultCode: http.StatusBadRequest,
+			result: amznode.ErrorResponse{
+				Error: "line 2: the id must be a positive integer",
+			},
+		},
+		{
+			path:       "/import",
+			body:       "path\nteams/e$\n",
+			resultCode: http.StatusBadRequest,
+			result: amznode.ErrorResponse{
+				Error: "name must match the regex /^[a-zA-Z\\d-_]+$/",
+			},
+		},
+	}
+
+	testFunc := func(t *testing.T) {
+		for i, test := range tests {
+			t.Run(strconv.Itoa(i), func(t *testing.T) {
+				r := sendRequestHeader(t, h, "POST", test.path, test.body, csvHeader)
+				assertListResponse(t, r, test.resultCode, test.result)
+			})
+		}
+	}
+
+	withReset(withTestNodes(testFunc, h))(t)
+}
+
+func TestExportCSV(t *testing.T) {
+	h, withReset := setup(t)
+	expected := "id,parent_id,name\n" +
+		"1,,root\n" +
+		"2,1,c1\n" +
+		"4,2,c3\n" +
+		"5,2,c4\n" +
+		"6,5,c5\n" +
+		"7,6,c6\n" +
+		"3,1,c2\n"
+
+	export := func(t *testing.T) string {
+		header := http.Header{"Accept": {"text/csv"}}
+		r := sendRequestHeader(t, h, "GET", "/export", "", header)
+		assertStatusCode(t, r, http.StatusOK)
+		assert.Equal(t, "text/csv; charset=utf-8", r.Header.Get("Content-Type"))
+		body, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		return string(body)
+	}
+
+	testFunc := func(t *testing.T) {
+		assert.Equal(t, expected, export(t))
+
+		r := sendRequest(t, h, "DELETE", "/1")
+		assertStatusCode(t, r, http.StatusOK)
+		r = sendRequest(t, h, "DELETE", "/trash/1")
+		assertStatusCode(t, r, http.StatusOK)
+		header := http.Header{"Content-Type": {"text/csv"}}
+		r = sendRequestHeader(t, h, "POST", "/import?preserveIDs=true", expected, header)
+		assertStatusCode(t, r, http.StatusCreated)
+		assert.Equal(t, expected, export(t), "the export must round trip")
+	}
+
+	testSubtree := func(t *testing.T) {
+		r := sendRequest(t, h, "GET", "/2?format=csv")
+		assertStatusCode(t, r, http.StatusOK)
+		body, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "id,parent_id,name\n2,,c1\n4,2,c3\n5,2,c4\n", string(body))
+
+		header := http.Header{"Content-Type": {"text/csv"}}
+		r = sendRequestHeader(t, h, "POST", "/import?parentID=3", string(body), header)
+		assertStatusCode(t, r, http.StatusCreated)
+		r = sendRequest(t, h, "GET", "/8?format=csv")
+		assertStatusCode(t, r, http.StatusOK)
+		body, err = ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "id,parent_id,name\n8,,c1\n9,8,c3\n10,8,c4\n", string(body))
+	}
+
+	t.Run("export", withReset(withTestNodes(testFunc, h)))
+	t.Run("subtree", withReset(withTestNodes(testSubtree, h)))
+}
+
+func TestRename(t *testing.T) {
+	h, withReset := setup(t)
+
+	tests := []struct {
+		id         int
+		name       string
+		resultCode int
+		result     interface{}
+		resultNode interface{}
+	}{
+		{
+			id: 42, name: "new",
+			resultCode: http.StatusNotFound,
+			result: amznode.ErrorResponse{
+				Error: "Could not find node with ID 42",
+			},
+		},
+		{
+			id: 3, name: "c$",
+			resultCode: http.StatusBadRequest,
+			result: amznode.ErrorResponse{
+				Error: "name must match the regex /^[a-zA-Z\\d-_]+$/",
+			},
+		},
+		{
+			id: 3, name: "c1",
+			resultCode: http.StatusBadRequest,
+			result: amznode.ErrorResponse{
+				Error: "the name 'c1' has already been taken under the parent with id #1",
+			},
+		},
+		{
+			id: 7, name: "c7",
+			resultCode: http.StatusOK,
+			resultNode: amznode.Node{
+				ID:       7,
+				ParentID: 6,
+				Name:     "c7",
+				RootID:   1,
+				Height:   4,
+			},
+		},
+	}
+
+	testFunc := func(t *testing.T) {
+		for i, test := range tests {
+			t.Run(strconv.Itoa(i), func(t *testing.T) {
+				r := sendRequest(t, h, "PATCH", fmt.Sprintf("/%d?name=%s", test.id, test.name))
+
+				assertResponse(t, r, test.resultCode, test.result)
+
+				if test.resultNode == nil {
+					return
+				}
+
+				r = sendRequest(t, h, "GET", fmt.Sprintf("/%d", test.id))
+				assertResponse(t, r, http.StatusOK, test.resultNode)
+			})
+		}
+	}
+
+	withReset(withTestNodes(testFunc, h))(t)
+}
+
+func TestAttributes(t *testing.T) {
+	h, withReset := setup(t)
+
+	tests := []struct {
+		method     string
+		path       string
+		body       string
+		resultCode int
+		result     string
+	}{
+		{
+			method:     "GET",
+			path:       "/4/attributes",
+			resultCode: http.StatusOK,
+			result:     `{}` + "\n",
+		},
+		{
+			method:     "PUT",
+			path:       "/4/attributes",
+			body:       `{"manager":"alice","floor":3}`,
+			resultCode: http.StatusOK,
+			result:     `{"floor":3,"manager":"alice"}` + "\n",
+		},
+		{
+			method:     "PUT",
+			path:       "/5/attributes",
+			body:       `{"manager":"bob","floor":3}`,
+			resultCode: http.StatusOK,
+			result:     `{"floor":3,"manager":"bob"}` + "\n",
+		},
+		{
+			method:     "PATCH",
+			path:       "/4/attributes",
+			body:       `{"cost":"cc-42","floor":null}`,
+			resultCode: http.StatusOK,
+			result:     `{"cost":"cc-42","manager":"alice"}` + "\n",
+		},
+		{
+			method:     "DELETE",
+			path:       "/4/attributes/cost",
+			resultCode: http.StatusOK,
+			result:     `{"manager":"alice"}` + "\n",
+		},
+		{
+			method:     "GET",
+			path:       "/4",
+			resultCode: http.StatusOK,
+			result: `{"id":4,"parent_id":2,"name":"c3","root_id":1,"height":2,` +
+				`"attributes":{"manager":"alice"}}` + "\n",
+		},
+		{
+			method:     "GET",
+			path:       "/2/children?attr.floor=3",
+			resultCode: http.StatusOK,
+			result: `[{"id":5,"parent_id":2,"name":"c4","root_id":1,"height":2,` +
+				`"attributes":{"floor":3,"manager":"bob"}}]` + "\n",
+		},
+		{
+			method:     "GET",
+			path:       "/2/children?attr.manager=carol",
+			resultCode: http.StatusOK,
+			result:     `[]` + "\n",
+		},
+		{
+			method:     "PUT",
+			path:       "/4/attributes",
+			body:       `["manager"]`,
+			resultCode: http.StatusBadRequest,
+			result:     `{"error":"the attributes must be a json object"}` + "\n",
+		},
+		{
+			method:     "PATCH",
+			path:       "/4/attributes",
+			resultCode: http.StatusBadRequest,
+			result:     `{"error":"the request body must not be empty"}` + "\n",
+		},
+		{
+			method:     "PUT",
+			path:       "/42/attributes",
+			body:       `{}`,
+			resultCode: http.StatusNotFound,
+			result:     `{"error":"Could not find node with ID 42"}` + "\n",
+		},
+		{
+			method:     "PUT",
+			path:       "/4/attributes?format=xml",
+			body:       `{"manager":"carol","floor":3}`,
+			resultCode: http.StatusOK,
+			result: xml.Header + `<attributes><attribute key="floor">3</attribute>` +
+				`<attribute key="manager">&#34;carol&#34;</attribute></attributes>` + "\n",
+		},
+		{
+			method:     "GET",
+			path:       "/4?format=xml",
+			resultCode: http.StatusOK,
+			result: xml.Header + `<node id="4" parent_id="2" name="c3" root_id="1" height="2"><attributes>` +
+				`<attribute key="floor">3</attribute><attribute key="manager">&#34;carol&#34;</attribute>` +
+				`</attributes></node>` + "\n",
+		},
+		{
+			method:     "PATCH",
+			path:       "/4/attributes?format=csv",
+			body:       `{"manager":"carol"}`,
+			resultCode: http.StatusNotAcceptable,
+			result:     "error\nthe response can't be encoded in the requested format\n",
+		},
+		{
+			method:     "DELETE",
+			path:       "/4/attributes/manager?format=text",
+			resultCode: http.StatusNotAcceptable,
+			result:     "error: the response can't be encoded in the requested format\n",
+		},
+		{
+			method:     "GET",
+			path:       "/4/attributes",
+			resultCode: http.StatusOK,
+			result:     `{"floor":3,"manager":"carol"}` + "\n",
+		},
+	}
+
+	testFunc := func(t *testing.T) {
+		for i, test := range tests {
+			t.Run(strconv.Itoa(i), func(t *testing.T) {
+				r := sendRequestBody(t, h, test.method, test.path, test.body)
+				assertStatusCode(t, r, test.resultCode)
+				body, err := ioutil.ReadAll(r.Body)
+				assert.NoError(t, err)
+				assert.Equal(t, test.result, string(body))
+			})
+		}
+	}
+
+	withReset(withTestNodes(testFunc, h))(t)
+}
+
+func TestPolicy(t *testing.T) {
+	h, withReset := setup(t)
+
+	tests := []struct {
+		method     string
+		path       string
+		body       string
+		resultCode int
+		result     string
+	}{
+		{
+			method:     "GET",
+			path:       "/1/policy",
+			resultCode: http.StatusOK,
+			result:     `{"max_height":0,"max_children":0}` + "\n",
+		},
+		{
+			method:     "PUT",
+			path:       "/1/policy",
+			body:       `{"max_height":4}`,
+			resultCode: http.StatusOK,
+			result:     `{"max_height":4,"max_children":0}` + "\n",
+		},
+		{
+			method:     "POST",
+			path:       "/7/c9",
+			resultCode: http.StatusBadRequest,
+			result:     `{"error":"the tree with root id 1 allows a max height of 4"}` + "\n",
+		},
+		{
+			method:     "PUT",
+			path:       "/5?parentID=4",
+			resultCode: http.StatusBadRequest,
+			result:     `{"error":"the tree with root id 1 allows a max height of 4"}` + "\n",
+		},
+		{
+			method:     "PUT",
+			path:       "/2/policy",
+			body:       `{"max_height":4}`,
+			resultCode: http.StatusBadRequest,
+			result:     `{"error":"the node with id 2 is not a root node"}` + "\n",
+		},
+		{
+			method:     "PUT",
+			path:       "/1/policy",
+			body:       `{"max_children":-1}`,
+			resultCode: http.StatusBadRequest,
+			result:     `{"error":"max_height and max_children must be greater than or equal 0"}` + "\n",
+		},
+		{
+			method:     "PUT",
+			path:       "/1/policy",
+			body:       `{"max_hieght":3}`,
+			resultCode: http.StatusBadRequest,
+			result:     `{"error":"json: unknown field \"max_hieght\""}` + "\n",
+		},
+		{
+			method:     "PUT",
+			path:       "/1/policy",
+			body:       `{"max_height":3} {"max_children":1}`,
+			resultCode: http.StatusBadRequest,
+			result:     `{"error":"the request body must hold a single policy"}` + "\n",
+		},
+		{
+			method:     "DELETE",
+			path:       "/1/policy",
+			resultCode: http.StatusOK,
+			result:     "",
+		},
+		{
+			method:     "POST",
+			path:       "/7/c9",
+			resultCode: http.StatusCreated,
+			result:     `{"id":8,"parent_id":7,"name":"c9","root_id":1,"height":5}` + "\n",
+		},
+		{
+			method:     "PUT",
+			path:       "/1/policy?format=text",
+			body:       `{"max_height":3}`,
+			resultCode: http.StatusNotAcceptable,
+			result:     "error: the response can't be encoded in the requested format\n",
+		},
+		{
+			method:     "GET",
+			path:       "/1/policy",
+			resultCode: http.StatusOK,
+			result:     `{"max_height":0,"max_children":0}` + "\n",
+		},
+	}
+
+	testFunc := func(t *testing.T) {
+		for i, test := range tests {
+			t.Run(strconv.Itoa(i), func(t *testing.T) {
+				r := sendRequestBody(t, h, test.method, test.path, test.body)
+				assertStatusCode(t, r, test.resultCode)
+				body, err := ioutil.ReadAll(r.Body)
+				assert.NoError(t, err)
+				assert.Equal(t, test.result, string(body))
+			})
+		}
+	}
+
+	withReset(withTestNodes(testFunc, h))(t)
+}
+
+func TestPosition(t *testing.T) {
+	h, withReset := setup(t)
+
+	tests := []struct {
+		method     string
+		path       string
+		resultCode int
+		result     string
+	}{
+		{
+			method:     "POST",
+			path:       "/1/c0?after=2",
+			resultCode: http.StatusCreated,
+			result:     `{"id":8,"parent_id":1,"name":"c0","root_id":1,"height":1,"position":2}` + "\n",
+		},
+		{
+			method:     "PUT",
+			path:       "/3/position?before=2",
+			resultCode: http.StatusOK,
+			result:     "",
+		},
+		{
+			method:     "GET",
+			path:       "/1/children?limit=2&cursor=MS9jMg",
+			resultCode: http.StatusOK,
+			result: `[{"id":2,"parent_id":1,"name":"c1","root_id":1,"height":1,"position":2},` +
+				`{"id":8,"parent_id":1,"name":"c0","root_id":1,"height":1,"position":3}]` + "\n",
+		},
+		{
+			method:     "PUT",
+			path:       "/4?parentID=1&after=3",
+			resultCode: http.StatusOK,
+			result:     "",
+		},
+		{
+			method:     "GET",
+			path:       "/1/children?limit=2",
+			resultCode: http.StatusOK,
+			result: `[{"id":3,"parent_id":1,"name":"c2","root_id":1,"height":1,"position":1},` +
+				`{"id":4,"parent_id":1,"name":"c3","root_id":1,"height":1,"position":2}]` + "\n",
+		},
+		{
+			method:     "PUT",
+			path:       "/3/position",
+			resultCode: http.StatusBadRequest,
+			result:     `{"error":"either before or after must be given"}` + "\n",
+		},
+		{
+			method:     "PUT",
+			path:       "/3/position?before=2&after=8",
+			resultCode: http.StatusBadRequest,
+			result:     `{"error":"only one of before and after can be given"}` + "\n",
+		},
+		{
+			method:     "PUT",
+			path:       "/3/position?before=5",
+			resultCode: http.StatusBadRequest,
+			result:     `{"error":"the node with id 5 is not a sibling, as it is not a child of the node with id 1"}` + "\n",
+		},
+		{
+			method:     "POST",
+			path:       "/1/c9?before=5",
+			resultCode: http.StatusBadRequest,
+			result:     `{"error":"the node with id 5 is not a sibling, as it is not a child of the node with id 1"}` + "\n",
+		},
+		{
+			method:     "PUT",
+			path:       "/42/position?before=2",
+			resultCode: http.StatusNotFound,
+			result:     `{"error":"Could not find node with ID 42"}` + "\n",
+		},
+	}
+
+	testFunc := func(t *testing.T) {
+		for i, test := range tests {
+			t.Run(strconv.Itoa(i), func(t *testing.T) {
+				r := sendRequest(t, h, test.method, test.path)
+				assertStatusCode(t, r, test.resultCode)
+				body, err := ioutil.ReadAll(r.Body)
+				assert.NoError(t, err)
+				assert.Equal(t, test.result, string(body))
+			})
+		}
+	}
+
+	withReset(withTestNodes(testFunc, h))(t)
+}
+
+func TestDelete(t *testing.T) {
+	h, withReset := setup(t)
+
+	tests := []struct {
+		id         int
+		resultCode int
+		deletedIDs []int
+	}{
+		{id: -1, resultCode: http.StatusBadRequest},
+		{id: 7, resultCode: http.StatusOK, deletedIDs: []int{7}},
+		{id: 5, resultCode: http.StatusOK, deletedIDs: []int{5, 6}},
+		{id: 1, resultCode: http.StatusOK, deletedIDs: []int{1, 2, 3, 4}},
+	}
+
+	testFunc := func(t *testing.T) {
+		for i, test := range tests {
+			t.Run(strconv.Itoa(i), func(t *testing.T) {
+				r := sendRequest(t, h, "DELETE", fmt.Sprintf("/%d", test.id))
+
+				assertResponse(t, r, test.resultCode, nil)
+
+				for _, id := range test.deletedIDs {
+					r = sendRequest(t, h, "GET", fmt.Sprintf("/%d", id))
+					if r.StatusCode != http.StatusNotFound {
+						t.Errorf("expected node with id to be not found, got code: %d", r.StatusCode)
+					}
+				}
+			})
+		}
+	}
+
+	withReset(withTestNodes(testFunc, h))(t)
+}
+
+func TestTrash(t *testing.T) {
+	h, withReset := setup(t)
+
+	c1 := amznode.Node{
+		ID:       2,
+		ParentID: 1,
+		Name:     "c1",
+		RootID:   1,
+		Height:   1,
+		Children: []*amznode.Node{
+			{ID: 4, ParentID: 2, Name: "c3", RootID: 1, Height: 2},
+			{ID: 5, ParentID: 2, Name: "c4", RootID: 1, Height: 2},
+		},
+	}
+
+	testFunc := func(t *testing.T) {
+		r := sendRequest(t, h, "DELETE", "/6")
+		assertStatusCode(t, r, http.StatusOK)
+		r = sendRequest(t, h, "DELETE", "/2")
+		assertStatusCode(t, r, http.StatusOK)
+
+		r = sendRequest(t, h, "GET", "/trash")
+		assertStatusCode(t, r, http.StatusOK)
+		var trash []amznode.TrashedNode
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&trash))
+		if assert.Len(t, trash, 2) {
+			assert.Equal(t, "c1", trash[0].Name)
+			assert.Equal(t, "c5", trash[1].Name)
+			assert.False(t, trash[0].DeletedAt.IsZero())
+		}
+
+		r = sendRequest(t, h, "POST", "/trash/4/restore")
+		assertResponse(t, r, http.StatusNotFound, amznode.ErrorResponse{
+			Error: "Could not find node with ID 4",
+		})
+		r = sendRequest(t, h, "POST", "/trash/6/restore")
+		assertResponse(t, r, http.StatusNotFound, amznode.ErrorResponse{
+			Error: "Could not find node with ID 5",
+		})
+		r = sendRequest(t, h, "POST", "/trash/2/restore")
+		assertResponse(t, r, http.StatusOK, c1)
+
+		r = sendRequest(t, h, "DELETE", "/trash/6")
+		assertStatusCode(t, r, http.StatusOK)
+		r = sendRequest(t, h, "DELETE", "/trash/6")
+		assertResponse(t, r, http.StatusNotFound, amznode.ErrorResponse{
+			Error: "Could not find node with ID 6",
+		})
+		r = sendRequest(t, h, "GET", "/trash")
+		assertListResponse(t, r, http.StatusOK, []amznode.Node{})
+	}
+
+	withReset(withTestNodes(testFunc, h))(t)
+}
+
+func TestPath(t *testing.T) {
+	h, withReset := setup(t)
+
+	tests := []struct {
+		method     string
+		path       string
+		resultCode int
+		result     interface{}
+	}{
+		{
+			method: "GET", path: "/path/root/c1/c4",
+			resultCode: http.StatusOK,
+			result: amznode.Node{
+				ID:       5,
+				ParentID: 2,
+				Name:     "c4",
+				RootID:   1,
+				Height:   2,
+				Children: []*amznode.Node{
+					{
+						ID:       6,
+						ParentID: 5,
+						Name:     "c5",
+						RootID:   1,
+						Height:   3,
+					},
+				},
+			},
+		},
+		{
+			method: "GET", path: "/path/root/c4",
+			resultCode: http.StatusNotFound,
+			result: amznode.ErrorResponse{
+				Error: "Could not find node with path 'root/c4'",
+			},
+		},
+		{
+			method: "GET", path: "/path/root/c1/c$",
+			resultCode: http.StatusBadRequest,
+			result: amznode.ErrorResponse{
+				Error: "paths must consist of names matching the regex /^[a-zA-Z\\d-_]+$/ separated by '/'",
+			},
+		},
+		{
+			method: "POST", path: "/path/root/c2/c7/c8",
+			resultCode: http.StatusCreated,
+			result: amznode.Node{
+				ID:       9,
+				ParentID: 8,
+				Name:     "c8",
+				RootID:   1,
+				Height:   3,
+			},
+		},
+		{
+			method: "GET", path: "/path/root/c2/c7",
+			resultCode: http.StatusOK,
+			result: amznode.Node{
+				ID:       8,
+				ParentID: 3,
+				Name:     "c7",
+				RootID:   1,
+				Height:   2,
+				Children: []*amznode.Node{
+					{
+						ID:       9,
+						ParentID: 8,
+						Name:     "c8",
+						RootID:   1,
+						Height:   3,
+					},
+				},
+			},
+		},
+		{
+			method: "DELETE", path: "/path/root/c2",
+			resultCode: http.StatusOK,
+		},
+		{
+			method: "GET", path: "/path/root/c2/c7",
+			resultCode: http.StatusNotFound,
+			result: amznode.ErrorResponse{
+				Error: "Could not find node with path 'root/c2/c7'",
+			},
+		},
+		{
+			method: "DELETE", path: "/path/root/c2",
+			resultCode: http.StatusNotFound,
+			result: amznode.ErrorResponse{
+				Error: "Could not find node with path 'root/c2'",
+			},
+		},
+	}
+
+	testFunc := func(t *testing.T) {
+		for i, test := range tests {
+			t.Run(strconv.Itoa(i), func(t *testing.T) {
+				r := sendRequest(t, h, test.method, test.path)
+				assertResponse(t, r, test.resultCode, test.result)
+			})
+		}
+	}
+
+	withReset(withTestNodes(testFunc, h))(t)
+}
+
+func createTestNodes(t *testing.T, h http.Handler) {
+	// 1(root)
+	//   2(c1)
+	//     4(c3)
+	//     5(c4)
+	//       6(c5)
+	//         7(c6)
+	//   3(c2)
+	nodes := []struct {
+		parentID int
+		name     string
+	}{
+		{parentID: 0, name: "root"}, // id: 1
+		{parentID: 1, name: "c1"},   // id: 2
+		{parentID: 1, name: "c2"},   // id: 3
+		{parentID: 2, name: "c3"},   // id: 4
+		{parentID: 2, name: "c4"},   // id: 5
+		{parentID: 5, name: "c5"},   // id: 6
+		{parentID: 6, name: "c6"},   // id: 7
+	}
+
+	// create nodes
+	for i, node := range nodes {
+		r := sendRequest(t, h, "POST", fmt.Sprintf("/%d/%s", node.parentID, node.name))
+		assert.Equal(t, http.StatusCreated, r.StatusCode, i)
+	}
+}
+
+func sendRequest(t *testing.T, handler http.Handler, method, path string) *http.Response {
+	return sendRequestBody(t, handler, method, path, "")
+}
+
+func sendRequestBody(t *testing.T, handler http.Handler, method, path, body string) *http.Response {
+	return sendRequestHeader(t, handler, method, path, body, nil)
+}
+
+func sendRequestHeader(t *testing.T, handler http.Handler, method, path, body string, header http.Header) *http.Response {
+	w := httptest.NewRecorder()
+	r, err := http.NewRequest(method, path, strings.NewReader(body))
+	assert.NoError(t, err, "could not create http request")
+	for key, values := range header {
+		r.Header[key] = values
+	}
 	handler.ServeHTTP(w, r)
 	return w.Result()
 }
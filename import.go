@@ -0,0 +1,66 @@
+package amznode
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+var errEmptyBody = errors.New("the request body must not be empty")
+
+// decodeTrees decodes either a single node or a list of nodes from json. The
+// returned bool is true if a single node was decoded.
+func decodeTrees(r io.Reader) ([]*Node, bool, error) {
+	br := bufio.NewReader(r)
+	first, err := peekNonSpace(br)
+	if err == io.EOF {
+		return nil, false, errEmptyBody
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	if first == '[' {
+		var trees []*Node
+		err := json.NewDecoder(br).Decode(&trees)
+		return trees, false, err
+	}
+	var tree Node
+	err = json.NewDecoder(br).Decode(&tree)
+	return []*Node{&tree}, true, err
+}
+
+// peekNonSpace returns the first byte which is not json whitespace without
+// consuming it.
+func peekNonSpace(br *bufio.Reader) (byte, error) {
+	for {
+		b, err := br.Peek(1)
+		if err != nil {
+			return 0, err
+		}
+		switch b[0] {
+		case ' ', '\t', '\r', '\n':
+			br.ReadByte()
+		default:
+			return b[0], nil
+		}
+	}
+}
+
+// validateTrees checks that all the nodes of the trees have valid names and
+// ids
+func validateTrees(trees []*Node) error {
+	for _, tree := range trees {
+		if tree == nil || !validName(tree.Name) {
+			return errInvalidName
+		}
+		if !validID(tree.ID) {
+			return errInvalidID
+		}
+		if err := validateTrees(tree.Children); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package memory
+
+import (
+	"sync"
+
+	"github.com/blacksails/amznode"
+)
+
+// Storage is an implementation of the `amznode.Storage` interface which keeps
+// all nodes in memory. It is safe for concurrent use.
+//
+// Node ids are handed out like a PostgreSQL sequence would hand them out in
+// `pg.Storage`. This means that an id is consumed even though a create fails
+// because of a name conflict. This keeps the two implementations
+// interchangeable, also when it comes to the ids of created nodes.
+type Storage struct {
+	mu       sync.RWMutex
+	lastID   int
+	nodes    map[int]*node
+	children map[int]map[string]int
+	policies map[int]amznode.Policy
+	trash    map[int]*node
+	types    *amznode.TypeSchema
+}
+
+// New instantiates a new empty Storage
+func New() *Storage {
+	return &Storage{
+		nodes: map[int]*node{},
+		// children maps a parent id to the ids of its children by name. Root
+		// nodes are registered under the parent id `0`.
+		children: map[int]map[string]int{},
+		// policies maps the ids of root nodes to their policies
+		policies: map[int]amznode.Policy{},
+		// trash holds the deleted nodes by id until they are purged. Deleted
+		// nodes are removed from the children of their live parents, while
+		// the children of deleted nodes are kept.
+		trash: map[int]*node{},
+	}
+}
+
+// SetTypeSchema implements `amznode.Storage.SetTypeSchema`
+func (s *Storage) SetTypeSchema(schema *amznode.TypeSchema) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.types = schema
+}
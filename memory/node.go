@@ -0,0 +1,107 @@
+package memory
+
+import (
+	"encoding/json"
+	"reflect"
+	"time"
+
+	"github.com/blacksails/amznode"
+)
+
+type node struct {
+	id         int
+	parentID   int
+	name       string
+	position   int
+	typ        string
+	attributes map[string]interface{}
+	// deletedAt is set for nodes in the trash, along with the id of the
+	// node whose deletion moved the node to the trash
+	deletedAt time.Time
+	trashID   int
+}
+
+func (n node) ToDomain() *amznode.Node {
+	return &amznode.Node{
+		ID:         n.id,
+		ParentID:   n.parentID,
+		Name:       n.name,
+		Position:   n.position,
+		Type:       n.typ,
+		Attributes: copyAttributes(n.attributes),
+	}
+}
+
+// before reports whether the node is ordered before a sibling with
+// `position` and `name`
+func (n node) before(position int, name string) bool {
+	if n.position != position {
+		return n.position < position
+	}
+	return n.name < name
+}
+
+// after reports whether the node is ordered after a sibling with `position`
+// and `name`
+func (n node) after(position int, name string) bool {
+	if n.position != position {
+		return n.position > position
+	}
+	return n.name > name
+}
+
+// hasAttributes returns true if the node has all of the attributes with
+// equal values. The attributes must be normalized.
+func (n node) hasAttributes(attrs map[string]interface{}) bool {
+	for key, value := range attrs {
+		if !reflect.DeepEqual(n.attributes[key], value) {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeAttributes round trips the attributes through json, such that they
+// hold the same types as the attributes of the pg storage, like float64 for
+// all numbers, and don't share any maps or slices with the caller. Empty
+// attributes are normalized to nil.
+func normalizeAttributes(attrs map[string]interface{}) (map[string]interface{}, error) {
+	if len(attrs) == 0 {
+		return nil, nil
+	}
+	b, err := json.Marshal(attrs)
+	if err != nil {
+		return nil, err
+	}
+	var normalized map[string]interface{}
+	err = json.Unmarshal(b, &normalized)
+	return normalized, err
+}
+
+// copyAttributes copies normalized attributes, such that the attributes of
+// the storage can't be changed through the copy.
+func copyAttributes(attrs map[string]interface{}) map[string]interface{} {
+	if attrs == nil {
+		return nil
+	}
+	return copyValue(attrs).(map[string]interface{})
+}
+
+func copyValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[key] = copyValue(value)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(v))
+		for i, value := range v {
+			s[i] = copyValue(value)
+		}
+		return s
+	default:
+		return v
+	}
+}
@@ -0,0 +1,1141 @@
+package memory
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/blacksails/amznode"
+)
+
+// Create implements `amznode.Storage.Create`
+func (s *Storage) Create(name string, parentID int, opts amznode.CreateOptions) (*amznode.Node, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n, err := s.create(0, name, parentID, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.get(n.id)
+}
+
+// create creates a node with the given id, or with a new id if `id` is 0.
+// The caller must hold the write lock.
+func (s *Storage) create(id int, name string, parentID int, opts amznode.CreateOptions) (*node, error) {
+	attrs, err := normalizeAttributes(opts.Attributes)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkNode(opts.Type, attrs, parentID, 0, 0); err != nil {
+		return nil, err
+	}
+	if err := s.checkPlacement(parentID, id, opts.Placement); err != nil {
+		return nil, err
+	}
+
+	if id == 0 {
+		s.lastID++
+		id = s.lastID
+	} else if s.lookup(id) != nil {
+		return nil, amznode.NewErrIDTaken(id)
+	} else if id > s.lastID {
+		s.lastID = id
+	}
+	if _, ok := s.children[parentID][name]; ok {
+		return nil, amznode.NewErrNameTaken(name, parentID)
+	}
+
+	n := &node{id: id, parentID: parentID, name: name, typ: opts.Type, attributes: attrs}
+	s.nodes[n.id] = n
+	s.addChild(n)
+	s.place(n, opts.Placement)
+
+	return n, nil
+}
+
+// checkNode checks that a node of type `typ` with the attributes `attrs` can
+// be placed under the node with `parentID` by the type schema and the policy
+// of the tree. `subtreeHeight` and `fanOut` are the shape of the subtree
+// below the node, see `amznode.Policy.CheckPlacement`. Both created and
+// restored nodes are checked, as the schema and the policy may have changed
+// since a node was deleted. The caller must hold at least a read lock.
+func (s *Storage) checkNode(typ string, attrs map[string]interface{}, parentID, subtreeHeight, fanOut int) error {
+	parentType := ""
+	if parentID != 0 {
+		parent, ok := s.nodes[parentID]
+		if !ok {
+			return amznode.NewErrNotFound(parentID)
+		}
+		parentType = parent.typ
+	}
+	if err := s.types.ValidatePlacement(typ, parentID, parentType); err != nil {
+		return err
+	}
+	if err := s.types.ValidateAttributes(typ, attrs); err != nil {
+		return err
+	}
+	return s.checkPolicy(parentID, subtreeHeight, fanOut)
+}
+
+// createTree creates a node along with all its children. The ids of the
+// nodes are kept if `preserveIDs` is set. Either the whole tree is created or
+// nothing is. The caller must hold the write lock.
+func (s *Storage) createTree(an *amznode.Node, parentID int, preserveIDs bool) (*node, error) {
+	id := 0
+	if preserveIDs {
+		id = an.ID
+	}
+	n, err := s.create(id, an.Name, parentID, amznode.CreateOptions{
+		Type:       an.Type,
+		Attributes: an.Attributes,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if an.Position != 0 {
+		n.position = an.Position
+	}
+	for _, child := range an.Children {
+		if _, err := s.createTree(child, n.id, preserveIDs); err != nil {
+			s.remove(n)
+			return nil, err
+		}
+	}
+	return n, nil
+}
+
+// Get implements `amznode.Storage.Get`
+func (s *Storage) Get(id int) (*amznode.Node, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.get(id)
+}
+
+// GetRec implements `amznode.Storage.GetRec`
+func (s *Storage) GetRec(id, depth int) (*amznode.Node, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.getRec(id, depth)
+}
+
+// GetAncestors implements `amznode.Storage.GetAncestors`
+func (s *Storage) GetAncestors(id int) ([]*amznode.Node, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	n, ok := s.nodes[id]
+	if !ok {
+		return nil, amznode.NewErrNotFound(id)
+	}
+
+	an := s.toDomain(n)
+	ancestors := make([]*amznode.Node, an.Height+1)
+	for i := an.Height; i >= 0; i-- {
+		ancestors[i] = n.ToDomain()
+		ancestors[i].RootID = an.RootID
+		ancestors[i].Height = i
+		n = s.nodes[n.parentID]
+	}
+
+	return ancestors, nil
+}
+
+// GetRoots implements `amznode.Storage.GetRoots`
+func (s *Storage) GetRoots() ([]*amznode.Node, error) {
+	return s.GetRootsRec(1)
+}
+
+// GetRootsRec implements `amznode.Storage.GetRootsRec`
+func (s *Storage) GetRootsRec(depth int) ([]*amznode.Node, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	roots := []*amznode.Node{}
+	for _, id := range s.children[0] {
+		root, err := s.getRec(id, depth)
+		if err != nil {
+			return nil, err
+		}
+		roots = append(roots, root)
+	}
+	amznode.SortSiblings(roots)
+
+	return roots, nil
+}
+
+// GetChildren implements `amznode.Storage.GetChildren`
+func (s *Storage) GetChildren(parentID, depth int, opts amznode.ListOptions) ([]*amznode.Node, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if parentID != 0 {
+		if _, ok := s.nodes[parentID]; !ok {
+			return nil, amznode.NewErrNotFound(parentID)
+		}
+	}
+
+	filter, err := normalizeAttributes(opts.Attributes)
+	if err != nil {
+		return nil, err
+	}
+	nodes := []*amznode.Node{}
+	for _, n := range s.siblings(parentID, 0) {
+		if !n.after(opts.AfterPosition, opts.After) || !n.hasAttributes(filter) {
+			continue
+		}
+		node, err := s.getRec(n.id, depth)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+		if opts.Limit > 0 && len(nodes) == opts.Limit {
+			break
+		}
+	}
+	return nodes, nil
+}
+
+// Search implements `amznode.Storage.Search`
+func (s *Storage) Search(query string, opts amznode.SearchOptions) ([]*amznode.SearchResult, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if opts.RootID != 0 {
+		root, ok := s.nodes[opts.RootID]
+		if !ok {
+			return nil, amznode.NewErrNotFound(opts.RootID)
+		}
+		if root.parentID != 0 {
+			return nil, amznode.NewErrNotRoot(opts.RootID)
+		}
+	}
+
+	results := []*amznode.SearchResult{}
+	for _, n := range s.nodes {
+		if !matches(n.name, query, opts.Match) {
+			continue
+		}
+		an := s.toDomain(n)
+		if opts.RootID != 0 && an.RootID != opts.RootID {
+			continue
+		}
+		results = append(results, &amznode.SearchResult{Node: *an, Path: s.path(n)})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Path < results[j].Path
+	})
+	if opts.Limit > 0 && len(results) > opts.Limit {
+		results = results[:opts.Limit]
+	}
+	return results, nil
+}
+
+func matches(name, query string, match amznode.Match) bool {
+	switch match {
+	case amznode.MatchExact:
+		return name == query
+	case amznode.MatchPrefix:
+		return strings.HasPrefix(name, query)
+	default:
+		return strings.Contains(name, query)
+	}
+}
+
+// path returns the slash separated names from the root down to the node. The
+// caller must hold the read lock.
+func (s *Storage) path(n *node) string {
+	names := []string{n.name}
+	for n.parentID != 0 {
+		n = s.nodes[n.parentID]
+		names = append([]string{n.name}, names...)
+	}
+	return amznode.JoinPath(names...)
+}
+
+// WalkRec implements `amznode.Storage.WalkRec`
+func (s *Storage) WalkRec(id, depth int, f func(*amznode.Node) error) error {
+	nodes, err := s.snapshotRec(id, depth)
+	if err != nil {
+		return err
+	}
+	return visit(nodes, f)
+}
+
+// snapshotRec returns the nodes visited by `WalkRec` in the order they are
+// visited. The nodes are copied while holding the read lock, such that f can
+// be called without holding it, as f may be slow or use the storage itself.
+func (s *Storage) snapshotRec(id, depth int) ([]*amznode.Node, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	n, ok := s.nodes[id]
+	if !ok {
+		return nil, amznode.NewErrNotFound(id)
+	}
+	return s.walkLevels([]*amznode.Node{s.toDomain(n)}, depth), nil
+}
+
+// WalkRootsRec implements `amznode.Storage.WalkRootsRec`
+func (s *Storage) WalkRootsRec(depth int, f func(*amznode.Node) error) error {
+	return visit(s.snapshotRootsRec(depth), f)
+}
+
+// snapshotRootsRec returns the nodes visited by `WalkRootsRec` in the order
+// they are visited, see `snapshotRec`.
+func (s *Storage) snapshotRootsRec(depth int) []*amznode.Node {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	roots := []*amznode.Node{}
+	for _, id := range s.children[0] {
+		roots = append(roots, s.toDomain(s.nodes[id]))
+	}
+	return s.walkLevels(roots, depth)
+}
+
+// visit calls f for each of the nodes until f returns an error
+func visit(nodes []*amznode.Node, f func(*amznode.Node) error) error {
+	for _, node := range nodes {
+		if err := f(node); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkLevels returns the nodes of the level and the levels below it, down to
+// `depth` levels below, one level at a time. The caller must hold the read
+// lock.
+func (s *Storage) walkLevels(level []*amznode.Node, depth int) []*amznode.Node {
+	nodes := []*amznode.Node{}
+	for len(level) > 0 {
+		sort.Slice(level, func(i, j int) bool {
+			if level[i].ParentID != level[j].ParentID {
+				return level[i].ParentID < level[j].ParentID
+			}
+			if level[i].Position != level[j].Position {
+				return level[i].Position < level[j].Position
+			}
+			return level[i].Name < level[j].Name
+		})
+
+		next := []*amznode.Node{}
+		for _, an := range level {
+			nodes = append(nodes, an)
+			if depth == 0 {
+				continue
+			}
+			for _, childID := range s.children[an.ID] {
+				child := s.nodes[childID].ToDomain()
+				child.RootID = an.RootID
+				child.Height = an.Height + 1
+				next = append(next, child)
+			}
+		}
+		level = next
+		depth--
+	}
+	return nodes
+}
+
+// ChangeParent implements `amznode.Storage.ChangeParent`
+func (s *Storage) ChangeParent(id, newParentID int, placement amznode.Placement) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n, ok := s.nodes[id]
+	if !ok {
+		return amznode.NewErrNotFound(id)
+	}
+	if _, ok := s.nodes[newParentID]; !ok {
+		return amznode.NewErrNotFound(newParentID)
+	}
+	if s.isDecendant(id, newParentID) {
+		return amznode.NewErrNodeIsDecendant(id, newParentID)
+	}
+	if n.parentID == newParentID {
+		if err := s.checkPlacement(newParentID, id, placement); err != nil {
+			return err
+		}
+		if !placement.IsZero() {
+			s.place(n, placement)
+		}
+		return nil
+	}
+	err := s.types.ValidatePlacement(n.typ, newParentID, s.nodes[newParentID].typ)
+	if err != nil {
+		return err
+	}
+	height, fanOut := s.shape(n)
+	if err := s.checkPolicy(newParentID, height, fanOut); err != nil {
+		return err
+	}
+	if _, ok := s.children[newParentID][n.name]; ok {
+		return amznode.NewErrNameTaken(n.name, newParentID)
+	}
+	if err := s.checkPlacement(newParentID, id, placement); err != nil {
+		return err
+	}
+
+	if n.parentID == 0 {
+		// the policy of a root is lost when it is moved into another tree
+		delete(s.policies, n.id)
+	}
+	s.removeChild(n)
+	n.parentID = newParentID
+	s.addChild(n)
+	s.place(n, placement)
+
+	return nil
+}
+
+// SetPosition implements `amznode.Storage.SetPosition`
+func (s *Storage) SetPosition(id int, placement amznode.Placement) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n, ok := s.nodes[id]
+	if !ok {
+		return amznode.NewErrNotFound(id)
+	}
+	if placement.IsZero() {
+		return nil
+	}
+	if err := s.checkPlacement(n.parentID, id, placement); err != nil {
+		return err
+	}
+	s.place(n, placement)
+
+	return nil
+}
+
+// checkPlacement checks that the sibling of the placement exists and is a
+// child of the node with `parentID`, other than the placed node with `id`.
+// The caller must hold at least a read lock.
+func (s *Storage) checkPlacement(parentID, id int, placement amznode.Placement) error {
+	if placement.IsZero() {
+		return nil
+	}
+	siblingID := placement.Sibling()
+	sibling, ok := s.nodes[siblingID]
+	if !ok {
+		return amznode.NewErrNotFound(siblingID)
+	}
+	if sibling.parentID != parentID || siblingID == id {
+		return amznode.NewErrNotSibling(siblingID, parentID)
+	}
+	return nil
+}
+
+// place sets the position of the node among its siblings. The zero placement
+// places the node after its siblings if they are ordered explicitly, and by
+// name otherwise. Otherwise the siblings are renumbered with the node next to
+// the sibling of the placement, which must have been checked with
+// `checkPlacement`. The caller must hold the write lock.
+func (s *Storage) place(n *node, placement amznode.Placement) {
+	siblings := s.siblings(n.parentID, n.id)
+	if placement.IsZero() {
+		n.position = 0
+		if len(siblings) > 0 && siblings[len(siblings)-1].position > 0 {
+			n.position = siblings[len(siblings)-1].position + 1
+		}
+		return
+	}
+
+	ids := make([]int, len(siblings))
+	for i, sibling := range siblings {
+		ids[i] = sibling.id
+	}
+	order, _ := placement.Order(ids, n.id)
+	for i, id := range order {
+		s.nodes[id].position = i + 1
+	}
+}
+
+// lastPosition returns the largest position of the children of the node
+// with `parentID`. The caller must hold at least a read lock.
+func (s *Storage) lastPosition(parentID int) int {
+	last := 0
+	for _, id := range s.children[parentID] {
+		if position := s.nodes[id].position; position > last {
+			last = position
+		}
+	}
+	return last
+}
+
+// siblings returns the children of the node with `parentID` ordered by
+// position and name, leaving out the node with id `exclude`. The caller must
+// hold at least a read lock.
+func (s *Storage) siblings(parentID, exclude int) []*node {
+	nodes := []*node{}
+	for _, id := range s.children[parentID] {
+		if id != exclude {
+			nodes = append(nodes, s.nodes[id])
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].before(nodes[j].position, nodes[j].name)
+	})
+	return nodes
+}
+
+// Rename implements `amznode.Storage.Rename`
+func (s *Storage) Rename(id int, newName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n, ok := s.nodes[id]
+	if !ok {
+		return amznode.NewErrNotFound(id)
+	}
+	if n.name == newName {
+		return nil
+	}
+	if _, ok := s.children[n.parentID][newName]; ok {
+		return amznode.NewErrNameTaken(newName, n.parentID)
+	}
+
+	s.removeChild(n)
+	n.name = newName
+	s.addChild(n)
+
+	return nil
+}
+
+// SetAttributes implements `amznode.Storage.SetAttributes`
+func (s *Storage) SetAttributes(id int, attrs map[string]interface{}) (map[string]interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n, ok := s.nodes[id]
+	if !ok {
+		return nil, amznode.NewErrNotFound(id)
+	}
+	normalized, err := normalizeAttributes(attrs)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.types.ValidateAttributes(n.typ, normalized); err != nil {
+		return nil, err
+	}
+	n.attributes = normalized
+
+	return copyAttributes(n.attributes), nil
+}
+
+// PatchAttributes implements `amznode.Storage.PatchAttributes`
+func (s *Storage) PatchAttributes(id int, patch map[string]interface{}) (map[string]interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n, ok := s.nodes[id]
+	if !ok {
+		return nil, amznode.NewErrNotFound(id)
+	}
+	normalized, err := normalizeAttributes(patch)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs := copyAttributes(n.attributes)
+	if attrs == nil {
+		attrs = map[string]interface{}{}
+	}
+	for key, value := range normalized {
+		if value == nil {
+			delete(attrs, key)
+			continue
+		}
+		attrs[key] = value
+	}
+	if len(attrs) == 0 {
+		attrs = nil
+	}
+	if err := s.types.ValidateAttributes(n.typ, attrs); err != nil {
+		return nil, err
+	}
+	n.attributes = attrs
+
+	return copyAttributes(n.attributes), nil
+}
+
+// Delete implements `amznode.Storage.Delete`
+func (s *Storage) Delete(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n, ok := s.nodes[id]
+	if !ok {
+		return amznode.NewErrNotFound(id)
+	}
+
+	s.moveToTrash(n)
+
+	return nil
+}
+
+// GetTrash implements `amznode.Storage.GetTrash`
+func (s *Storage) GetTrash() ([]*amznode.TrashedNode, error) {
+	return s.getTrash(false)
+}
+
+// GetTrashRec implements `amznode.Storage.GetTrashRec`
+func (s *Storage) GetTrashRec() ([]*amznode.TrashedNode, error) {
+	return s.getTrash(true)
+}
+
+func (s *Storage) getTrash(rec bool) ([]*amznode.TrashedNode, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	trash := []*amznode.TrashedNode{}
+	for _, n := range s.trash {
+		if n.trashID != n.id {
+			continue
+		}
+		an := s.toDomain(n)
+		if rec {
+			s.addTrashedChildrenRec(an)
+		}
+		trash = append(trash, &amznode.TrashedNode{Node: *an, DeletedAt: n.deletedAt})
+	}
+	sort.Slice(trash, func(i, j int) bool {
+		if !trash[i].DeletedAt.Equal(trash[j].DeletedAt) {
+			return trash[i].DeletedAt.After(trash[j].DeletedAt)
+		}
+		return trash[i].ID < trash[j].ID
+	})
+	return trash, nil
+}
+
+// Restore implements `amznode.Storage.Restore`
+func (s *Storage) Restore(id int) (*amznode.Node, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n, ok := s.trash[id]
+	if !ok || n.trashID != id {
+		return nil, amznode.NewErrNotFound(id)
+	}
+	height, fanOut := s.shape(n)
+	if err := s.checkNode(n.typ, n.attributes, n.parentID, height, fanOut); err != nil {
+		return nil, err
+	}
+	if err := s.checkTrashedChildren(n); err != nil {
+		return nil, err
+	}
+	if _, ok := s.children[n.parentID][n.name]; ok {
+		return nil, amznode.NewErrNameTaken(n.name, n.parentID)
+	}
+
+	s.restoreRec(n)
+	s.addChild(n)
+	// the siblings may have been reordered since the node was deleted
+	s.place(n, amznode.Placement{})
+
+	return s.get(id)
+}
+
+// Purge implements `amznode.Storage.Purge`
+func (s *Storage) Purge(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n, ok := s.trash[id]
+	if !ok || n.trashID != id {
+		return amznode.NewErrNotFound(id)
+	}
+
+	s.purgeRec(n)
+	// nodes deleted before an ancestor was deleted are not children of
+	// their parents, so they are purged once their parents are gone
+	for purged := true; purged; {
+		purged = false
+		for _, n := range s.trash {
+			if n.parentID != 0 && s.lookup(n.parentID) == nil {
+				s.purgeRec(n)
+				purged = true
+			}
+		}
+	}
+
+	return nil
+}
+
+// moveToTrash deletes the node along with its decendants by moving them to
+// the trash. The caller must hold the write lock.
+func (s *Storage) moveToTrash(n *node) {
+	s.removeChild(n)
+	s.trashRec(n, time.Now(), n.id)
+}
+
+// trashRec moves the node along with its decendants to the trash. The caller
+// must hold the write lock.
+func (s *Storage) trashRec(n *node, deletedAt time.Time, trashID int) {
+	for _, childID := range s.children[n.id] {
+		s.trashRec(s.nodes[childID], deletedAt, trashID)
+	}
+	n.deletedAt, n.trashID = deletedAt, trashID
+	delete(s.nodes, n.id)
+	s.trash[n.id] = n
+}
+
+// restoreRec moves the node along with its decendants out of the trash. The
+// caller must hold the write lock.
+func (s *Storage) restoreRec(n *node) {
+	for _, childID := range s.children[n.id] {
+		s.restoreRec(s.trash[childID])
+	}
+	n.deletedAt, n.trashID = time.Time{}, 0
+	delete(s.trash, n.id)
+	s.nodes[n.id] = n
+}
+
+// checkTrashedChildren checks the types and the attributes of the decendants
+// deleted along with the trashed node against the type schema, like
+// `checkNode` checks the node itself. The caller must hold at least a read
+// lock.
+func (s *Storage) checkTrashedChildren(n *node) error {
+	for _, childID := range s.children[n.id] {
+		child := s.trash[childID]
+		if err := s.types.ValidatePlacement(child.typ, n.id, n.typ); err != nil {
+			return err
+		}
+		if err := s.types.ValidateAttributes(child.typ, child.attributes); err != nil {
+			return err
+		}
+		if err := s.checkTrashedChildren(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// purgeRec removes the node along with its decendants from the trash. The
+// caller must hold the write lock.
+func (s *Storage) purgeRec(n *node) {
+	for _, childID := range s.children[n.id] {
+		s.purgeRec(s.trash[childID])
+	}
+	delete(s.children, n.id)
+	delete(s.trash, n.id)
+	delete(s.policies, n.id)
+}
+
+// addTrashedChildrenRec adds the decendants which were deleted along with the
+// deleted node. The caller must hold at least a read lock.
+func (s *Storage) addTrashedChildrenRec(an *amznode.Node) {
+	for _, childID := range s.children[an.ID] {
+		child := s.trash[childID].ToDomain()
+		child.RootID = an.RootID
+		child.Height = an.Height + 1
+		s.addTrashedChildrenRec(child)
+		an.Children = append(an.Children, child)
+	}
+	amznode.SortSiblings(an.Children)
+}
+
+// lookup returns the node with `id`, whether it is deleted or not, or nil if
+// there is no such node. The caller must hold at least a read lock.
+func (s *Storage) lookup(id int) *node {
+	if n, ok := s.nodes[id]; ok {
+		return n
+	}
+	return s.trash[id]
+}
+
+// Copy implements `amznode.Storage.Copy`
+func (s *Storage) Copy(id, parentID int, name string) (*amznode.Node, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	src, err := s.getRec(id, -1)
+	if err != nil {
+		return nil, err
+	}
+	if name != "" {
+		src.Name = name
+	}
+	// the copy is placed like a created node under its new parent
+	src.Position = 0
+
+	n, err := s.createTree(src, parentID, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.get(n.id)
+}
+
+// Import implements `amznode.Storage.Import`
+func (s *Storage) Import(parentID int, trees []*amznode.Node, opts amznode.ImportOptions) ([]*amznode.Node, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !opts.DeletedAt.IsZero() {
+		return s.importTrash(parentID, trees, opts)
+	}
+
+	// the trees keep their positions relative to each other, after the
+	// siblings which are already there
+	offset := s.lastPosition(parentID)
+	created := []*node{}
+	for _, tree := range trees {
+		n, err := s.createTree(tree, parentID, opts.PreserveIDs)
+		if err != nil {
+			for _, n := range created {
+				s.remove(n)
+			}
+			return nil, err
+		}
+		if tree.Position != 0 {
+			n.position = offset + tree.Position
+		}
+		created = append(created, n)
+	}
+
+	nodes := make([]*amznode.Node, len(created))
+	for i, n := range created {
+		node, err := s.getRec(n.id, -1)
+		if err != nil {
+			return nil, err
+		}
+		nodes[i] = node
+	}
+	return nodes, nil
+}
+
+// importTrash creates the trees in the trash as deleted at `opts.DeletedAt`.
+// The caller must hold the write lock.
+func (s *Storage) importTrash(parentID int, trees []*amznode.Node, opts amznode.ImportOptions) ([]*amznode.Node, error) {
+	if parentID != 0 && s.lookup(parentID) == nil {
+		return nil, amznode.NewErrNotFound(parentID)
+	}
+	if err := s.checkTrashTrees(parentID, trees, opts.PreserveIDs, map[int]bool{}); err != nil {
+		return nil, err
+	}
+
+	nodes := make([]*amznode.Node, len(trees))
+	for i, tree := range trees {
+		n := s.trashTree(tree, parentID, opts, 0)
+		an := s.toDomain(n)
+		s.addTrashedChildrenRec(an)
+		nodes[i] = an
+	}
+	return nodes, nil
+}
+
+// checkTrashTrees checks that the trees can be created in the trash, where
+// the names of siblings must be unique among the trees and preserved ids must
+// not be in use. The caller must hold at least a read lock.
+func (s *Storage) checkTrashTrees(parentID int, trees []*amznode.Node, preserveIDs bool, ids map[int]bool) error {
+	names := map[string]bool{}
+	for _, tree := range trees {
+		if names[tree.Name] {
+			return amznode.NewErrNameTaken(tree.Name, parentID)
+		}
+		names[tree.Name] = true
+		if preserveIDs && tree.ID != 0 {
+			if ids[tree.ID] || s.lookup(tree.ID) != nil {
+				return amznode.NewErrIDTaken(tree.ID)
+			}
+			ids[tree.ID] = true
+		}
+		if _, err := normalizeAttributes(tree.Attributes); err != nil {
+			return err
+		}
+		if err := s.checkTrashTrees(tree.ID, tree.Children, preserveIDs, ids); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// trashTree creates the node along with its children in the trash, where
+// they are deleted along with the node with `trashID`, or with the node itself
+// if `trashID` is 0. The caller must hold the write lock.
+func (s *Storage) trashTree(an *amznode.Node, parentID int, opts amznode.ImportOptions, trashID int) *node {
+	id := 0
+	if opts.PreserveIDs {
+		id = an.ID
+	}
+	if id == 0 {
+		s.lastID++
+		id = s.lastID
+	} else if id > s.lastID {
+		s.lastID = id
+	}
+	attrs, _ := normalizeAttributes(an.Attributes)
+
+	n := &node{
+		id:         id,
+		parentID:   parentID,
+		name:       an.Name,
+		position:   an.Position,
+		typ:        an.Type,
+		attributes: attrs,
+		deletedAt:  opts.DeletedAt,
+		trashID:    trashID,
+	}
+	// only the children of deleted nodes are kept, as in `moveToTrash`
+	if trashID == 0 {
+		n.trashID = id
+	} else {
+		s.addChild(n)
+	}
+	s.trash[id] = n
+	for _, child := range an.Children {
+		s.trashTree(child, id, opts, n.trashID)
+	}
+	return n
+}
+
+// GetPolicy implements `amznode.Storage.GetPolicy`
+func (s *Storage) GetPolicy(rootID int) (amznode.Policy, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if err := s.checkRoot(rootID); err != nil {
+		return amznode.Policy{}, err
+	}
+	return s.policies[rootID], nil
+}
+
+// SetPolicy implements `amznode.Storage.SetPolicy`
+func (s *Storage) SetPolicy(rootID int, policy amznode.Policy) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkRoot(rootID); err != nil {
+		return err
+	}
+	if policy == (amznode.Policy{}) {
+		delete(s.policies, rootID)
+		return nil
+	}
+	s.policies[rootID] = policy
+	return nil
+}
+
+// checkRoot checks that the node with `id` exists and is a root node. The
+// caller must hold at least a read lock.
+func (s *Storage) checkRoot(id int) error {
+	n, ok := s.nodes[id]
+	if !ok {
+		return amznode.NewErrNotFound(id)
+	}
+	if n.parentID != 0 {
+		return amznode.NewErrNotRoot(id)
+	}
+	return nil
+}
+
+// checkPolicy checks that a subtree with `subtreeHeight` levels below its
+// root, and at most `fanOut` children per node, can be placed under the node
+// with `parentID` without breaking the policy of its tree. Root nodes are not
+// limited by any policy. The caller must hold at least a read lock.
+func (s *Storage) checkPolicy(parentID, subtreeHeight, fanOut int) error {
+	if parentID == 0 {
+		return nil
+	}
+	parent := s.toDomain(s.nodes[parentID])
+	policy := s.policies[parent.RootID]
+	return policy.CheckPlacement(
+		parent.RootID, parent.Height, len(s.children[parentID]), subtreeHeight, fanOut)
+}
+
+// shape returns the number of levels below the node and the largest number
+// of children of the node or any of its decendants, also for deleted nodes.
+// The caller must hold at least a read lock.
+func (s *Storage) shape(n *node) (int, int) {
+	height, fanOut := 0, len(s.children[n.id])
+	for _, childID := range s.children[n.id] {
+		h, f := s.shape(s.lookup(childID))
+		if h+1 > height {
+			height = h + 1
+		}
+		if f > fanOut {
+			fanOut = f
+		}
+	}
+	return height, fanOut
+}
+
+// CreatePath implements `amznode.Storage.CreatePath`
+func (s *Storage) CreatePath(path string) (*amznode.Node, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names := amznode.SplitPath(path)
+	if len(names) == 0 {
+		return nil, amznode.NewErrPathNotFound(path)
+	}
+
+	id, resolved := s.resolvePath(names)
+	if resolved < len(names) {
+		parentType := ""
+		if id != 0 {
+			parentType = s.nodes[id].typ
+		}
+		if err := s.types.ValidatePlacement("", id, parentType); err != nil {
+			return nil, err
+		}
+		// the created nodes form a chain below the deepest existing node
+		created := len(names) - resolved
+		fanOut := 0
+		if created > 1 {
+			fanOut = 1
+		}
+		if err := s.checkPolicy(id, created-1, fanOut); err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range names[resolved:] {
+		s.lastID++
+		n := &node{id: s.lastID, parentID: id, name: name}
+		s.nodes[n.id] = n
+		s.addChild(n)
+		s.place(n, amznode.Placement{})
+		id = n.id
+	}
+
+	return s.get(id)
+}
+
+// GetByPath implements `amznode.Storage.GetByPath`
+func (s *Storage) GetByPath(path string) (*amznode.Node, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	names := amznode.SplitPath(path)
+	id, resolved := s.resolvePath(names)
+	if len(names) == 0 || resolved < len(names) {
+		return nil, amznode.NewErrPathNotFound(path)
+	}
+
+	return s.get(id)
+}
+
+// DeleteByPath implements `amznode.Storage.DeleteByPath`
+func (s *Storage) DeleteByPath(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names := amznode.SplitPath(path)
+	id, resolved := s.resolvePath(names)
+	if len(names) == 0 || resolved < len(names) {
+		return amznode.NewErrPathNotFound(path)
+	}
+
+	s.moveToTrash(s.nodes[id])
+
+	return nil
+}
+
+// resolvePath follows the names from the roots and returns the id of the
+// deepest existing node along with the number of names which were resolved.
+// The caller must hold at least a read lock.
+func (s *Storage) resolvePath(names []string) (int, int) {
+	id := 0
+	for i, name := range names {
+		childID, ok := s.children[id][name]
+		if !ok {
+			return id, i
+		}
+		id = childID
+	}
+	return id, len(names)
+}
+
+// remove removes the node along with all its decendants. The caller must hold
+// the write lock.
+func (s *Storage) remove(n *node) {
+	s.removeChild(n)
+	s.deleteRec(n)
+}
+
+func (s *Storage) deleteRec(n *node) {
+	for _, childID := range s.children[n.id] {
+		s.deleteRec(s.nodes[childID])
+	}
+	delete(s.children, n.id)
+	delete(s.nodes, n.id)
+	delete(s.policies, n.id)
+}
+
+// get returns the node with the given id along with its immediate children.
+// The caller must hold at least a read lock.
+func (s *Storage) get(id int) (*amznode.Node, error) {
+	return s.getRec(id, 1)
+}
+
+// getRec returns the node with the given id along with its decendants down to
+// `depth` levels below the node. The caller must hold at least a read lock.
+func (s *Storage) getRec(id, depth int) (*amznode.Node, error) {
+	n, ok := s.nodes[id]
+	if !ok {
+		return nil, amznode.NewErrNotFound(id)
+	}
+
+	an := s.toDomain(n)
+	s.addChildrenRec(an, depth)
+
+	return an, nil
+}
+
+func (s *Storage) addChildrenRec(an *amznode.Node, depth int) {
+	if depth == 0 {
+		return
+	}
+	for _, childID := range s.children[an.ID] {
+		child := s.nodes[childID].ToDomain()
+		child.RootID = an.RootID
+		child.Height = an.Height + 1
+		s.addChildrenRec(child, depth-1)
+		an.Children = append(an.Children, child)
+	}
+	amznode.SortSiblings(an.Children)
+}
+
+// toDomain converts the node to an `amznode.Node` with its root id and height
+// set, also for deleted nodes. The caller must hold at least a read lock.
+func (s *Storage) toDomain(n *node) *amznode.Node {
+	an := n.ToDomain()
+	cur := n
+	for cur.parentID != 0 {
+		cur = s.lookup(cur.parentID)
+		an.Height++
+	}
+	an.RootID = cur.id
+	return an
+}
+
+// isDecendant reports whether the node with `id` is placed in the tree which
+// has its root at the node with `treeRootID`. A node is considered a
+// decendant of itself. The caller must hold at least a read lock.
+func (s *Storage) isDecendant(treeRootID, id int) bool {
+	for cur := id; cur != 0; cur = s.nodes[cur].parentID {
+		if cur == treeRootID {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Storage) addChild(n *node) {
+	siblings, ok := s.children[n.parentID]
+	if !ok {
+		siblings = map[string]int{}
+		s.children[n.parentID] = siblings
+	}
+	siblings[n.name] = n.id
+}
+
+func (s *Storage) removeChild(n *node) {
+	delete(s.children[n.parentID], n.name)
+}
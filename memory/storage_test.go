@@ -0,0 +1,46 @@
+package memory_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/blacksails/amznode"
+	"github.com/blacksails/amznode/memory"
+	"github.com/blacksails/amznode/storagetest"
+)
+
+func TestStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) amznode.Storage {
+		return memory.New()
+	})
+}
+
+func TestWalkRecUnlocked(t *testing.T) {
+	s := memory.New()
+	root, err := s.Create("root", 0, amznode.CreateOptions{})
+	require.NoError(t, err)
+	_, err = s.Create("c1", root.ID, amznode.CreateOptions{})
+	require.NoError(t, err)
+
+	done := make(chan error)
+	go func() {
+		done <- s.WalkRec(root.ID, -1, func(node *amznode.Node) error {
+			// changing the storage while walking it must not deadlock
+			_, err := s.Create("copy", node.ID, amznode.CreateOptions{})
+			return err
+		})
+	}()
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("the walk holds the lock while calling f")
+	}
+
+	node, err := s.GetRec(root.ID, -1)
+	require.NoError(t, err)
+	assert.Len(t, node.Children, 2, "nodes created during the walk must not be walked")
+}
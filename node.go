@@ -2,12 +2,23 @@ package amznode
 
 // Node represents a node in the organization tree
 type Node struct {
-	ID       int     `json:"id"`
-	ParentID int     `json:"parent_id,omitempty"`
-	Name     string  `json:"name"`
-	RootID   int     `json:"root_id"`
-	Height   int     `json:"height"`
-	Children []*Node `json:"children,omitempty"`
+	ID       int    `json:"id" yaml:"id" xml:"id,attr"`
+	ParentID int    `json:"parent_id,omitempty" yaml:"parent_id,omitempty" xml:"parent_id,attr,omitempty"`
+	Name     string `json:"name" yaml:"name" xml:"name,attr"`
+	// Type is the type of the node in the `TypeSchema` of the storage, or
+	// empty for untyped nodes
+	Type   string `json:"type,omitempty" yaml:"type,omitempty" xml:"type,attr,omitempty"`
+	RootID int    `json:"root_id" yaml:"root_id" xml:"root_id,attr"`
+	Height int    `json:"height" yaml:"height" xml:"height,attr"`
+	// Position orders the node among its siblings, which are ordered by
+	// position and then by name. It is 0 unless the siblings have been
+	// ordered explicitly.
+	Position int `json:"position,omitempty" yaml:"position,omitempty" xml:"position,attr,omitempty"`
+	// Attributes are arbitrary values stored with the node. In xml they are
+	// encoded as elements holding the json encoding of their values, as
+	// xml has no representation of arbitrary values.
+	Attributes map[string]interface{} `json:"attributes,omitempty" yaml:"attributes,omitempty" xml:"-"`
+	Children   []*Node                `json:"children,omitempty" yaml:"children,omitempty" xml:"node"`
 }
 
 // IsRoot returns true if the node does not have a parent
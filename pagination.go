@@ -0,0 +1,111 @@
+package amznode
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// defaultPageLimit is the number of children in a page when no limit is
+	// given
+	defaultPageLimit = 100
+	// maxPageLimit is the largest number of children in a page
+	maxPageLimit = 1000
+)
+
+var errInvalidLimit = fmt.Errorf("limit must be between 1 and %d", maxPageLimit)
+var errInvalidCursor = errors.New("cursor is not valid")
+var errNotPaginated = errors.New(
+	"limit, cursor and attribute filters only apply to the roots and to the children of a node")
+
+// urlParamListOptions reads the page of children to get from the `limit`,
+// `cursor` and `attr.<key>` query parameters. The returned bool is false if
+// none of them are given.
+func urlParamListOptions(r *http.Request) (ListOptions, bool, error) {
+	query := r.URL.Query()
+	opts := ListOptions{Limit: defaultPageLimit}
+	attrs, err := urlParamAttributes(r)
+	if err != nil {
+		return opts, true, err
+	}
+	opts.Attributes = attrs
+
+	limitStr, cursor := query.Get("limit"), query.Get("cursor")
+	if limitStr == "" && cursor == "" && attrs == nil {
+		return opts, false, nil
+	}
+
+	if limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			return opts, true, errInvalidLimit
+		}
+		opts.Limit = limit
+	}
+	if cursor != "" {
+		position, after, err := decodeCursor(cursor)
+		if err != nil {
+			return opts, true, errInvalidCursor
+		}
+		opts.AfterPosition, opts.After = position, after
+	}
+	return opts, true, nil
+}
+
+// encodeCursor encodes the position and name of the last node of a page as an
+// opaque cursor, such that clients don't depend on how pages are found. Nodes
+// without a position are encoded by their name only.
+func encodeCursor(position int, after string) string {
+	if position != 0 {
+		after = strconv.Itoa(position) + "/" + after
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(after))
+}
+
+func decodeCursor(cursor string) (int, string, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, "", err
+	}
+	after, position := string(b), 0
+	// names can't contain slashes, so a slash separates the position
+	if i := strings.Index(after, "/"); i >= 0 {
+		position, err = strconv.Atoi(after[:i])
+		if err != nil || position < 1 {
+			return 0, "", errInvalidCursor
+		}
+		after = after[i+1:]
+	}
+	if !validName(after) {
+		return 0, "", errInvalidCursor
+	}
+	return position, after, nil
+}
+
+// getPage gets the page of children and sets the Link header of the response
+// to the next page, if there is one.
+func (s *server) getPage(w http.ResponseWriter, r *http.Request, parentID, depth int, opts ListOptions) ([]*Node, error) {
+	// one extra child is read to find out if there is a next page
+	limit := opts.Limit
+	opts.Limit++
+	nodes, err := s.storage.GetChildren(parentID, depth, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(nodes) <= limit {
+		return nodes, nil
+	}
+
+	nodes = nodes[:limit]
+	next := *r.URL
+	query := next.Query()
+	last := nodes[limit-1]
+	query.Set("cursor", encodeCursor(last.Position, last.Name))
+	next.RawQuery = query.Encode()
+	w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, next.RequestURI()))
+	return nodes, nil
+}
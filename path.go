@@ -0,0 +1,22 @@
+package amznode
+
+import "strings"
+
+// PathSeparator separates the node names of a path
+const PathSeparator = "/"
+
+// SplitPath splits a slash separated path into the names of the nodes it
+// consists of. Leading and trailing separators are ignored, so an empty path
+// results in no names.
+func SplitPath(path string) []string {
+	path = strings.Trim(path, PathSeparator)
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, PathSeparator)
+}
+
+// JoinPath joins node names into a slash separated path
+func JoinPath(names ...string) string {
+	return strings.Join(names, PathSeparator)
+}
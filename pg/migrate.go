@@ -0,0 +1,297 @@
+package pg
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// MigrationsTableName is the name of the table in which the versions of the
+// applied migrations are recorded
+const MigrationsTableName = "schema_migrations"
+
+// migration is a versioned change to the database schema. The statements of a
+// migration are run in the same transaction as the recording of its version.
+//
+// The migrations up until the migrations table was introduced used to be run
+// on every startup, so they must be able to run against a schema where they
+// have already been applied.
+type migration struct {
+	version     int
+	description string
+	statements  func(s *Storage) []string
+}
+
+// migrations must be kept in order of their version, and applied migrations
+// must never be changed. Add a new migration instead.
+var migrations = []migration{
+	{
+		version:     1,
+		description: "create nodes table",
+		statements: func(s *Storage) []string {
+			return []string{
+				fmt.Sprintf(`
+					CREATE TABLE IF NOT EXISTS %s (
+						id SERIAL PRIMARY KEY,
+						parentID INTEGER REFERENCES %s (id) NULL,
+						name TEXT NOT NULL,
+						UNIQUE (parentID, name)
+					)`, s.table(), s.table(),
+				),
+			}
+		},
+	},
+	{
+		version:     2,
+		description: "unique root names",
+		statements: func(s *Storage) []string {
+			// root nodes are not covered by the unique constraint of the
+			// nodes table, as their parentID is NULL. Tables created before
+			// this migration can have roots with the same name, so all but
+			// the oldest of them get their id appended to their name.
+			return []string{
+				fmt.Sprintf(`
+					UPDATE %s h
+					SET name = h.name || ' (' || h.id || ')'
+					WHERE h.parentID IS NULL AND EXISTS (
+						SELECT 1 FROM %s o
+						WHERE o.parentID IS NULL AND o.name = h.name AND o.id < h.id
+					)`, s.table(), s.table(),
+				),
+				fmt.Sprintf(`
+					CREATE UNIQUE INDEX IF NOT EXISTS nodes_root_name_key
+					ON %s (name) WHERE parentID IS NULL`, s.table(),
+				),
+			}
+		},
+	},
+	{
+		version:     3,
+		description: "denormalized rootID and height",
+		statements: func(s *Storage) []string {
+			return []string{
+				fmt.Sprintf(`
+					ALTER TABLE %s
+					ADD COLUMN IF NOT EXISTS rootID INTEGER,
+					ADD COLUMN IF NOT EXISTS height INTEGER NOT NULL DEFAULT 0`,
+					s.table(),
+				),
+				fmt.Sprintf(`
+					WITH RECURSIVE q AS (
+						SELECT h.id, h.id AS rootID, 0 AS height
+						FROM %s h
+						WHERE parentID IS NULL
+						UNION ALL
+						SELECT hc.id, q.rootID, q.height + 1
+						FROM q
+						JOIN %s hc
+						ON q.id = hc.parentID
+					)
+					UPDATE %s h
+					SET rootID = q.rootID, height = q.height
+					FROM q
+					WHERE h.id = q.id AND h.rootID IS NULL`,
+					s.table(), s.table(), s.table(),
+				),
+				fmt.Sprintf(`ALTER TABLE %s ALTER COLUMN rootID SET NOT NULL`, s.table()),
+				fmt.Sprintf(`
+					CREATE INDEX IF NOT EXISTS nodes_root_height_idx
+					ON %s (rootID, height)`, s.table(),
+				),
+			}
+		},
+	},
+	{
+		version:     4,
+		description: "closure table",
+		statements: func(s *Storage) []string {
+			return []string{
+				fmt.Sprintf(`
+					CREATE TABLE IF NOT EXISTS %s (
+						ancestor INTEGER NOT NULL REFERENCES %s (id) ON DELETE CASCADE,
+						descendant INTEGER NOT NULL REFERENCES %s (id) ON DELETE CASCADE,
+						depth INTEGER NOT NULL,
+						PRIMARY KEY (ancestor, descendant)
+					)`, s.closureTable(), s.table(), s.table(),
+				),
+				fmt.Sprintf(`
+					CREATE INDEX IF NOT EXISTS nodes_closure_descendant_idx
+					ON %s (descendant)`, s.closureTable(),
+				),
+			}
+		},
+	},
+	{
+		version:     5,
+		description: "node attributes",
+		statements: func(s *Storage) []string {
+			return []string{
+				fmt.Sprintf(`
+					ALTER TABLE %s
+					ADD COLUMN IF NOT EXISTS attributes JSONB NOT NULL DEFAULT '{}'`,
+					s.table(),
+				),
+				// the GIN index speeds up filtering by attributes with @>
+				fmt.Sprintf(`
+					CREATE INDEX IF NOT EXISTS nodes_attributes_idx
+					ON %s USING GIN (attributes)`, s.table(),
+				),
+			}
+		},
+	},
+	{
+		version:     6,
+		description: "node types",
+		statements: func(s *Storage) []string {
+			return []string{
+				fmt.Sprintf(`
+					ALTER TABLE %s
+					ADD COLUMN IF NOT EXISTS nodeType TEXT NOT NULL DEFAULT ''`,
+					s.table(),
+				),
+			}
+		},
+	},
+	{
+		version:     7,
+		description: "root policies",
+		statements: func(s *Storage) []string {
+			return []string{
+				fmt.Sprintf(`
+					CREATE TABLE IF NOT EXISTS %s (
+						rootID INTEGER PRIMARY KEY REFERENCES %s (id) ON DELETE CASCADE,
+						maxHeight INTEGER NOT NULL DEFAULT 0,
+						maxChildren INTEGER NOT NULL DEFAULT 0
+					)`, s.policiesTable(), s.table(),
+				),
+			}
+		},
+	},
+	{
+		version:     8,
+		description: "sibling positions",
+		statements: func(s *Storage) []string {
+			return []string{
+				fmt.Sprintf(`
+					ALTER TABLE %s
+					ADD COLUMN IF NOT EXISTS position INTEGER NOT NULL DEFAULT 0`,
+					s.table(),
+				),
+				// the index matches the order of the pages of children
+				fmt.Sprintf(`
+					CREATE INDEX IF NOT EXISTS nodes_parent_position_idx
+					ON %s (parentID, position, name COLLATE "C")`, s.table(),
+				),
+			}
+		},
+	},
+	{
+		version:     9,
+		description: "trash",
+		statements: func(s *Storage) []string {
+			// names only have to be unique among the nodes which are not
+			// deleted, so the unique constraints are replaced by partial
+			// unique indexes
+			return []string{
+				fmt.Sprintf(`
+					ALTER TABLE %s
+					ADD COLUMN IF NOT EXISTS deletedAt TIMESTAMPTZ NULL,
+					ADD COLUMN IF NOT EXISTS trashID INTEGER NULL`,
+					s.table(),
+				),
+				fmt.Sprintf(`
+					ALTER TABLE %s
+					DROP CONSTRAINT IF EXISTS %s`,
+					s.table(), pq.QuoteIdentifier(TableName+"_parentid_name_key"),
+				),
+				fmt.Sprintf(`
+					CREATE UNIQUE INDEX IF NOT EXISTS nodes_parent_name_key
+					ON %s (parentID, name) WHERE deletedAt IS NULL`, s.table(),
+				),
+				fmt.Sprintf(`
+					DROP INDEX IF EXISTS %s.nodes_root_name_key`,
+					pq.QuoteIdentifier(s.schema),
+				),
+				fmt.Sprintf(`
+					CREATE UNIQUE INDEX IF NOT EXISTS nodes_live_root_name_key
+					ON %s (name) WHERE parentID IS NULL AND deletedAt IS NULL`, s.table(),
+				),
+				fmt.Sprintf(`
+					CREATE INDEX IF NOT EXISTS nodes_trash_idx
+					ON %s (trashID) WHERE trashID IS NOT NULL`, s.table(),
+				),
+			}
+		},
+	},
+	{
+		version:     10,
+		description: "closure table state",
+		statements: func(s *Storage) []string {
+			// the closure table has been rebuilt on every start until now,
+			// so it is only known to be in sync once it has been rebuilt
+			return []string{
+				fmt.Sprintf(`
+					CREATE TABLE IF NOT EXISTS %s (
+						synced BOOLEAN NOT NULL
+					)`, s.closureStateTable(),
+				),
+				fmt.Sprintf(`INSERT INTO %s (synced) VALUES (false)`, s.closureStateTable()),
+			}
+		},
+	},
+}
+
+func (s Storage) migrationsTable() string {
+	return fmt.Sprintf(
+		"%s.%s", pq.QuoteIdentifier(s.schema), pq.QuoteIdentifier(MigrationsTableName))
+}
+
+// Migrate creates the schema if it doesn't exist and applies the migrations
+// which haven't been applied yet. The migrations are applied in a single
+// transaction while holding the exclusive tree lock, so concurrent calls are
+// safe.
+func (s *Storage) Migrate() error {
+	qs := []string{
+		fmt.Sprintf(`CREATE SCHEMA IF NOT EXISTS %s`, pq.QuoteIdentifier(s.schema)),
+		fmt.Sprintf(`
+			CREATE TABLE IF NOT EXISTS %s (
+				version INTEGER PRIMARY KEY,
+				description TEXT NOT NULL,
+				appliedAt TIMESTAMPTZ NOT NULL DEFAULT now()
+			)`, s.migrationsTable(),
+		),
+	}
+	for _, q := range qs {
+		if _, err := s.db.Exec(q); err != nil {
+			return err
+		}
+	}
+
+	return s.withTx(lockExclusive, func(tx *sql.Tx) error {
+		var version int
+		q := fmt.Sprintf(`SELECT COALESCE(MAX(version), 0) FROM %s`, s.migrationsTable())
+		if err := tx.QueryRow(q).Scan(&version); err != nil {
+			return err
+		}
+
+		for _, m := range migrations {
+			if m.version <= version {
+				continue
+			}
+			for _, q := range m.statements(s) {
+				if _, err := tx.Exec(q); err != nil {
+					return fmt.Errorf("migration %d (%s): %s", m.version, m.description, err)
+				}
+			}
+			q := fmt.Sprintf(
+				`INSERT INTO %s (version, description) VALUES ($1, $2)`,
+				s.migrationsTable(),
+			)
+			if _, err := tx.Exec(q, m.version, m.description); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
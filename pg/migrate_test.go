@@ -0,0 +1,98 @@
+package pg
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/blacksails/amznode"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrate(t *testing.T) {
+	s := connect(t)
+	reset(t, s)
+
+	// set up the nodes table as it looked before the migrations were
+	// introduced.
+	qs := []string{
+		fmt.Sprintf(`CREATE SCHEMA %s`, pq.QuoteIdentifier(s.schema)),
+		fmt.Sprintf(`
+			CREATE TABLE %s (
+				id SERIAL PRIMARY KEY,
+				parentID INTEGER REFERENCES %s (id) NULL,
+				name TEXT NOT NULL,
+				UNIQUE (parentID, name)
+			)`, s.table(), s.table(),
+		),
+		fmt.Sprintf(`INSERT INTO %s (parentID, name) VALUES (NULL, 'root')`, s.table()),
+		fmt.Sprintf(`INSERT INTO %s (parentID, name) VALUES (1, 'c1')`, s.table()),
+		fmt.Sprintf(`INSERT INTO %s (parentID, name) VALUES (2, 'c2')`, s.table()),
+	}
+	for _, q := range qs {
+		_, err := s.db.Exec(q)
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, s.Migrate())
+	require.NoError(t, s.Migrate(), "migrating twice must be a noop")
+
+	var version int
+	q := fmt.Sprintf(`SELECT MAX(version) FROM %s`, s.migrationsTable())
+	require.NoError(t, s.db.QueryRow(q).Scan(&version))
+	assert.Equal(t, migrations[len(migrations)-1].version, version)
+
+	node, err := s.Get(3)
+	require.NoError(t, err)
+	assert.Equal(t, 1, node.RootID, "the existing nodes must be backfilled")
+	assert.Equal(t, 2, node.Height)
+
+	node, err = s.Create("c3", 3, amznode.CreateOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 4, node.ID)
+	assert.Equal(t, 3, node.Height)
+}
+
+func TestMigrateDuplicateRoots(t *testing.T) {
+	s := connect(t)
+	reset(t, s)
+
+	// the nodes table did not require root names to be unique before the
+	// migrations were introduced
+	qs := []string{
+		fmt.Sprintf(`CREATE SCHEMA %s`, pq.QuoteIdentifier(s.schema)),
+		fmt.Sprintf(`
+			CREATE TABLE %s (
+				id SERIAL PRIMARY KEY,
+				parentID INTEGER REFERENCES %s (id) NULL,
+				name TEXT NOT NULL,
+				UNIQUE (parentID, name)
+			)`, s.table(), s.table(),
+		),
+		fmt.Sprintf(`INSERT INTO %s (parentID, name) VALUES (NULL, 'root')`, s.table()),
+		fmt.Sprintf(`INSERT INTO %s (parentID, name) VALUES (NULL, 'root')`, s.table()),
+		fmt.Sprintf(`INSERT INTO %s (parentID, name) VALUES (2, 'c1')`, s.table()),
+		fmt.Sprintf(`INSERT INTO %s (parentID, name) VALUES (NULL, 'root')`, s.table()),
+	}
+	for _, q := range qs {
+		_, err := s.db.Exec(q)
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, s.Migrate())
+
+	for id, name := range map[int]string{1: "root", 2: "root (2)", 3: "c1", 4: "root (4)"} {
+		node, err := s.Get(id)
+		require.NoError(t, err)
+		assert.Equal(t, name, node.Name)
+	}
+	_, err := s.Create("root", 0, amznode.CreateOptions{})
+	assert.Equal(t, amznode.NewErrNameTaken("root", 0), err)
+}
+
+func TestMigrationsOrder(t *testing.T) {
+	for i, m := range migrations {
+		assert.Equal(t, i+1, m.version, "migrations must be numbered consecutively")
+	}
+}
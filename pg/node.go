@@ -2,20 +2,72 @@ package pg
 
 import (
 	"database/sql"
+	"encoding/json"
 
 	"github.com/blacksails/amznode"
 )
 
 type node struct {
-	id       int
-	parentID sql.NullInt64
-	name     string
+	id         int
+	parentID   sql.NullInt64
+	rootID     int
+	name       string
+	height     int
+	attributes map[string]interface{}
+	nodeType   string
+	position   int
+}
+
+// scanNode scans a row with the columns of `nodeCols`, followed by any extra
+// columns which are scanned into `extra`
+func scanNode(rows *sql.Rows, extra ...interface{}) (node, error) {
+	var (
+		n     node
+		attrs []byte
+	)
+	dest := []interface{}{
+		&n.id, &n.parentID, &n.rootID, &n.name, &n.height, &attrs, &n.nodeType, &n.position,
+	}
+	err := rows.Scan(append(dest, extra...)...)
+	if err != nil {
+		return n, err
+	}
+	n.attributes, err = decodeAttributes(attrs)
+	return n, err
+}
+
+// encodeAttributes encodes attributes as a JSONB object. The object is
+// returned as a string, as lib/pq sends byte slices as bytea.
+func encodeAttributes(attrs map[string]interface{}) (string, error) {
+	if attrs == nil {
+		return "{}", nil
+	}
+	b, err := json.Marshal(attrs)
+	return string(b), err
+}
+
+// decodeAttributes decodes a JSONB object of attributes. Nodes without any
+// attributes have nil attributes.
+func decodeAttributes(b []byte) (map[string]interface{}, error) {
+	var attrs map[string]interface{}
+	if err := json.Unmarshal(b, &attrs); err != nil {
+		return nil, err
+	}
+	if len(attrs) == 0 {
+		return nil, nil
+	}
+	return attrs, nil
 }
 
 func (n node) ToDomain() *amznode.Node {
 	return &amznode.Node{
-		ID:       n.id,
-		ParentID: int(n.parentID.Int64),
-		Name:     n.name,
+		ID:         n.id,
+		ParentID:   int(n.parentID.Int64),
+		Name:       n.name,
+		Position:   n.position,
+		Type:       n.nodeType,
+		RootID:     n.rootID,
+		Height:     n.height,
+		Attributes: n.attributes,
 	}
 }
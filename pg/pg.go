@@ -3,6 +3,7 @@ package pg
 import (
 	"database/sql"
 	"fmt"
+	"sync/atomic"
 
 	"github.com/lib/pq"
 
@@ -11,13 +12,17 @@ import (
 
 // TableName is the name of the node table in the database
 const TableName = "nodes"
-const nodeCols = "id, parentID, rootID, name, height"
+const nodeCols = "id, parentID, rootID, name, height, attributes, nodeType, position"
 
 // Storage is an implementaion of the `amznode.Storage` interface backed by
 // PostgreSQL
 type Storage struct {
-	db     *sql.DB
-	schema string
+	db       *sql.DB
+	schema   string
+	strategy Strategy
+	// types holds the *amznode.TypeSchema, which can be replaced while the
+	// storage is in use
+	types atomic.Value
 }
 
 func (s Storage) table() string {
@@ -25,18 +30,20 @@ func (s Storage) table() string {
 		"%s.%s", pq.QuoteIdentifier(s.schema), pq.QuoteIdentifier(TableName))
 }
 
+// DefaultSchema is the schema used when no other schema is given
+const DefaultSchema = "amznode"
+
 // New instantiates a new Storage based on the given dataSourceName
-// string
+// string. The database schema is migrated to the latest version.
 func New(dataSourceName string) (*Storage, error) {
-	db, err := sql.Open("postgres", dataSourceName)
-	if err != nil {
-		return nil, err
-	}
-	if err := db.Ping(); err != nil {
-		return nil, err
-	}
-	// TODO: ensure that db and table is created
-	return &Storage{db: db, schema: "amznode"}, nil
+	return NewWithStrategy(dataSourceName, AdjacencyList)
+}
+
+// NewWithStrategy instantiates a new Storage based on the given
+// dataSourceName string, which uses the given strategy for querying the tree
+// structure. The database schema is migrated to the latest version.
+func NewWithStrategy(dataSourceName string, strategy Strategy) (*Storage, error) {
+	return newStorage(dataSourceName, DefaultSchema, strategy)
 }
 
 // NewFromEnv instantiates a new pg.Storage based on the following env
@@ -45,44 +52,61 @@ func New(dataSourceName string) (*Storage, error) {
 // - POSTGRES_USER
 // - POSTGRES_PASS
 // - POSTGRES_DB
+// - POSTGRES_SCHEMA
 // - POSTGRES_HOST
 // - POSTGRES_PORT
+// - POSTGRES_STRATEGY, either "adjacency" (default) or "closure"
 func NewFromEnv() (*Storage, error) {
 	dbUser := amznode.GetEnv("POSTGRES_USER", "postgres")
 	dbPass := amznode.GetEnv("POSTGRES_PASS", "postgres")
 	dbName := amznode.GetEnv("POSTGRES_DB", "postgres")
-	dbSchema := amznode.GetEnv("POSTGRES_SCHEMA", "amznode")
+	dbSchema := amznode.GetEnv("POSTGRES_SCHEMA", DefaultSchema)
 	dbHost := amznode.GetEnv("POSTGRES_HOST", "localhost")
 	dbPort := amznode.GetEnv("POSTGRES_PORT", "5432")
+	strategy, err := ParseStrategy(amznode.GetEnv("POSTGRES_STRATEGY", "adjacency"))
+	if err != nil {
+		return nil, err
+	}
 	dbConnStr := fmt.Sprintf(
 		"user=%s password=%s dbname=%s host=%s port=%s sslmode=disable",
 		dbUser, dbPass, dbName, dbHost, dbPort)
-	storage, err := New(dbConnStr)
+	return newStorage(dbConnStr, dbSchema, strategy)
+}
+
+func newStorage(dataSourceName, schema string, strategy Strategy) (*Storage, error) {
+	db, err := sql.Open("postgres", dataSourceName)
 	if err != nil {
-		return storage, err
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		return nil, err
 	}
-	storage.schema = dbSchema
-	err = ensureSchemaAndTableExists(storage.db, pq.QuoteIdentifier(dbSchema), storage.table())
-	return storage, err
+	storage := &Storage{db: db, schema: schema, strategy: strategy}
+	if err := storage.init(); err != nil {
+		return nil, err
+	}
+	return storage, nil
+}
+
+// SetTypeSchema implements `amznode.Storage.SetTypeSchema`
+func (s *Storage) SetTypeSchema(schema *amznode.TypeSchema) {
+	s.types.Store(schema)
+}
+
+// typeSchema returns the type schema of the storage, which is nil if none
+// has been set
+func (s *Storage) typeSchema() *amznode.TypeSchema {
+	schema, _ := s.types.Load().(*amznode.TypeSchema)
+	return schema
 }
 
-func ensureSchemaAndTableExists(db *sql.DB, schema, table string) error {
-	qs := []string{
-		fmt.Sprintf(`CREATE SCHEMA IF NOT EXISTS %s`, schema),
-		fmt.Sprintf(`
-			CREATE TABLE IF NOT EXISTS %s (
-				id SERIAL PRIMARY KEY,
-				parentID INTEGER REFERENCES %s (id) NULL,
-				name TEXT NOT NULL,
-				UNIQUE (parentID, name)
-			);`, table, table,
-		),
+// init migrates the database schema and prepares it for the strategy in use
+func (s *Storage) init() error {
+	if err := s.Migrate(); err != nil {
+		return err
 	}
-	for _, q := range qs {
-		_, err := db.Query(q)
-		if err != nil {
-			return err
-		}
+	if s.strategy == ClosureTable {
+		return s.syncClosureTable()
 	}
 	return nil
 }
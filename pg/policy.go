@@ -0,0 +1,138 @@
+package pg
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/blacksails/amznode"
+	"github.com/lib/pq"
+)
+
+// PoliciesTableName is the name of the table which holds the policies of the
+// root nodes
+const PoliciesTableName = "nodes_policies"
+
+func (s Storage) policiesTable() string {
+	return fmt.Sprintf(
+		"%s.%s", pq.QuoteIdentifier(s.schema), pq.QuoteIdentifier(PoliciesTableName))
+}
+
+// GetPolicy implements `amznode.Storage.GetPolicy`
+func (s *Storage) GetPolicy(rootID int) (amznode.Policy, error) {
+	if err := s.checkRoot(s.db, rootID); err != nil {
+		return amznode.Policy{}, err
+	}
+	return s.getPolicy(s.db, rootID)
+}
+
+func (s *Storage) getPolicy(db querier, rootID int) (amznode.Policy, error) {
+	q := fmt.Sprintf(`
+		SELECT maxHeight, maxChildren FROM %s WHERE rootID = $1`,
+		s.policiesTable(),
+	)
+	var policy amznode.Policy
+	err := db.QueryRow(q, rootID).Scan(&policy.MaxHeight, &policy.MaxChildren)
+	if err == sql.ErrNoRows {
+		return policy, nil
+	}
+	return policy, err
+}
+
+// SetPolicy implements `amznode.Storage.SetPolicy`
+func (s *Storage) SetPolicy(rootID int, policy amznode.Policy) error {
+	return s.withTx(lockExclusive, func(tx *sql.Tx) error {
+		if err := s.checkRoot(tx, rootID); err != nil {
+			return err
+		}
+		if policy == (amznode.Policy{}) {
+			return s.deletePolicy(tx, rootID)
+		}
+
+		q := fmt.Sprintf(`
+			INSERT INTO %s (rootID, maxHeight, maxChildren)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (rootID) DO UPDATE
+			SET maxHeight = EXCLUDED.maxHeight, maxChildren = EXCLUDED.maxChildren`,
+			s.policiesTable(),
+		)
+		_, err := tx.Exec(q, rootID, policy.MaxHeight, policy.MaxChildren)
+		return err
+	})
+}
+
+func (s *Storage) deletePolicy(db querier, rootID int) error {
+	q := fmt.Sprintf(`DELETE FROM %s WHERE rootID = $1`, s.policiesTable())
+	_, err := db.Exec(q, rootID)
+	return err
+}
+
+// checkRoot checks that the node with `id` exists and is a root node
+func (s *Storage) checkRoot(db querier, id int) error {
+	node, err := s.getRec(db, id, 0)
+	if err != nil {
+		return err
+	}
+	if !node.IsRoot() {
+		return amznode.NewErrNotRoot(id)
+	}
+	return nil
+}
+
+// checkPolicy checks that a subtree can be placed under `parent` without
+// breaking the policy of its tree. `shape` returns the number of levels below
+// the root of the subtree and the largest number of children of its nodes. It
+// is only called if the tree has a policy, and a nil `shape` is a single new
+// node.
+func (s *Storage) checkPolicy(db querier, parent *amznode.Node, shape func() (int, int, error)) error {
+	policy, err := s.getPolicy(db, parent.RootID)
+	if err != nil || policy == (amznode.Policy{}) {
+		return err
+	}
+
+	var subtreeHeight, fanOut int
+	if shape != nil {
+		subtreeHeight, fanOut, err = shape()
+		if err != nil {
+			return err
+		}
+	}
+
+	siblings := 0
+	if policy.MaxChildren > 0 {
+		// the parent is locked such that concurrent creates under it, which
+		// only hold the shared tree lock, can't both take the last place
+		q := fmt.Sprintf(`SELECT id FROM %s WHERE id = $1 FOR UPDATE`, s.table())
+		if _, err := db.Exec(q, parent.ID); err != nil {
+			return err
+		}
+		q = fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE parentID = $1 AND deletedAt IS NULL`, s.table())
+		if err := db.QueryRow(q, parent.ID).Scan(&siblings); err != nil {
+			return err
+		}
+	}
+
+	return policy.CheckPlacement(parent.RootID, parent.Height, siblings, subtreeHeight, fanOut)
+}
+
+// shape returns the number of levels below the node with `id` and the
+// largest number of children of the node or any of its decendants. If
+// `trashed` is set, the shape is found for the deleted node with `id` and the
+// decendants which were deleted along with it, as they would be restored.
+func (s *Storage) shape(db querier, id int, trashed bool) (int, int, error) {
+	// live nodes have no trash id
+	trashID := sql.NullInt64{Int64: int64(id), Valid: trashed}
+	q := fmt.Sprintf(`
+		SELECT COALESCE(MAX(d.depth), 0), COALESCE(MAX(c.children), 0)
+		FROM (%s) d
+		JOIN %s h
+		ON h.id = d.id AND h.trashID IS NOT DISTINCT FROM $2::int
+		CROSS JOIN LATERAL (
+			SELECT COUNT(*) AS children FROM %s hc
+			WHERE hc.parentID = d.id AND hc.trashID IS NOT DISTINCT FROM $2::int
+		) c
+	`, s.decendantsQuery("-1"), s.table(), s.table())
+
+	var height, fanOut int
+	err := db.QueryRow(q, id, trashID).Scan(&height, &fanOut)
+	return height, fanOut, err
+}
@@ -4,8 +4,8 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
-	"sort"
-	"sync"
+	"strings"
+	"time"
 
 	"github.com/blacksails/amznode"
 	"github.com/lib/pq"
@@ -14,65 +14,170 @@ import (
 const codeUniqueViolation = "23505"
 
 // Create implements `amznode.Storage.Create`
-func (s *Storage) Create(name string, parentID int) (*amznode.Node, error) {
+func (s *Storage) Create(name string, parentID int, opts amznode.CreateOptions) (*amznode.Node, error) {
+	// renumbering the siblings must not interleave with other changes to them
+	mode := lockShared
+	if !opts.Placement.IsZero() {
+		mode = lockExclusive
+	}
+
+	var node *amznode.Node
+	err := s.withTx(mode, func(tx *sql.Tx) error {
+		id, err := s.create(tx, 0, name, parentID, opts)
+		if err != nil {
+			return err
+		}
+		node, err = s.getRec(tx, id, 1)
+		return err
+	})
+	return node, err
+}
 
-	n := node{name: name}
+// create creates a node with the given id, or with the next id of the
+// sequence if `id` is 0.
+func (s *Storage) create(db querier, id int, name string, parentID int, opts amznode.CreateOptions) (int, error) {
+	n := node{name: name, nodeType: opts.Type}
 
+	var parent *amznode.Node
+	parentType := ""
 	if parentID != 0 {
 		// when we have a child node we need to fetch the parent to see
-		// that it exists and get the id of the root node.
-		parent, err := s.Get(parentID)
+		// that it exists.
+		var err error
+		parent, err = s.getRec(db, parentID, 0)
 		if err != nil {
-			return nil, err
+			return 0, err
 		}
 		n.parentID = sql.NullInt64{Int64: int64(parent.ID), Valid: true}
+		parentType = parent.Type
+	}
+	if err := s.typeSchema().ValidatePlacement(opts.Type, parentID, parentType); err != nil {
+		return 0, err
+	}
+	if err := s.typeSchema().ValidateAttributes(opts.Type, opts.Attributes); err != nil {
+		return 0, err
+	}
+	if parent != nil {
+		if err := s.checkPolicy(db, parent, nil); err != nil {
+			return 0, err
+		}
+	}
+	if err := s.checkPlacement(db, parentID, id, opts.Placement); err != nil {
+		return 0, err
 	}
 
-	q := fmt.Sprintf(`
-		INSERT INTO %s (parentID, name)
-		VALUES ($1, $2) RETURNING id`,
-		s.table(),
-	)
-
-	err := s.db.QueryRow(q, n.parentID, name).Scan(&n.id)
-	if err, ok := err.(*pq.Error); ok {
-		switch err.Code {
-		case codeUniqueViolation:
-			return nil, amznode.NewErrNameTaken(name, parentID)
+	newID, err := s.insert(db, id, n.parentID, name, n.nodeType, "")
+	if pqErr, ok := err.(*pq.Error); ok {
+		switch {
+		case pqErr.Code == codeUniqueViolation && pqErr.Constraint == TableName+"_pkey":
+			return 0, amznode.NewErrIDTaken(id)
+		case pqErr.Code == codeUniqueViolation:
+			return 0, amznode.NewErrNameTaken(name, parentID)
 		}
 	}
 	if err != nil {
-		return nil, err
+		return 0, err
+	}
+	if len(opts.Attributes) > 0 {
+		if _, err := s.setAttributes(db, newID, opts.Attributes); err != nil {
+			return 0, err
+		}
+	}
+	if !opts.Placement.IsZero() {
+		if err := s.place(db, newID, parentID, opts.Placement); err != nil {
+			return 0, err
+		}
+	}
+
+	return newID, nil
+}
+
+// createTree creates a node along with all its children and returns the id
+// of the node. The ids of the nodes are kept if `preserveIDs` is set.
+func (s *Storage) createTree(db querier, n *amznode.Node, parentID int, preserveIDs bool) (int, error) {
+	id := 0
+	if preserveIDs {
+		id = n.ID
+	}
+	id, err := s.create(db, id, n.Name, parentID, amznode.CreateOptions{
+		Type:       n.Type,
+		Attributes: n.Attributes,
+	})
+	if err != nil {
+		return 0, err
+	}
+	if n.Position != 0 {
+		q := fmt.Sprintf("UPDATE %s SET position = $2 WHERE id = $1", s.table())
+		if _, err := db.Exec(q, id, n.Position); err != nil {
+			return 0, err
+		}
+	}
+	for _, child := range n.Children {
+		if _, err := s.createTree(db, child, id, preserveIDs); err != nil {
+			return 0, err
+		}
+	}
+	return id, nil
+}
+
+// insert inserts a node and returns its id. The root id and height of the
+// node are derived from its parent, and it is placed after its siblings if
+// they are ordered explicitly, which is why the siblings are locked first.
+// `onConflict` is an optional ON CONFLICT clause.
+func (s *Storage) insert(db querier, id int, parentID sql.NullInt64, name, nodeType, onConflict string) (int, error) {
+	if err := s.lockSiblings(db, int(parentID.Int64)); err != nil {
+		return 0, err
 	}
+	q := fmt.Sprintf(`
+		INSERT INTO %s (id, parentID, name, nodeType, rootID, height, position)
+		SELECT n.id, $1::int, $2::text, $5::text, COALESCE(hp.rootID, n.id), COALESCE(hp.height + 1, 0), (%s)
+		FROM (
+			SELECT CASE
+				WHEN $4::int > 0 THEN $4::int
+				ELSE nextval(pg_get_serial_sequence($3, 'id'))::int
+			END AS id
+		) n
+		LEFT JOIN %s hp
+		ON hp.id = $1::int
+		%s
+		RETURNING id`,
+		s.table(), s.lastPositionQuery("$1::int", "0"), s.table(), onConflict,
+	)
 
-	return s.Get(n.id)
+	err := db.QueryRow(q, parentID, name, s.table(), id, nodeType).Scan(&id)
+	if err != nil {
+		return id, err
+	}
+	return id, s.afterInsert(db, id, parentID)
 }
 
 // Get implements `amznode.Storage.Get`
 func (s *Storage) Get(id int) (*amznode.Node, error) {
+	return s.GetRec(id, 1)
+}
+
+// GetRec implements `amznode.Storage.GetRec`
+func (s *Storage) GetRec(id, depth int) (*amznode.Node, error) {
+	return s.getRec(s.db, id, depth)
+}
+
+func (s *Storage) getRec(db querier, id, depth int) (*amznode.Node, error) {
 	q := fmt.Sprintf(`
-		WITH RECURSIVE q AS (
-			SELECT h.*, 1 AS level
-			FROM %s h
-			WHERE id = $1 OR parentID = $1
-			UNION ALL
-			SELECT hp.*, level + 1
-			FROM q
-			JOIN %s hp
-			ON hp.id = q.parentID
-		)
-		SELECT id, parentID, name
-		FROM q
-		ORDER BY level DESC
-	`, s.table(), s.table())
+		SELECT %s
+		FROM %s
+		WHERE id IN (SELECT id FROM (%s) d) AND deletedAt IS NULL
+	`, nodeCols, s.table(), s.decendantsQuery("$2::int"))
 
-	rows, err := s.db.Query(q, id)
-	defer rows.Close()
+	rows, err := db.Query(q, id, depth)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	_, nodesByID, err := loadRawNodes(rows)
+	if err != nil {
+		return nil, err
+	}
 	node, ok := nodesByID[id]
 	if !ok {
 		return nil, amznode.NewErrNotFound(id)
@@ -80,34 +185,67 @@ func (s *Storage) Get(id int) (*amznode.Node, error) {
 	return node, nil
 }
 
+// GetAncestors implements `amznode.Storage.GetAncestors`
+func (s *Storage) GetAncestors(id int) ([]*amznode.Node, error) {
+	q := fmt.Sprintf(`
+		SELECT %s
+		FROM %s
+		WHERE id IN (SELECT id FROM (%s) a) AND deletedAt IS NULL
+		ORDER BY height
+	`, nodeCols, s.table(), s.ancestorsQuery())
+
+	rows, err := s.db.Query(q, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ancestors := []*amznode.Node{}
+	for rows.Next() {
+		n, err := scanNode(rows)
+		if err != nil {
+			return nil, err
+		}
+		ancestors = append(ancestors, n.ToDomain())
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// the ancestors of a deleted node may not be deleted themselves
+	if len(ancestors) == 0 || ancestors[len(ancestors)-1].ID != id {
+		return nil, amznode.NewErrNotFound(id)
+	}
+	return ancestors, nil
+}
+
 // GetRoots implements `amznode.Storage.GetRoots`
 func (s *Storage) GetRoots() ([]*amznode.Node, error) {
-	t := s.table()
+	return s.GetRootsRec(1)
+}
+
+// GetRootsRec implements `amznode.Storage.GetRootsRec`
+func (s *Storage) GetRootsRec(depth int) ([]*amznode.Node, error) {
 	q := fmt.Sprintf(`
-		WITH q AS (
-			SELECT * 
-			FROM %s
-			WHERE parentID IS NULL
-		)
-		SELECT * FROM q
-		UNION ALL
-		SELECT * FROM %s WHERE parentID IN (SELECT id FROM q)
-	`, t, t)
+		SELECT %s
+		FROM %s
+		WHERE ($1::int < 0 OR height <= $1::int) AND deletedAt IS NULL
+	`, nodeCols, s.table())
 
-	rows, err := s.db.Query(q)
-	defer rows.Close()
+	rows, err := s.db.Query(q, depth)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	rootIDs, nodesByID, err := loadRawNodes(rows)
+	if err != nil {
+		return nil, err
+	}
 	roots := make([]*amznode.Node, len(rootIDs))
 	for i, id := range rootIDs {
 		roots[i] = nodesByID[id]
 	}
-	sort.SliceStable(roots, func(i, j int) bool {
-		return roots[i].Name < roots[j].Name
-	})
+	amznode.SortSiblings(roots)
 
 	return roots, nil
 }
@@ -117,109 +255,463 @@ func (s *Storage) GetRoots() ([]*amznode.Node, error) {
 // never happen.
 var ErrInvalidTree = errors.New("invalid tree")
 
+// loadRawNodes loads the nodes from the rows and adds them to the children of
+// their parents. The ids of the nodes whose parents are not among the rows are
+// returned as the top ids, e.g. the roots of the loaded subtrees.
 func loadRawNodes(rows *sql.Rows) ([]int, map[int]*amznode.Node, error) {
-	rootIDs := []int{}
+	topIDs := []int{}
 	nodesByID := map[int]*amznode.Node{}
 
 	// Load rows from SQL to domain Nodes
 	for rows.Next() {
-		var n node
-		if err := rows.Scan(&n.id, &n.parentID, &n.name); err != nil {
+		n, err := scanNode(rows)
+		if err != nil {
 			return nil, nil, err
 		}
-		an := n.ToDomain()
-		nodesByID[n.id] = an
+		nodesByID[n.id] = n.ToDomain()
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
 	}
 
-	// Register rootIDs and set children
+	// Register topIDs and set children
 	for _, node := range nodesByID {
-		if node.IsRoot() {
-			rootIDs = append(rootIDs, node.ID)
-			node.RootID = node.ID
-			continue
-		}
 		parent, ok := nodesByID[node.ParentID]
 		if !ok {
+			topIDs = append(topIDs, node.ID)
+			continue
+		}
+		if parent.Height+1 != node.Height || parent.RootID != node.RootID {
 			return nil, nil, ErrInvalidTree
 		}
 		parent.Children = append(parent.Children, node)
 	}
 
-	// Recursively build nodes
-	for _, rootID := range rootIDs {
-		node := nodesByID[rootID]
-		if node.IsRoot() {
-			buildRootNode(node)
+	// Recursively sort the children
+	for _, topID := range topIDs {
+		sortChildren(nodesByID[topID])
+	}
+
+	return topIDs, nodesByID, nil
+}
+
+// sortChildren recursively sorts the children of the node by position and
+// name, as the rows are read in no particular order.
+func sortChildren(node *amznode.Node) {
+	amznode.SortSiblings(node.Children)
+	for _, child := range node.Children {
+		sortChildren(child)
+	}
+}
+
+// GetChildren implements `amznode.Storage.GetChildren`. The page and the
+// subtrees of its nodes are read by a single query while holding the shared
+// tree lock, such that the page is consistent with the subtrees.
+func (s *Storage) GetChildren(parentID, depth int, opts amznode.ListOptions) ([]*amznode.Node, error) {
+	var parent sql.NullInt64
+	if parentID != 0 {
+		parent = sql.NullInt64{Int64: int64(parentID), Valid: true}
+	}
+	var limit sql.NullInt64
+	if opts.Limit > 0 {
+		limit = sql.NullInt64{Int64: int64(opts.Limit), Valid: true}
+	}
+
+	filter, err := encodeAttributes(opts.Attributes)
+	if err != nil {
+		return nil, err
+	}
+
+	page := fmt.Sprintf(`
+		SELECT id
+		FROM %s
+		WHERE parentID IS NOT DISTINCT FROM $1::int AND deletedAt IS NULL
+		AND (position > $5::int OR (position = $5::int AND name COLLATE "C" > $2::text))
+		AND attributes @> $4::jsonb
+		ORDER BY position, name COLLATE "C"
+		LIMIT $3::int
+	`, s.table())
+	q := fmt.Sprintf(`
+		SELECT %s
+		FROM %s
+		WHERE id IN (SELECT id FROM (%s) d) AND deletedAt IS NULL
+	`, nodeCols, s.table(), s.subtreesQuery(page, "$6::int"))
+
+	nodes := []*amznode.Node{}
+	err = s.withTx(lockShared, func(tx *sql.Tx) error {
+		if parentID != 0 {
+			if _, err := s.getRec(tx, parentID, 0); err != nil {
+				return err
+			}
+		}
+
+		rows, err := tx.Query(q, parent, opts.After, limit, filter, opts.AfterPosition, depth)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		topIDs, nodesByID, err := loadRawNodes(rows)
+		if err != nil {
+			return err
+		}
+		for _, id := range topIDs {
+			nodes = append(nodes, nodesByID[id])
+		}
+		amznode.SortSiblings(nodes)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return nodes, nil
+}
+
+// Search implements `amznode.Storage.Search`
+func (s *Storage) Search(query string, opts amznode.SearchOptions) ([]*amznode.SearchResult, error) {
+	if opts.RootID != 0 {
+		if err := s.checkRoot(s.db, opts.RootID); err != nil {
+			return nil, err
 		}
 	}
+	var limit sql.NullInt64
+	if opts.Limit > 0 {
+		limit = sql.NullInt64{Int64: int64(opts.Limit), Valid: true}
+	}
+
+	// the paths are found by walking up from each of the matching nodes
+	q := fmt.Sprintf(`
+		WITH RECURSIVE hits AS (
+			SELECT %s
+			FROM %s
+			WHERE name LIKE $1::text ESCAPE '\' AND ($2::int = 0 OR rootID = $2::int)
+			AND deletedAt IS NULL
+		), up AS (
+			SELECT id AS hit, parentID, name, height
+			FROM hits
+			UNION ALL
+			SELECT up.hit, hp.parentID, hp.name, hp.height
+			FROM up
+			JOIN %s hp
+			ON hp.id = up.parentID
+		)
+		SELECT %s, p.path
+		FROM hits
+		JOIN (
+			SELECT hit, string_agg(name, $3::text ORDER BY height) AS path
+			FROM up
+			GROUP BY hit
+		) p
+		ON p.hit = hits.id
+		ORDER BY p.path COLLATE "C"
+		LIMIT $4::int
+	`, nodeCols, s.table(), s.table(), nodeCols)
+
+	rows, err := s.db.Query(q, likePattern(query, opts.Match), opts.RootID, amznode.PathSeparator, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []*amznode.SearchResult{}
+	for rows.Next() {
+		var path string
+		n, err := scanNode(rows, &path)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, &amznode.SearchResult{Node: *n.ToDomain(), Path: path})
+	}
+	return results, rows.Err()
+}
 
-	return rootIDs, nodesByID, nil
+// likePattern returns a LIKE pattern for the query, where the wildcards of
+// LIKE are escaped
+func likePattern(query string, match amznode.Match) string {
+	pattern := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(query)
+	switch match {
+	case amznode.MatchExact:
+		return pattern
+	case amznode.MatchPrefix:
+		return pattern + "%"
+	default:
+		return "%" + pattern + "%"
+	}
 }
 
-func buildRootNode(rootNode *amznode.Node) {
-	var wg sync.WaitGroup
-	var auxFunc func(node *amznode.Node, height int)
-	auxFunc = func(node *amznode.Node, height int) {
-		node.Height = height
-		node.RootID = rootNode.ID
-		// this sort is mostly here to ensure deterministic behavior, which
-		// makes testing easier.
-		sort.SliceStable(node.Children, func(i, j int) bool {
-			return node.Children[i].Name < node.Children[j].Name
-		})
-		for _, child := range node.Children {
-			wg.Add(1)
-			go func(node *amznode.Node) {
-				defer wg.Done()
-				auxFunc(node, height+1)
-			}(child)
+// WalkRec implements `amznode.Storage.WalkRec`
+func (s *Storage) WalkRec(id, depth int, f func(*amznode.Node) error) error {
+	q := fmt.Sprintf(`
+		SELECT %s
+		FROM %s
+		WHERE id IN (SELECT id FROM (%s) d) AND deletedAt IS NULL
+		ORDER BY %s
+	`, nodeCols, s.table(), s.decendantsQuery("$2::int"), walkOrder)
+
+	rows, err := s.db.Query(q, id, depth)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	visited, err := walkRows(rows, f)
+	if err != nil {
+		return err
+	}
+	if visited == 0 {
+		return amznode.NewErrNotFound(id)
+	}
+	return nil
+}
+
+// WalkRootsRec implements `amznode.Storage.WalkRootsRec`
+func (s *Storage) WalkRootsRec(depth int, f func(*amznode.Node) error) error {
+	q := fmt.Sprintf(`
+		SELECT %s
+		FROM %s
+		WHERE ($1::int < 0 OR height <= $1::int) AND deletedAt IS NULL
+		ORDER BY %s
+	`, nodeCols, s.table(), walkOrder)
+
+	rows, err := s.db.Query(q, depth)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	_, err = walkRows(rows, f)
+	return err
+}
+
+// walkOrder orders nodes level by level. The names are compared bytewise
+// like the sorting of children, regardless of the collation of the database.
+const walkOrder = `height, parentID NULLS FIRST, position, name COLLATE "C"`
+
+// walkRows calls f for every node of the rows as they are read and returns
+// the number of nodes visited.
+func walkRows(rows *sql.Rows, f func(*amznode.Node) error) (int, error) {
+	visited := 0
+	for rows.Next() {
+		n, err := scanNode(rows)
+		if err != nil {
+			return visited, err
+		}
+		visited++
+		if err := f(n.ToDomain()); err != nil {
+			return visited, err
 		}
 	}
-	auxFunc(rootNode, 0)
-	wg.Wait()
+	return visited, rows.Err()
 }
 
 // ChangeParent implements amznode.Storage.ChangeParent
-func (s *Storage) ChangeParent(id, newParentID int) error {
-	_, err := s.Get(id)
+func (s *Storage) ChangeParent(id, newParentID int, placement amznode.Placement) error {
+	return s.withTx(lockExclusive, func(tx *sql.Tx) error {
+		node, err := s.getRec(tx, id, 0)
+		if err != nil {
+			return err
+		}
+		parent, err := s.getRec(tx, newParentID, 0)
+		if err != nil {
+			return err
+		}
+
+		isDecendant, err := s.isDecendant(tx, id, newParentID)
+		if err != nil {
+			return err
+		}
+		if isDecendant {
+			return amznode.NewErrNodeIsDecendant(id, newParentID)
+		}
+		if node.ParentID == newParentID {
+			if err := s.checkPlacement(tx, newParentID, id, placement); err != nil {
+				return err
+			}
+			if placement.IsZero() {
+				return nil
+			}
+			return s.place(tx, id, newParentID, placement)
+		}
+		err = s.typeSchema().ValidatePlacement(node.Type, newParentID, parent.Type)
+		if err != nil {
+			return err
+		}
+		err = s.checkPolicy(tx, parent, func() (int, int, error) {
+			return s.shape(tx, id, false)
+		})
+		if err != nil {
+			return err
+		}
+		if err := s.checkPlacement(tx, newParentID, id, placement); err != nil {
+			return err
+		}
+		if node.IsRoot() {
+			// the policy of a root is lost when it is moved into another tree
+			if err := s.deletePolicy(tx, id); err != nil {
+				return err
+			}
+		}
+
+		q := fmt.Sprintf("UPDATE %s SET parentID = $1 WHERE id = $2", s.table())
+		_, err = tx.Exec(q, newParentID, id)
+		if err, ok := err.(*pq.Error); ok {
+			switch err.Code {
+			case codeUniqueViolation:
+				return amznode.NewErrNameTaken(node.Name, newParentID)
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := s.afterMove(tx, id, newParentID); err != nil {
+			return err
+		}
+
+		// the root id and height of the whole subtree follow the new parent
+		q = fmt.Sprintf(`
+			UPDATE %s h
+			SET rootID = hp.rootID, height = hp.height + 1 + d.depth
+			FROM (%s) d, %s hp
+			WHERE h.id = d.id AND hp.id = $2
+		`, s.table(), s.decendantsQuery("-1"), s.table())
+		if _, err := tx.Exec(q, id, newParentID); err != nil {
+			return err
+		}
+		return s.place(tx, id, newParentID, placement)
+	})
+}
+
+// SetPosition implements amznode.Storage.SetPosition
+func (s *Storage) SetPosition(id int, placement amznode.Placement) error {
+	return s.withTx(lockExclusive, func(tx *sql.Tx) error {
+		node, err := s.getRec(tx, id, 0)
+		if err != nil {
+			return err
+		}
+		if placement.IsZero() {
+			return nil
+		}
+		if err := s.checkPlacement(tx, node.ParentID, id, placement); err != nil {
+			return err
+		}
+		return s.place(tx, id, node.ParentID, placement)
+	})
+}
+
+// checkPlacement checks that the sibling of the placement exists and is a
+// child of the node with `parentID`, other than the placed node with `id`.
+func (s *Storage) checkPlacement(db querier, parentID, id int, placement amznode.Placement) error {
+	if placement.IsZero() {
+		return nil
+	}
+	sibling, err := s.getRec(db, placement.Sibling(), 0)
 	if err != nil {
 		return err
 	}
-	_, err = s.Get(newParentID)
-	if err != nil {
+	if sibling.ParentID != parentID || sibling.ID == id {
+		return amznode.NewErrNotSibling(sibling.ID, parentID)
+	}
+	return nil
+}
+
+// place sets the position of the node with `id` among the children of the
+// node with `parentID`. The zero placement places the node after its siblings
+// if they are ordered explicitly, and by name otherwise. Otherwise the
+// siblings are renumbered with the node next to the sibling of the placement,
+// which must have been checked with `checkPlacement`. Renumbering must run
+// under the exclusive lock.
+func (s *Storage) place(db querier, id, parentID int, placement amznode.Placement) error {
+	var parent sql.NullInt64
+	if parentID != 0 {
+		parent = sql.NullInt64{Int64: int64(parentID), Valid: true}
+	}
+
+	if placement.IsZero() {
+		q := fmt.Sprintf(
+			"UPDATE %s SET position = (%s) WHERE id = $2",
+			s.table(), s.lastPositionQuery("$1::int", "$2::int"),
+		)
+		_, err := db.Exec(q, parent, id)
 		return err
 	}
 
-	isDecendant, err := s.isDecendant(id, newParentID)
+	q := fmt.Sprintf(`
+		SELECT id FROM %s
+		WHERE parentID IS NOT DISTINCT FROM $1::int AND id <> $2::int AND deletedAt IS NULL
+		ORDER BY position, name COLLATE "C"
+	`, s.table())
+	rows, err := db.Query(q, parent, id)
 	if err != nil {
 		return err
 	}
-	if isDecendant {
-		return amznode.NewErrNodeIsDecendant(id, newParentID)
+	defer rows.Close()
+	siblings := []int{}
+	for rows.Next() {
+		var siblingID int
+		if err := rows.Scan(&siblingID); err != nil {
+			return err
+		}
+		siblings = append(siblings, siblingID)
+	}
+	if err := rows.Err(); err != nil {
+		return err
 	}
 
-	q := fmt.Sprintf("UPDATE %s SET parentID = $1 WHERE id = $2", s.table())
-	_, err = s.db.Exec(q, newParentID, id)
+	order, _ := placement.Order(siblings, id)
+	positions := make([]int64, len(order))
+	ids := make([]int64, len(order))
+	for i, siblingID := range order {
+		ids[i], positions[i] = int64(siblingID), int64(i+1)
+	}
+	q = fmt.Sprintf(`
+		UPDATE %s h SET position = v.position
+		FROM unnest($1::int[], $2::int[]) AS v(id, position)
+		WHERE h.id = v.id
+	`, s.table())
+	_, err = db.Exec(q, pq.Array(ids), pq.Array(positions))
 	return err
 }
 
-func (s *Storage) isDecendant(treeRootID, id int) (bool, error) {
+// lastPositionQuery returns a query for the position of a node placed after
+// the children of `parentID`, leaving out the node with id `exclude`. The
+// position is 0 if the children are not ordered explicitly.
+func (s *Storage) lastPositionQuery(parentID, exclude string) string {
+	return fmt.Sprintf(`
+		SELECT CASE WHEN MAX(position) > 0 THEN MAX(position) + 1 ELSE 0 END
+		FROM %s
+		WHERE parentID IS NOT DISTINCT FROM %s AND id <> %s AND deletedAt IS NULL`,
+		s.table(), parentID, exclude,
+	)
+}
+
+// Rename implements amznode.Storage.Rename
+func (s *Storage) Rename(id int, newName string) error {
+	return s.withTx(lockShared, func(tx *sql.Tx) error {
+		node, err := s.getRec(tx, id, 0)
+		if err != nil {
+			return err
+		}
+
+		q := fmt.Sprintf("UPDATE %s SET name = $1 WHERE id = $2", s.table())
+		_, err = tx.Exec(q, newName, id)
+		if err, ok := err.(*pq.Error); ok {
+			switch err.Code {
+			case codeUniqueViolation:
+				return amznode.NewErrNameTaken(newName, node.ParentID)
+			}
+		}
+		return err
+	})
+}
+
+func (s *Storage) isDecendant(db querier, treeRootID, id int) (bool, error) {
 	q := fmt.Sprintf(`
-		WITH RECURSIVE q AS (
-			SELECT h.*
-			FROM %s h
-			WHERE id = $1
-			UNION ALL
-			SELECT hc.*
-			FROM q
-			JOIN %s hc
-			ON q.id = hc.parentID
-		)
-		SELECT COUNT(*) FROM q WHERE id = $2
-	`, s.table(), s.table())
+		SELECT COUNT(*) FROM (%s) d WHERE id = $2
+	`, s.decendantsQuery("-1"))
 
 	var count int
-	err := s.db.QueryRow(q, treeRootID, id).Scan(&count)
+	err := db.QueryRow(q, treeRootID, id).Scan(&count)
 	if err != nil {
 		return false, err
 	}
@@ -230,22 +722,674 @@ func (s *Storage) isDecendant(treeRootID, id int) (bool, error) {
 	return true, nil
 }
 
-// Delete implements amznode.Storage.Delete
-func (s *Storage) Delete(id int) error {
+// SetAttributes implements `amznode.Storage.SetAttributes`
+func (s *Storage) SetAttributes(id int, attrs map[string]interface{}) (map[string]interface{}, error) {
+	var result map[string]interface{}
+	err := s.withTx(lockShared, func(tx *sql.Tx) error {
+		var err error
+		result, err = s.setAttributes(tx, id, attrs)
+		return err
+	})
+	return result, err
+}
+
+func (s *Storage) setAttributes(db querier, id int, attrs map[string]interface{}) (map[string]interface{}, error) {
+	b, err := encodeAttributes(attrs)
+	if err != nil {
+		return nil, err
+	}
 	q := fmt.Sprintf(`
-		WITH RECURSIVE q AS (
-			SELECT h.* 
-			FROM %s h
-			WHERE id = $1
-			UNION ALL
-			SELECT hc.* 
-			FROM q 
-			JOIN %s hc
-			ON q.id = hc.parentID
+		UPDATE %s SET attributes = $2::jsonb
+		WHERE id = $1 AND deletedAt IS NULL
+		RETURNING attributes, nodeType`,
+		s.table(),
+	)
+	return s.updateAttributes(db, id, q, id, b)
+}
+
+// PatchAttributes implements `amznode.Storage.PatchAttributes`
+func (s *Storage) PatchAttributes(id int, patch map[string]interface{}) (map[string]interface{}, error) {
+	set := map[string]interface{}{}
+	remove := []string{}
+	for key, value := range patch {
+		if value == nil {
+			remove = append(remove, key)
+			continue
+		}
+		set[key] = value
+	}
+	b, err := encodeAttributes(set)
+	if err != nil {
+		return nil, err
+	}
+
+	q := fmt.Sprintf(`
+		UPDATE %s SET attributes = (attributes || $2::jsonb) - $3::text[]
+		WHERE id = $1 AND deletedAt IS NULL
+		RETURNING attributes, nodeType`,
+		s.table(),
+	)
+	var result map[string]interface{}
+	err = s.withTx(lockShared, func(tx *sql.Tx) error {
+		var err error
+		result, err = s.updateAttributes(tx, id, q, id, b, pq.Array(remove))
+		return err
+	})
+	return result, err
+}
+
+// updateAttributes runs an update of the attributes of the node with `id`,
+// which returns the updated attributes and the type of the node. The updated
+// attributes are checked against the type schema, so the update must run in a
+// transaction which is rolled back on errors.
+func (s *Storage) updateAttributes(db querier, id int, q string, args ...interface{}) (map[string]interface{}, error) {
+	var (
+		b        []byte
+		nodeType string
+	)
+	err := db.QueryRow(q, args...).Scan(&b, &nodeType)
+	if err == sql.ErrNoRows {
+		return nil, amznode.NewErrNotFound(id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	attrs, err := decodeAttributes(b)
+	if err != nil {
+		return nil, err
+	}
+	return attrs, s.typeSchema().ValidateAttributes(nodeType, attrs)
+}
+
+// Delete implements amznode.Storage.Delete
+func (s *Storage) Delete(id int) error {
+	return s.withTx(lockExclusive, func(tx *sql.Tx) error {
+		return s.delete(tx, id)
+	})
+}
+
+// delete moves the node with `id` along with its decendants to the trash.
+// The decendants which are already in the trash are left as they are, such
+// that they are not restored along with the node.
+func (s *Storage) delete(db querier, id int) error {
+	q := fmt.Sprintf(`
+		UPDATE %s SET deletedAt = now(), trashID = $1
+		WHERE id IN (SELECT id FROM (%s) d) AND deletedAt IS NULL`,
+		s.table(), s.decendantsQuery("-1"),
+	)
+	res, err := db.Exec(q, id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return amznode.NewErrNotFound(id)
+	}
+	return nil
+}
+
+// GetTrash implements amznode.Storage.GetTrash
+func (s *Storage) GetTrash() ([]*amznode.TrashedNode, error) {
+	return s.getTrash(false)
+}
+
+// GetTrashRec implements amznode.Storage.GetTrashRec
+func (s *Storage) GetTrashRec() ([]*amznode.TrashedNode, error) {
+	return s.getTrash(true)
+}
+
+// getTrash reads the deleted nodes, along with the decendants which were
+// deleted along with them if `rec` is set, in a single query.
+func (s *Storage) getTrash(rec bool) ([]*amznode.TrashedNode, error) {
+	where := "trashID = id"
+	if rec {
+		where = "trashID IS NOT NULL"
+	}
+	q := fmt.Sprintf(`
+		SELECT %s, deletedAt, trashID
+		FROM %s
+		WHERE %s
+		ORDER BY deletedAt DESC, id
+	`, nodeCols, s.table(), where)
+
+	rows, err := s.db.Query(q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	trash := []*amznode.TrashedNode{}
+	nodesByID := map[int]*amznode.Node{}
+	children := []*amznode.Node{}
+	for rows.Next() {
+		var (
+			deletedAt time.Time
+			trashID   int
+		)
+		n, err := scanNode(rows, &deletedAt, &trashID)
+		if err != nil {
+			return nil, err
+		}
+		if n.id != trashID {
+			node := n.ToDomain()
+			nodesByID[n.id] = node
+			children = append(children, node)
+			continue
+		}
+		trashed := &amznode.TrashedNode{Node: *n.ToDomain(), DeletedAt: deletedAt}
+		nodesByID[n.id] = &trashed.Node
+		trash = append(trash, trashed)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// the parents of the decendants were deleted along with them, so they
+	// are read by the same query
+	for _, child := range children {
+		parent := nodesByID[child.ParentID]
+		parent.Children = append(parent.Children, child)
+	}
+	for _, trashed := range trash {
+		sortChildren(&trashed.Node)
+	}
+	return trash, nil
+}
+
+// Restore implements amznode.Storage.Restore
+func (s *Storage) Restore(id int) (*amznode.Node, error) {
+	var node *amznode.Node
+	err := s.withTx(lockExclusive, func(tx *sql.Tx) error {
+		var (
+			parentID sql.NullInt64
+			name     string
+		)
+		q := fmt.Sprintf(
+			`SELECT parentID, name FROM %s WHERE id = $1 AND trashID = $1`,
+			s.table(),
+		)
+		err := tx.QueryRow(q, id).Scan(&parentID, &name)
+		if err == sql.ErrNoRows {
+			return amznode.NewErrNotFound(id)
+		}
+		if err != nil {
+			return err
+		}
+
+		var parent *amznode.Node
+		parentType := ""
+		if parentID.Valid {
+			parent, err = s.getRec(tx, int(parentID.Int64), 0)
+			if err != nil {
+				return err
+			}
+			parentType = parent.Type
+		}
+		if err := s.validateTrashTypes(tx, id, parentType); err != nil {
+			return err
+		}
+		if parent != nil {
+			err = s.checkPolicy(tx, parent, func() (int, int, error) {
+				return s.shape(tx, id, true)
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		q = fmt.Sprintf(
+			`UPDATE %s SET deletedAt = NULL, trashID = NULL WHERE trashID = $1`,
+			s.table(),
 		)
-		DELETE FROM %s WHERE id IN (SELECT id FROM q)`,
-		s.table(), s.table(), s.table(),
+		_, err = tx.Exec(q, id)
+		if err, ok := err.(*pq.Error); ok && err.Code == codeUniqueViolation {
+			return amznode.NewErrNameTaken(name, int(parentID.Int64))
+		}
+		if err != nil {
+			return err
+		}
+		// the siblings may have been reordered since the node was deleted
+		if err := s.place(tx, id, int(parentID.Int64), amznode.Placement{}); err != nil {
+			return err
+		}
+
+		node, err = s.getRec(tx, id, 1)
+		return err
+	})
+	return node, err
+}
+
+// validateTrashTypes checks the types and the attributes of the trashed node
+// with `id`, and of the decendants deleted along with it, against the type
+// schema, which may have changed since they were deleted.
+func (s *Storage) validateTrashTypes(db querier, id int, parentType string) error {
+	types := s.typeSchema()
+	if types == nil {
+		return nil
+	}
+	q := fmt.Sprintf(`SELECT %s FROM %s WHERE trashID = $1`, nodeCols, s.table())
+	rows, err := db.Query(q, id)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	_, nodesByID, err := loadRawNodes(rows)
+	if err != nil {
+		return err
+	}
+
+	var validate func(node *amznode.Node, parentType string) error
+	validate = func(node *amznode.Node, parentType string) error {
+		if err := types.ValidatePlacement(node.Type, node.ParentID, parentType); err != nil {
+			return err
+		}
+		if err := types.ValidateAttributes(node.Type, node.Attributes); err != nil {
+			return err
+		}
+		for _, child := range node.Children {
+			if err := validate(child, node.Type); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return validate(nodesByID[id], parentType)
+}
+
+// Purge implements amznode.Storage.Purge
+func (s *Storage) Purge(id int) error {
+	return s.withTx(lockExclusive, func(tx *sql.Tx) error {
+		var trashed bool
+		q := fmt.Sprintf(
+			`SELECT EXISTS (SELECT 1 FROM %s WHERE id = $1 AND trashID = $1)`,
+			s.table(),
+		)
+		if err := tx.QueryRow(q, id).Scan(&trashed); err != nil {
+			return err
+		}
+		if !trashed {
+			return amznode.NewErrNotFound(id)
+		}
+
+		// the decendants include the nodes which were deleted before the node
+		q = fmt.Sprintf(`
+			DELETE FROM %s WHERE id IN (SELECT id FROM (%s) d)`,
+			s.table(), s.decendantsQuery("-1"),
+		)
+		_, err := tx.Exec(q, id)
+		return err
+	})
+}
+
+// Copy implements amznode.Storage.Copy
+func (s *Storage) Copy(id, parentID int, name string) (*amznode.Node, error) {
+	var node *amznode.Node
+	err := s.withTx(lockShared, func(tx *sql.Tx) error {
+		src, err := s.getRec(tx, id, -1)
+		if err != nil {
+			return err
+		}
+		if name != "" {
+			src.Name = name
+		}
+		// the copy is placed like a created node under its new parent
+		src.Position = 0
+
+		copyID, err := s.createTree(tx, src, parentID, false)
+		if err != nil {
+			return err
+		}
+		node, err = s.getRec(tx, copyID, 1)
+		return err
+	})
+	return node, err
+}
+
+// Import implements amznode.Storage.Import
+func (s *Storage) Import(parentID int, trees []*amznode.Node, opts amznode.ImportOptions) ([]*amznode.Node, error) {
+	// nodes with preserved ids could collide with the ids handed out by
+	// concurrent creates, so those imports have to run alone.
+	mode := lockShared
+	if opts.PreserveIDs {
+		mode = lockExclusive
+	}
+
+	var nodes []*amznode.Node
+	err := s.withTx(mode, func(tx *sql.Tx) error {
+		var err error
+		if opts.DeletedAt.IsZero() {
+			nodes, err = s.importTrees(tx, parentID, trees, opts.PreserveIDs)
+		} else {
+			nodes, err = s.importTrash(tx, parentID, trees, opts)
+		}
+		if err != nil {
+			return err
+		}
+		if opts.PreserveIDs {
+			return s.advanceSequence(tx)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return nodes, nil
+}
+
+func (s *Storage) importTrees(db querier, parentID int, trees []*amznode.Node, preserveIDs bool) ([]*amznode.Node, error) {
+	var parent sql.NullInt64
+	if parentID != 0 {
+		parent = sql.NullInt64{Int64: int64(parentID), Valid: true}
+	}
+	if err := s.lockSiblings(db, parentID); err != nil {
+		return nil, err
+	}
+	// the trees keep their positions relative to each other, after the
+	// siblings which are already there
+	var offset int
+	q := fmt.Sprintf(`
+		SELECT COALESCE(MAX(position), 0) FROM %s
+		WHERE parentID IS NOT DISTINCT FROM $1::int AND deletedAt IS NULL`,
+		s.table(),
 	)
-	_, err := s.db.Exec(q, id)
+	if err := db.QueryRow(q, parent).Scan(&offset); err != nil {
+		return nil, err
+	}
+
+	nodes := []*amznode.Node{}
+	for _, tree := range trees {
+		id, err := s.createTree(db, tree, parentID, preserveIDs)
+		if err != nil {
+			return nil, err
+		}
+		if tree.Position != 0 {
+			q := fmt.Sprintf("UPDATE %s SET position = $2 WHERE id = $1", s.table())
+			if _, err := db.Exec(q, id, offset+tree.Position); err != nil {
+				return nil, err
+			}
+		}
+		node, err := s.getRec(db, id, -1)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+// importTrash creates the trees in the trash as deleted at `opts.DeletedAt`
+func (s *Storage) importTrash(db querier, parentID int, trees []*amznode.Node, opts amznode.ImportOptions) ([]*amznode.Node, error) {
+	var parent sql.NullInt64
+	if parentID != 0 {
+		// the parent may be in the trash too
+		var exists bool
+		q := fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM %s WHERE id = $1)`, s.table())
+		if err := db.QueryRow(q, parentID).Scan(&exists); err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, amznode.NewErrNotFound(parentID)
+		}
+		parent = sql.NullInt64{Int64: int64(parentID), Valid: true}
+	}
+	if err := checkUniqueNames(parentID, trees); err != nil {
+		return nil, err
+	}
+
+	nodes := []*amznode.Node{}
+	for _, tree := range trees {
+		id, err := s.trashTree(db, tree, parent, opts, 0)
+		if err != nil {
+			return nil, err
+		}
+		q := fmt.Sprintf(`SELECT %s FROM %s WHERE trashID = $1`, nodeCols, s.table())
+		rows, err := db.Query(q, id)
+		if err != nil {
+			return nil, err
+		}
+		_, nodesByID, err := loadRawNodes(rows)
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, nodesByID[id])
+	}
+	return nodes, nil
+}
+
+// trashTree creates the node along with its children in the trash, where
+// they are deleted along with the node with `trashID`, or with the node itself
+// if `trashID` is 0, and returns the id of the node.
+func (s *Storage) trashTree(db querier, n *amznode.Node, parentID sql.NullInt64, opts amznode.ImportOptions, trashID int) (int, error) {
+	id := 0
+	if opts.PreserveIDs {
+		id = n.ID
+	}
+	attrs, err := encodeAttributes(n.Attributes)
+	if err != nil {
+		return 0, err
+	}
+	q := fmt.Sprintf(`
+		INSERT INTO %s (
+			id, parentID, name, nodeType, rootID, height, position, attributes,
+			deletedAt, trashID
+		)
+		SELECT
+			n.id, $1::int, $2::text, $5::text, COALESCE(hp.rootID, n.id),
+			COALESCE(hp.height + 1, 0), $6::int, $7::jsonb, $8,
+			COALESCE(NULLIF($9::int, 0), n.id)
+		FROM (
+			SELECT CASE
+				WHEN $4::int > 0 THEN $4::int
+				ELSE nextval(pg_get_serial_sequence($3, 'id'))::int
+			END AS id
+		) n
+		LEFT JOIN %s hp
+		ON hp.id = $1::int
+		RETURNING id`,
+		s.table(), s.table(),
+	)
+	err = db.QueryRow(
+		q, parentID, n.Name, s.table(), id, n.Type, n.Position, attrs, opts.DeletedAt, trashID,
+	).Scan(&id)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == codeUniqueViolation {
+		return 0, amznode.NewErrIDTaken(id)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if err := s.afterInsert(db, id, parentID); err != nil {
+		return 0, err
+	}
+
+	if trashID == 0 {
+		trashID = id
+	}
+	if err := checkUniqueNames(id, n.Children); err != nil {
+		return 0, err
+	}
+	parent := sql.NullInt64{Int64: int64(id), Valid: true}
+	for _, child := range n.Children {
+		if _, err := s.trashTree(db, child, parent, opts, trashID); err != nil {
+			return 0, err
+		}
+	}
+	return id, nil
+}
+
+// checkUniqueNames returns an `ErrNameTaken` if two of the trees have the
+// same name. It is used for nodes created in the trash, where the names are
+// not covered by the unique indexes.
+func checkUniqueNames(parentID int, trees []*amznode.Node) error {
+	names := map[string]bool{}
+	for _, tree := range trees {
+		if names[tree.Name] {
+			return amznode.NewErrNameTaken(tree.Name, parentID)
+		}
+		names[tree.Name] = true
+	}
+	return nil
+}
+
+// advanceSequence moves the id sequence past the largest id in use, such that
+// nodes created after an import with preserved ids gets new ids.
+func (s *Storage) advanceSequence(db querier) error {
+	q := fmt.Sprintf(`
+		SELECT setval(
+			pg_get_serial_sequence($1, 'id'),
+			GREATEST(
+				(SELECT MAX(id) FROM %s),
+				nextval(pg_get_serial_sequence($1, 'id'))
+			)
+		)`,
+		s.table(),
+	)
+	_, err := db.Exec(q, s.table())
 	return err
 }
+
+// CreatePath implements amznode.Storage.CreatePath
+func (s *Storage) CreatePath(path string) (*amznode.Node, error) {
+	names := amznode.SplitPath(path)
+	if len(names) == 0 {
+		return nil, amznode.NewErrPathNotFound(path)
+	}
+
+	var node *amznode.Node
+	err := s.withTx(lockShared, func(tx *sql.Tx) error {
+		id, resolved, err := s.resolvePath(tx, names)
+		if err != nil {
+			return err
+		}
+		if resolved < len(names) {
+			if err := s.checkPathPlacement(tx, id, len(names)-resolved); err != nil {
+				return err
+			}
+		}
+		for _, name := range names[resolved:] {
+			id, err = s.createOrGet(tx, name, id)
+			if err != nil {
+				return err
+			}
+		}
+		node, err = s.getRec(tx, id, 1)
+		return err
+	})
+	return node, err
+}
+
+// checkPathPlacement checks that a chain of `created` untyped nodes can be
+// created under the node with `parentID`, or as a new tree if `parentID` is
+// 0. Only the first created node has to be checked against the type schema,
+// as untyped nodes allow children of any type.
+func (s *Storage) checkPathPlacement(db querier, parentID, created int) error {
+	if parentID == 0 {
+		return s.typeSchema().ValidatePlacement("", 0, "")
+	}
+	parent, err := s.getRec(db, parentID, 0)
+	if err != nil {
+		return err
+	}
+	if err := s.typeSchema().ValidatePlacement("", parentID, parent.Type); err != nil {
+		return err
+	}
+	return s.checkPolicy(db, parent, func() (int, int, error) {
+		fanOut := 0
+		if created > 1 {
+			fanOut = 1
+		}
+		return created - 1, fanOut, nil
+	})
+}
+
+// createOrGet creates a node like create, but returns the id of the existing
+// node if the name is already taken. This lets concurrent calls to CreatePath
+// with overlapping paths succeed.
+func (s *Storage) createOrGet(db querier, name string, parentID int) (int, error) {
+	var parent sql.NullInt64
+	if parentID != 0 {
+		parent = sql.NullInt64{Int64: int64(parentID), Valid: true}
+	}
+
+	id, err := s.insert(db, 0, parent, name, "", "ON CONFLICT DO NOTHING")
+	if err != sql.ErrNoRows {
+		return id, err
+	}
+
+	q := fmt.Sprintf(`
+		SELECT id FROM %s
+		WHERE parentID IS NOT DISTINCT FROM $1::int AND name = $2 AND deletedAt IS NULL`,
+		s.table(),
+	)
+	err = db.QueryRow(q, parent, name).Scan(&id)
+	return id, err
+}
+
+// GetByPath implements amznode.Storage.GetByPath
+func (s *Storage) GetByPath(path string) (*amznode.Node, error) {
+	names := amznode.SplitPath(path)
+	id, resolved, err := s.resolvePath(s.db, names)
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 || resolved < len(names) {
+		return nil, amznode.NewErrPathNotFound(path)
+	}
+
+	return s.Get(id)
+}
+
+// DeleteByPath implements amznode.Storage.DeleteByPath
+func (s *Storage) DeleteByPath(path string) error {
+	names := amznode.SplitPath(path)
+	return s.withTx(lockExclusive, func(tx *sql.Tx) error {
+		id, resolved, err := s.resolvePath(tx, names)
+		if err != nil {
+			return err
+		}
+		if len(names) == 0 || resolved < len(names) {
+			return amznode.NewErrPathNotFound(path)
+		}
+
+		return s.delete(tx, id)
+	})
+}
+
+// resolvePath follows the names from the roots and returns the id of the
+// deepest existing node along with the number of names which were resolved.
+func (s *Storage) resolvePath(db querier, names []string) (int, int, error) {
+	if len(names) == 0 {
+		return 0, 0, nil
+	}
+
+	q := fmt.Sprintf(`
+		WITH RECURSIVE q AS (
+			SELECT id, 1 AS level
+			FROM %s
+			WHERE parentID IS NULL AND name = ($1::text[])[1] AND deletedAt IS NULL
+			UNION ALL
+			SELECT hc.id, level + 1
+			FROM q
+			JOIN %s hc
+			ON hc.parentID = q.id AND hc.name = ($1::text[])[level + 1]
+			AND hc.deletedAt IS NULL
+		)
+		SELECT id, level
+		FROM q
+		ORDER BY level DESC
+		LIMIT 1
+	`, s.table(), s.table())
+
+	var id, resolved int
+	err := db.QueryRow(q, pq.Array(names)).Scan(&id, &resolved)
+	if err == sql.ErrNoRows {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	return id, resolved, nil
+}
@@ -0,0 +1,117 @@
+package pg
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/blacksails/amznode"
+	"github.com/blacksails/amznode/storagetest"
+)
+
+// TestStorage runs the storage conformance tests against PostgreSQL. The
+// database is configured with the same env variables as `NewFromEnv`. Note
+// that the schema is dropped before each test.
+func TestStorage(t *testing.T) {
+	testStorage(t, AdjacencyList)
+}
+
+func TestStorageClosureTable(t *testing.T) {
+	testStorage(t, ClosureTable)
+}
+
+func testStorage(t *testing.T, strategy Strategy) {
+	storage := connect(t)
+	storage.strategy = strategy
+
+	storagetest.Run(t, func(t *testing.T) amznode.Storage {
+		reset(t, storage)
+		if err := storage.init(); err != nil {
+			t.Fatal(err)
+		}
+		return storage
+	})
+}
+
+func TestSyncClosureTable(t *testing.T) {
+	s := connect(t)
+	reset(t, s)
+	s.strategy = AdjacencyList
+	require.NoError(t, s.init())
+
+	root, err := s.Create("root", 0, amznode.CreateOptions{})
+	require.NoError(t, err)
+	_, err = s.Create("c1", root.ID, amznode.CreateOptions{})
+	require.NoError(t, err)
+
+	closureRows := func() int {
+		var count int
+		q := fmt.Sprintf(`SELECT COUNT(*) FROM %s`, s.closureTable())
+		require.NoError(t, s.db.QueryRow(q).Scan(&count))
+		return count
+	}
+	synced := func() bool {
+		var synced bool
+		q := fmt.Sprintf(`SELECT synced FROM %s`, s.closureStateTable())
+		require.NoError(t, s.db.QueryRow(q).Scan(&synced))
+		return synced
+	}
+
+	s.strategy = ClosureTable
+	require.NoError(t, s.init())
+	assert.True(t, synced())
+	assert.Equal(t, 3, closureRows(), "the stale closure table must be rebuilt")
+
+	q := fmt.Sprintf(`DELETE FROM %s`, s.closureTable())
+	_, err = s.db.Exec(q)
+	require.NoError(t, err)
+	require.NoError(t, s.init())
+	assert.Equal(t, 0, closureRows(), "a closure table in sync must not be rebuilt")
+
+	s.strategy = AdjacencyList
+	_, err = s.Create("c2", root.ID, amznode.CreateOptions{})
+	require.NoError(t, err)
+	assert.False(t, synced(), "writes with the adjacency list must mark it stale")
+}
+
+// connect connects to the database configured by the environment, or skips
+// the test if no database is reachable.
+func connect(t *testing.T) *Storage {
+	storage, err := NewFromEnv()
+	if err != nil {
+		t.Skipf("could not connect to PostgreSQL: %s", err)
+	}
+	return storage
+}
+
+// reset drops the schema of the storage
+func reset(t *testing.T, s *Storage) {
+	q := fmt.Sprintf(`DROP SCHEMA IF EXISTS %s CASCADE`, pq.QuoteIdentifier(s.schema))
+	if _, err := s.db.Exec(q); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLikePattern(t *testing.T) {
+	cases := map[string]struct {
+		query   string
+		match   amznode.Match
+		pattern string
+	}{
+		"exact":     {query: "c1", match: amznode.MatchExact, pattern: "c1"},
+		"prefix":    {query: "c1", match: amznode.MatchPrefix, pattern: "c1%"},
+		"substring": {query: "c1", match: amznode.MatchSubstring, pattern: "%c1%"},
+		"wildcards": {query: `a_b%c\d`, match: amznode.MatchExact, pattern: `a\_b\%c\\d`},
+	}
+
+	for n, c := range cases {
+		t.Run(n, func(t *testing.T) {
+			if pattern := likePattern(c.query, c.match); pattern != c.pattern {
+				t.Errorf("Expected %s, got %s", c.pattern, pattern)
+			}
+		})
+	}
+}
@@ -0,0 +1,236 @@
+package pg
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// ClosureTableName is the name of the closure table used by the ClosureTable
+// strategy
+const ClosureTableName = "nodes_closure"
+
+// ClosureStateTableName is the name of the table which records whether the
+// closure table is in sync with the nodes
+const ClosureStateTableName = "nodes_closure_state"
+
+// Strategy determines how the tree structure is queried
+type Strategy int
+
+const (
+	// AdjacencyList finds decendants and ancestors with recursive queries on
+	// the parentID column of the nodes. This needs no extra bookkeeping, but
+	// the recursive queries gets slow on large trees.
+	AdjacencyList Strategy = iota
+	// ClosureTable maintains a closure table with a row for every ancestor
+	// and decendant pair in the tree along with their distance. This turns
+	// decendant and ancestor lookups into indexed joins, at the cost of extra
+	// writes when nodes are created and moved.
+	ClosureTable
+)
+
+// ParseStrategy parses the name of a strategy, which is either "adjacency" or
+// "closure"
+func ParseStrategy(name string) (Strategy, error) {
+	switch name {
+	case "adjacency":
+		return AdjacencyList, nil
+	case "closure":
+		return ClosureTable, nil
+	default:
+		return AdjacencyList, fmt.Errorf("unknown strategy '%s'", name)
+	}
+}
+
+func (s Storage) closureTable() string {
+	return fmt.Sprintf(
+		"%s.%s", pq.QuoteIdentifier(s.schema), pq.QuoteIdentifier(ClosureTableName))
+}
+
+func (s Storage) closureStateTable() string {
+	return fmt.Sprintf(
+		"%s.%s", pq.QuoteIdentifier(s.schema), pq.QuoteIdentifier(ClosureStateTableName))
+}
+
+// decendantsQuery returns a query which selects the id of the node with id $1
+// and the ids of all its decendants along with their depth relative to the
+// node. Decendants deeper than `maxDepth` are left out, unless `maxDepth` is
+// negative. `maxDepth` is an SQL expression.
+func (s *Storage) decendantsQuery(maxDepth string) string {
+	if s.strategy == ClosureTable {
+		return fmt.Sprintf(`
+			SELECT descendant AS id, depth
+			FROM %s
+			WHERE ancestor = $1 AND (%s < 0 OR depth <= %s)
+		`, s.closureTable(), maxDepth, maxDepth)
+	}
+	return fmt.Sprintf(`
+		WITH RECURSIVE q AS (
+			SELECT h.id, 0 AS depth
+			FROM %s h
+			WHERE id = $1
+			UNION ALL
+			SELECT hc.id, depth + 1
+			FROM q
+			JOIN %s hc
+			ON q.id = hc.parentID
+			WHERE %s < 0 OR depth < %s
+		)
+		SELECT id, depth FROM q
+	`, s.table(), s.table(), maxDepth, maxDepth)
+}
+
+// subtreesQuery is like `decendantsQuery`, but selects the ids of the nodes
+// selected by the query `top` along with all their decendants. `top` must
+// select a column named id.
+func (s *Storage) subtreesQuery(top, maxDepth string) string {
+	if s.strategy == ClosureTable {
+		return fmt.Sprintf(`
+			SELECT descendant AS id, depth
+			FROM %s
+			WHERE ancestor IN (SELECT id FROM (%s) t) AND (%s < 0 OR depth <= %s)
+		`, s.closureTable(), top, maxDepth, maxDepth)
+	}
+	return fmt.Sprintf(`
+		WITH RECURSIVE q AS (
+			SELECT id, 0 AS depth
+			FROM (%s) t
+			UNION ALL
+			SELECT hc.id, depth + 1
+			FROM q
+			JOIN %s hc
+			ON q.id = hc.parentID
+			WHERE %s < 0 OR depth < %s
+		)
+		SELECT id, depth FROM q
+	`, top, s.table(), maxDepth, maxDepth)
+}
+
+// ancestorsQuery returns a query which selects the id of the node with id $1
+// and the ids of all its ancestors.
+func (s *Storage) ancestorsQuery() string {
+	if s.strategy == ClosureTable {
+		return fmt.Sprintf(`
+			SELECT ancestor AS id
+			FROM %s
+			WHERE descendant = $1
+		`, s.closureTable())
+	}
+	return fmt.Sprintf(`
+		WITH RECURSIVE q AS (
+			SELECT h.id, h.parentID
+			FROM %s h
+			WHERE id = $1
+			UNION ALL
+			SELECT hp.id, hp.parentID
+			FROM q
+			JOIN %s hp
+			ON hp.id = q.parentID
+		)
+		SELECT id FROM q
+	`, s.table(), s.table())
+}
+
+// afterInsert registers the node with `id` as a child of the node with
+// `parentID` in the closure table.
+func (s *Storage) afterInsert(db querier, id int, parentID sql.NullInt64) error {
+	if s.strategy != ClosureTable {
+		return s.markClosureStale(db)
+	}
+	q := fmt.Sprintf(`
+		INSERT INTO %s (ancestor, descendant, depth)
+		SELECT ancestor, $1::int, depth + 1
+		FROM %s
+		WHERE descendant = $2::int
+		UNION ALL
+		SELECT $1::int, $1::int, 0
+	`, s.closureTable(), s.closureTable())
+	_, err := db.Exec(q, id, parentID)
+	return err
+}
+
+// afterMove updates the closure table after the subtree with its root at the
+// node with `id` has been moved to the node with `newParentID`.
+func (s *Storage) afterMove(db querier, id, newParentID int) error {
+	if s.strategy != ClosureTable {
+		return s.markClosureStale(db)
+	}
+	qs := []string{
+		// detach the subtree from its old ancestors
+		fmt.Sprintf(`
+			DELETE FROM %s
+			WHERE descendant IN (
+				SELECT descendant FROM %s WHERE ancestor = $1
+			)
+			AND ancestor NOT IN (
+				SELECT descendant FROM %s WHERE ancestor = $1
+			)
+		`, s.closureTable(), s.closureTable(), s.closureTable()),
+		// attach it to its new ancestors
+		fmt.Sprintf(`
+			INSERT INTO %s (ancestor, descendant, depth)
+			SELECT super.ancestor, sub.descendant, super.depth + sub.depth + 1
+			FROM %s super
+			CROSS JOIN %s sub
+			WHERE super.descendant = $2 AND sub.ancestor = $1
+		`, s.closureTable(), s.closureTable(), s.closureTable()),
+	}
+	for _, q := range qs {
+		if _, err := db.Exec(q, id, newParentID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// markClosureStale records that the closure table is no longer in sync with
+// the nodes, as they have been changed without maintaining it. The row is
+// only updated the first time, so later writes don't contend on it.
+func (s *Storage) markClosureStale(db querier) error {
+	q := fmt.Sprintf(`UPDATE %s SET synced = false WHERE synced`, s.closureStateTable())
+	_, err := db.Exec(q)
+	return err
+}
+
+// syncClosureTable rebuilds the content of the closure table from the nodes,
+// unless it is already in sync. The closure table is not maintained when the
+// AdjacencyList strategy is in use, so it has to be rebuilt when the
+// ClosureTable strategy is taken into use after nodes have been changed with
+// the AdjacencyList strategy.
+func (s *Storage) syncClosureTable() error {
+	return s.withTx(lockExclusive, func(tx *sql.Tx) error {
+		var synced bool
+		q := fmt.Sprintf(`SELECT synced FROM %s`, s.closureStateTable())
+		if err := tx.QueryRow(q).Scan(&synced); err != nil {
+			return err
+		}
+		if synced {
+			return nil
+		}
+
+		qs := []string{
+			fmt.Sprintf(`DELETE FROM %s`, s.closureTable()),
+			fmt.Sprintf(`
+				INSERT INTO %s (ancestor, descendant, depth)
+				WITH RECURSIVE q AS (
+					SELECT h.id AS ancestor, h.id AS descendant, 0 AS depth
+					FROM %s h
+					UNION ALL
+					SELECT q.ancestor, hc.id, depth + 1
+					FROM q
+					JOIN %s hc
+					ON q.descendant = hc.parentID
+				)
+				SELECT ancestor, descendant, depth FROM q
+			`, s.closureTable(), s.table(), s.table()),
+			fmt.Sprintf(`UPDATE %s SET synced = true`, s.closureStateTable()),
+		}
+		for _, q := range qs {
+			if _, err := tx.Exec(q); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
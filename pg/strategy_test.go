@@ -0,0 +1,33 @@
+package pg
+
+import "testing"
+
+func TestParseStrategy(t *testing.T) {
+	cases := map[string]struct {
+		name     string
+		strategy Strategy
+		err      bool
+	}{
+		"adjacency": {name: "adjacency", strategy: AdjacencyList},
+		"closure":   {name: "closure", strategy: ClosureTable},
+		"unknown":   {name: "nested-sets", err: true},
+	}
+
+	for n, c := range cases {
+		t.Run(n, func(t *testing.T) {
+			strategy, err := ParseStrategy(c.name)
+			if c.err {
+				if err == nil {
+					t.Errorf("expected an error for '%s'", c.name)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strategy != c.strategy {
+				t.Errorf("Expected %v, got %v", c.strategy, strategy)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package pg
+
+import (
+	"database/sql"
+)
+
+// querier is implemented by both `*sql.DB` and `*sql.Tx`, which lets the
+// queries run both in and outside of transactions.
+type querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// lockMode determines how the tree lock is taken by a transaction
+type lockMode int
+
+const (
+	// lockShared is used by operations which can't break the tree
+	// structure, like creating and renaming nodes. Conflicting names are
+	// caught by the unique constraints.
+	lockShared lockMode = iota
+	// lockExclusive is used by operations which moves or removes subtrees.
+	// Holding the exclusive lock guarantees that the tree doesn't change
+	// between checking for cycles and updating the nodes.
+	lockExclusive
+)
+
+// withTx runs f within a transaction holding the tree lock in the given
+// mode. The tree lock is a transaction level advisory lock, which is released
+// when the transaction ends. The transaction is committed if f succeeds and
+// rolled back otherwise.
+func (s *Storage) withTx(mode lockMode, f func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	lockFunc := "pg_advisory_xact_lock_shared"
+	if mode == lockExclusive {
+		lockFunc = "pg_advisory_xact_lock"
+	}
+	_, err = tx.Exec("SELECT "+lockFunc+"(hashtext($1))", s.table())
+	if err == nil {
+		err = f(tx)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// lockSiblings takes a transaction level lock on the children of the node
+// with `parentID`, or on the roots if it is 0, which is held until the
+// transaction ends. Nodes placed after their siblings under the shared tree
+// lock must hold it, or else concurrent transactions could read the same last
+// position. The siblings lock is keyed by the table and the parent, so it
+// never conflicts with the tree lock.
+func (s *Storage) lockSiblings(db querier, parentID int) error {
+	_, err := db.Exec("SELECT pg_advisory_xact_lock(hashtext($1), $2)", s.table(), parentID)
+	return err
+}
@@ -0,0 +1,83 @@
+package amznode
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+)
+
+// Placement places a node right before or right after one of its siblings,
+// given by id. Only one of Before and After can be set. The zero Placement
+// places the node after its siblings if they have been ordered explicitly, and
+// by name otherwise.
+type Placement struct {
+	Before int
+	After  int
+}
+
+var errInvalidPlacement = errors.New("only one of before and after can be given")
+var errMissingPlacement = errors.New("either before or after must be given")
+
+// IsZero returns true if the placement does not refer to a sibling
+func (p Placement) IsZero() bool {
+	return p.Before == 0 && p.After == 0
+}
+
+// Sibling returns the id of the sibling which the node is placed next to
+func (p Placement) Sibling() int {
+	if p.Before != 0 {
+		return p.Before
+	}
+	return p.After
+}
+
+// Order returns the ids of the ordered `siblings` with `id` placed next to the
+// sibling of the placement. `siblings` must not contain `id`. The returned
+// bool is false if the sibling of the placement is not among `siblings`.
+//
+// Storages give the siblings the positions 1 to n in the returned order.
+func (p Placement) Order(siblings []int, id int) ([]int, bool) {
+	order := make([]int, 0, len(siblings)+1)
+	found := false
+	for _, sibling := range siblings {
+		if sibling == p.Before {
+			order = append(order, id)
+			found = true
+		}
+		order = append(order, sibling)
+		if sibling == p.After {
+			order = append(order, id)
+			found = true
+		}
+	}
+	return order, found
+}
+
+// SortSiblings sorts sibling nodes by their position and then by their name.
+// Nodes which haven't been ordered explicitly have position 0, so they are
+// sorted by name only.
+func SortSiblings(nodes []*Node) {
+	sort.SliceStable(nodes, func(i, j int) bool {
+		if nodes[i].Position != nodes[j].Position {
+			return nodes[i].Position < nodes[j].Position
+		}
+		return nodes[i].Name < nodes[j].Name
+	})
+}
+
+// urlParamPlacement reads a placement from the `before` and `after` query
+// parameters
+func urlParamPlacement(r *http.Request) (Placement, error) {
+	var p Placement
+	var err error
+	if p.Before, err = urlParamID(r, "before"); err != nil {
+		return p, err
+	}
+	if p.After, err = urlParamID(r, "after"); err != nil {
+		return p, err
+	}
+	if p.Before != 0 && p.After != 0 {
+		return p, errInvalidPlacement
+	}
+	return p, nil
+}
@@ -0,0 +1,58 @@
+package amznode
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// Policy limits the shape of the tree below a root node. A limit of 0 means
+// that there is no limit.
+type Policy struct {
+	// MaxHeight is the largest height of the nodes of the tree, where the
+	// root has height 0
+	MaxHeight int `json:"max_height" yaml:"max_height" xml:"max_height,attr"`
+	// MaxChildren is the largest number of children of any node of the tree
+	MaxChildren int `json:"max_children" yaml:"max_children" xml:"max_children,attr"`
+}
+
+var errInvalidPolicy = errors.New("max_height and max_children must be greater than or equal 0")
+var errTrailingData = errors.New("the request body must hold a single policy")
+
+// CheckPlacement checks that a subtree can be placed under a parent in the
+// tree with root `rootID` without breaking the policy. `parentHeight` is the
+// height of the parent and `siblings` the number of children it already has.
+// `subtreeHeight` is the number of levels below the root of the subtree, and
+// `fanOut` the largest number of children of any node in the subtree. A
+// single new node has a `subtreeHeight` and `fanOut` of 0.
+func (p Policy) CheckPlacement(rootID, parentHeight, siblings, subtreeHeight, fanOut int) error {
+	if p.MaxHeight > 0 && parentHeight+1+subtreeHeight > p.MaxHeight {
+		return NewErrPolicyViolation(rootID, p, "height")
+	}
+	if p.MaxChildren > 0 && (siblings >= p.MaxChildren || fanOut > p.MaxChildren) {
+		return NewErrPolicyViolation(rootID, p, "children")
+	}
+	return nil
+}
+
+// decodePolicy decodes a policy from the json request body. Unknown fields
+// and data after the policy are rejected, such that a misspelled limit isn't
+// silently left unset.
+func decodePolicy(r io.Reader) (Policy, error) {
+	var policy Policy
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&policy); err != nil {
+		if err == io.EOF {
+			return policy, errEmptyBody
+		}
+		return policy, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return policy, errTrailingData
+	}
+	if policy.MaxHeight < 0 || policy.MaxChildren < 0 {
+		return policy, errInvalidPolicy
+	}
+	return policy, nil
+}
@@ -0,0 +1,64 @@
+package amznode
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// renderDOT renders the trees as a Graphviz directed graph
+func renderDOT(w io.Writer, trees []*Node) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "digraph amznode {")
+	walkEdges(trees, func(node *Node) {
+		fmt.Fprintf(bw, "\tn%d [label=%s];\n", node.ID, strconv.Quote(nodeLabel(node)))
+	}, func(parent, child *Node) {
+		fmt.Fprintf(bw, "\tn%d -> n%d;\n", parent.ID, child.ID)
+	})
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
+
+// renderMermaid renders the trees as a top down Mermaid flowchart
+func renderMermaid(w io.Writer, trees []*Node) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "graph TD")
+	walkEdges(trees, func(node *Node) {
+		fmt.Fprintf(bw, "\tn%d[\"%s\"]\n", node.ID, nodeLabel(node))
+	}, func(parent, child *Node) {
+		fmt.Fprintf(bw, "\tn%d --> n%d\n", parent.ID, child.ID)
+	})
+	return bw.Flush()
+}
+
+// renderText renders the trees as lists of names, where children are listed
+// beneath their parent indented by two spaces.
+func renderText(w io.Writer, trees []*Node) error {
+	bw := bufio.NewWriter(w)
+	writeTextLines(bw, trees, "")
+	return bw.Flush()
+}
+
+func writeTextLines(w io.Writer, nodes []*Node, indent string) {
+	for _, node := range nodes {
+		fmt.Fprintf(w, "%s- %s\n", indent, node.Name)
+		writeTextLines(w, node.Children, indent+"  ")
+	}
+}
+
+func nodeLabel(node *Node) string {
+	return fmt.Sprintf("%s (id %d)", node.Name, node.ID)
+}
+
+// walkEdges calls visit for every node of the trees followed by edge for
+// every child of the node, before walking the children.
+func walkEdges(trees []*Node, visit func(node *Node), edge func(parent, child *Node)) {
+	for _, node := range trees {
+		visit(node)
+		for _, child := range node.Children {
+			edge(node, child)
+		}
+		walkEdges(node.Children, visit, edge)
+	}
+}
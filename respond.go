@@ -2,19 +2,33 @@ package amznode
 
 import (
 	"bytes"
-	"encoding/json"
 	"log"
+	"mime"
 	"net/http"
 )
 
+// hasContentType returns true if the body of the request has the given media
+// type
+func hasContentType(r *http.Request, mediaType string) bool {
+	t, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && t == mediaType
+}
+
+// respond encodes v in the format negotiated for the request. The response is
+// buffered, so errors from encoding can still be responded.
 func respond(w http.ResponseWriter, r *http.Request, v interface{}, code int) {
+	enc := requestEncoder(r)
 	var b bytes.Buffer
-	err := json.NewEncoder(&b).Encode(v)
+	err := enc.Encode(&b, v)
+	if err == ErrNotEncodable {
+		respondErr(w, r, err, http.StatusNotAcceptable)
+		return
+	}
 	if err != nil {
 		respondErr(w, r, err, http.StatusInternalServerError)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Content-Type", enc.contentType())
 	w.WriteHeader(code)
 	_, err = b.WriteTo(w)
 	if err != nil {
@@ -22,16 +36,97 @@ func respond(w http.ResponseWriter, r *http.Request, v interface{}, code int) {
 	}
 }
 
+// checkEncodable responds `406 Not Acceptable` and returns false if the
+// format negotiated for the request can only encode nodes. Handlers which
+// change the storage and respond other values must check it before changing
+// anything, or else the client is told that a change failed which was
+// actually made.
+func checkEncodable(w http.ResponseWriter, r *http.Request) bool {
+	if requestEncoder(r).TreesOnly {
+		respondErr(w, r, ErrNotEncodable, http.StatusNotAcceptable)
+		return false
+	}
+	return true
+}
+
+// respondStream encodes v directly to the response instead of buffering it
+// first, which keeps large responses from being held in memory twice. As the
+// status code has been sent once encoding starts, errors can only be logged.
+func respondStream(w http.ResponseWriter, r *http.Request, v interface{}, code int) {
+	enc := requestEncoder(r)
+	w.Header().Set("Content-Type", enc.contentType())
+	w.WriteHeader(code)
+	err := enc.Encode(w, v)
+	if err != nil {
+		log.Printf("respond: %s", err)
+	}
+}
+
+// respondWalk streams the nodes visited by walk in the negotiated format, which
+// must be able to encode single nodes. The response is started when the first
+// node is visited, such that errors occurring before that, like a node which
+// could not be found, are still responded as errors.
+func respondWalk(w http.ResponseWriter, r *http.Request, walk func(f func(*Node) error) error) {
+	enc := requestEncoder(r)
+	started := false
+	start := func() {
+		w.Header().Set("Content-Type", enc.contentType())
+		w.WriteHeader(http.StatusOK)
+		started = true
+	}
+
+	err := walk(func(node *Node) error {
+		if !started {
+			start()
+		}
+		return enc.EncodeNode(w, node)
+	})
+	if err != nil && !started {
+		handleStorageError(w, r, err)
+		return
+	}
+	if err != nil {
+		log.Printf("respond: %s", err)
+		return
+	}
+	if !started {
+		start()
+	}
+}
+
+// streams returns true if the nodes of the response to the request should be
+// streamed one at a time using `respondWalk`
+func streams(r *http.Request) bool {
+	return requestEncoder(r).EncodeNode != nil
+}
+
+// respondErr responds the error in the format negotiated for the request, or
+// as json if the error can't be encoded in that format.
 func respondErr(w http.ResponseWriter, r *http.Request, err error, code int) {
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	v := ErrorResponse{Error: err.Error()}
+	enc := requestEncoder(r)
+	var b bytes.Buffer
+	if enc.Encode(&b, v) != nil {
+		enc = jsonEncoder
+		b.Reset()
+		enc.Encode(&b, v)
+	}
+	w.Header().Set("Content-Type", enc.contentType())
 	w.WriteHeader(code)
-	err = json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+	_, err = b.WriteTo(w)
 	if err != nil {
 		log.Printf("respond: %s", err)
 	}
 }
 
-// ErrorResponse is used to serialize errors to json
+// ErrorResponse is used to serialize errors
 type ErrorResponse struct {
-	Error string `json:"error"`
+	Error string `json:"error" yaml:"error" xml:",chardata"`
+}
+
+// AncestorsResponse is used to serialize the ancestors of a node
+type AncestorsResponse struct {
+	// Path is the slash separated names of the ancestors
+	Path      string  `json:"path" yaml:"path" xml:"path,attr"`
+	Ancestors []*Node `json:"ancestors" yaml:"ancestors" xml:"node"`
 }
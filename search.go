@@ -0,0 +1,90 @@
+package amznode
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// Match determines how names are matched by `Storage.Search`
+type Match int
+
+const (
+	// MatchSubstring matches names which contain the query
+	MatchSubstring Match = iota
+	// MatchPrefix matches names which start with the query
+	MatchPrefix
+	// MatchExact matches names which are equal to the query
+	MatchExact
+)
+
+// ParseMatch parses the name of a match, which is either "substring",
+// "prefix" or "exact"
+func ParseMatch(name string) (Match, error) {
+	switch name {
+	case "substring":
+		return MatchSubstring, nil
+	case "prefix":
+		return MatchPrefix, nil
+	case "exact":
+		return MatchExact, nil
+	default:
+		return MatchSubstring, fmt.Errorf("unknown match '%s'", name)
+	}
+}
+
+// SearchOptions controls which nodes are found by `Storage.Search`
+type SearchOptions struct {
+	Match Match
+	// RootID limits the search to the tree with the root with RootID. A
+	// RootID of 0 searches all the trees.
+	RootID int
+	// Limit is the maximum number of results. A Limit of 0 returns all the
+	// results.
+	Limit int
+}
+
+// SearchResult is a node found by `Storage.Search` along with its path
+type SearchResult struct {
+	Node `yaml:",inline"`
+	// Path is the slash separated names from the root down to the node
+	Path string `json:"path" yaml:"path" xml:"path,attr"`
+}
+
+// defaultSearchLimit is the number of results when no limit is given
+const defaultSearchLimit = 100
+
+var errInvalidQuery = fmt.Errorf(
+	"q must be a part of a name matching the regex /%s/", validNameRegexpStr)
+
+// urlParamSearch reads a search from the `q`, `match`, `root` and `limit`
+// query parameters
+func urlParamSearch(r *http.Request) (string, SearchOptions, error) {
+	query := r.URL.Query()
+	opts := SearchOptions{Limit: defaultSearchLimit}
+
+	q := query.Get("q")
+	if !validName(q) {
+		return q, opts, errInvalidQuery
+	}
+	if matchStr := query.Get("match"); matchStr != "" {
+		match, err := ParseMatch(matchStr)
+		if err != nil {
+			return q, opts, err
+		}
+		opts.Match = match
+	}
+	rootID, err := urlParamID(r, "root")
+	if err != nil {
+		return q, opts, err
+	}
+	opts.RootID = rootID
+	if limitStr := query.Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			return q, opts, errInvalidLimit
+		}
+		opts.Limit = limit
+	}
+	return q, opts, nil
+}
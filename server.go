@@ -38,10 +38,35 @@ func (s *server) Handler() http.Handler {
 func (s *server) routes() {
 	r := s.r
 
+	r.Use(negotiateMiddleware)
+
+	r.Post("/import", s.importHandler())
 	r.Post("/{childName}", s.createHandler())
 	r.Post("/{parentID}/{childName}", s.createHandler())
+	r.Post("/{id}/copy", s.copyHandler())
 	r.Get("/", s.getHandler())
+	r.Get("/export", s.exportHandler())
+	r.Get("/search", s.searchHandler())
 	r.Get("/{id}", s.getHandler())
+	r.Get("/{id}/children", s.getChildrenHandler())
+	r.Get("/{id}/ancestors", s.getAncestorsHandler())
+	r.Get("/{id}/attributes", s.getAttributesHandler())
+	r.Put("/{id}/attributes", s.setAttributesHandler())
+	r.Patch("/{id}/attributes", s.patchAttributesHandler())
+	r.Delete("/{id}/attributes/{key}", s.deleteAttributeHandler())
+	r.Get("/{id}/policy", s.getPolicyHandler())
+	r.Put("/{id}/policy", s.setPolicyHandler())
+	r.Delete("/{id}/policy", s.deletePolicyHandler())
 	r.Put("/{id}", s.changeParentHandler())
+	r.Put("/{id}/position", s.setPositionHandler())
+	r.Patch("/{id}", s.renameHandler())
 	r.Delete("/{id}", s.deleteHandler())
+
+	r.Get("/trash", s.getTrashHandler())
+	r.Post("/trash/{id}/restore", s.restoreHandler())
+	r.Delete("/trash/{id}", s.purgeHandler())
+
+	r.Post("/path/*", s.createPathHandler())
+	r.Get("/path/*", s.getByPathHandler())
+	r.Delete("/path/*", s.deleteByPathHandler())
 }
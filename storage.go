@@ -1,42 +1,295 @@
 package amznode
 
+import "time"
+
 // Storage is our main storage interface
 type Storage interface {
 	// Create creates a new node with the given `name` and `parentID`. If
-	// `parentID` is set to `0`, then the node will be a root node.
+	// `parentID` is set to `0`, then the node will be a root node. The node
+	// is given the type and attributes of `opts`, and is placed among its
+	// siblings by `opts.Placement`.
 	//
 	// If the parent could not be found an `ErrNotFound` error will be
 	// returned. If already exists a node with the given `name` and `parentID`
-	// an `ErrNameTaken` error will be returned.
-	Create(name string, parentID int) (*Node, error)
+	// an `ErrNameTaken` error will be returned. This also applies to root
+	// nodes, which must have unique names. If the node breaks the rules of
+	// the type schema an `ErrSchemaViolation` will be returned. If the
+	// tree would break the policy of its root an `ErrPolicyViolation` will be
+	// returned. If the sibling of the placement is not a child of the parent
+	// an `ErrNotSibling` will be returned.
+	Create(name string, parentID int, opts CreateOptions) (*Node, error)
 
 	// Get gets the node with the given `id` along with its children.
 	//
 	// If the Node could not be found an `ErrNotFound` will be returned.
 	Get(id int) (*Node, error)
 
-	// GetRoots get all the tree roots
+	// GetRec gets the node with the given `id` along with its decendants down
+	// to `depth` levels below the node. A negative `depth` gets all the
+	// decendants. Hence `Get(id)` is equal to `GetRec(id, 1)`.
+	//
+	// If the Node could not be found an `ErrNotFound` will be returned.
+	GetRec(id, depth int) (*Node, error)
+
+	// GetAncestors gets the chain of nodes from the root of the tree down to
+	// and including the node with the given `id`. The nodes are returned
+	// without their children.
+	//
+	// If the Node could not be found an `ErrNotFound` will be returned.
+	GetAncestors(id int) ([]*Node, error)
+
+	// GetRoots get all the tree roots along with their children
 	GetRoots() ([]*Node, error)
 
+	// GetRootsRec gets all the tree roots along with their decendants down to
+	// `depth` levels below the roots. A negative `depth` gets all the
+	// decendants. Hence `GetRoots()` is equal to `GetRootsRec(1)`.
+	GetRootsRec(depth int) ([]*Node, error)
+
+	// GetChildren gets a page of the children of the node with `parentID`,
+	// or of the tree roots if `parentID` is `0`, ordered by position and
+	// name. Each child has its decendants down to `depth` levels below the
+	// child.
+	//
+	// If the parent could not be found an `ErrNotFound` error will be
+	// returned.
+	GetChildren(parentID, depth int, opts ListOptions) ([]*Node, error)
+
+	// Search finds the nodes with names matching `query` in all the trees,
+	// or in the tree with the root given by `opts.RootID`. The nodes are
+	// returned without children along with their paths, ordered by path.
+	// Names are matched case sensitively.
+	//
+	// If the root could not be found an `ErrNotFound` error will be returned.
+	// If it is not a root node an `ErrNotRoot` error will be returned.
+	Search(query string, opts SearchOptions) ([]*SearchResult, error)
+
+	// WalkRec calls `f` for the node with `id` and its decendants down to
+	// `depth` levels below the node, without holding the whole subtree in
+	// memory. The nodes are passed to `f` without their children, one level
+	// at a time ordered by parent id, position and name, such that parents
+	// are visited before their children. The walk stops at the first error
+	// returned by `f`, which is then returned.
+	//
+	// If the node could not be found an `ErrNotFound` error will be returned.
+	WalkRec(id, depth int, f func(*Node) error) error
+
+	// WalkRootsRec walks the tree roots and their decendants down to `depth`
+	// levels below the roots in the same way as `WalkRec`.
+	WalkRootsRec(depth int, f func(*Node) error) error
+
 	// ChangeParent changes the parent of the node with `id` to the node with
-	// the `newParentID`.
+	// the `newParentID`, and places it among its new siblings by `placement`.
 	//
 	// If either of the nodes does not exist an `ErrNotFound` error will be
 	// returned. If the node with id `newParentID` already has a node with the
 	// name of the node with id of `id`. Then an `ErrNameTaken` will be
+	// returned. If the type of the new parent does not allow the type of the
+	// node an `ErrSchemaViolation` will be returned. If the subtree would make
+	// the tree of the new parent break the policy of its root an
+	// `ErrPolicyViolation` will be returned. If the sibling of the placement
+	// is not a child of the new parent an `ErrNotSibling` will be returned.
+	ChangeParent(id, newParentID int, placement Placement) error
+
+	// SetPosition places the node with `id` before or after one of its
+	// siblings. The siblings are renumbered, such that their order is kept
+	// explicitly from then on.
+	//
+	// If the node could not be found an `ErrNotFound` error will be returned.
+	// If the sibling of the placement could not be found an `ErrNotFound`
+	// error will be returned, and if it is not a sibling of the node an
+	// `ErrNotSibling` error.
+	SetPosition(id int, placement Placement) error
+
+	// Rename changes the name of the node with `id` to `newName`.
+	//
+	// If the node does not exist an `ErrNotFound` error will be returned. If
+	// the node has a sibling named `newName` an `ErrNameTaken` will be
 	// returned.
-	ChangeParent(id, newParentID int) error
+	Rename(id int, newName string) error
 
-	// Delete deletes a node along with all its decendent children.
+	// SetAttributes replaces the attributes of the node with `id` and
+	// returns them. Empty attributes removes all the attributes of the node.
+	// Attribute values must be encodable as json, and are returned as they
+	// would be decoded from json, e.g. numbers are float64.
+	//
+	// If the node could not be found an `ErrNotFound` error will be returned.
+	// If the attributes break the rules of the type of the node an
+	// `ErrSchemaViolation` will be returned.
+	SetAttributes(id int, attrs map[string]interface{}) (map[string]interface{}, error)
+
+	// PatchAttributes merges `patch` into the attributes of the node with
+	// `id` and returns the resulting attributes. Attributes which are nil in
+	// the patch are removed from the node, the rest are set.
+	//
+	// If the node could not be found an `ErrNotFound` error will be returned.
+	// If the resulting attributes break the rules of the type of the node an
+	// `ErrSchemaViolation` will be returned.
+	PatchAttributes(id int, patch map[string]interface{}) (map[string]interface{}, error)
+
+	// Delete moves a node along with all its decendent children to the
+	// trash. Deleted nodes are left out everywhere else, and their names can
+	// be taken by new nodes.
 	//
 	// If a node with id of `id` could not be found then an `ErrNotFound` will
 	// be returned.
 	Delete(id int) error
 
-	//CreatePath(path string) (*Node, error)
-	//Get(path string) (*Node, error)
-	//DeleteByPath(path string) error
+	// GetTrash gets the deleted nodes without their children, most recently
+	// deleted first. The decendants which were deleted along with a node are
+	// left out.
+	GetTrash() ([]*TrashedNode, error)
+
+	// GetTrashRec gets the deleted nodes like `GetTrash`, but along with the
+	// decendants which were deleted along with them.
+	GetTrashRec() ([]*TrashedNode, error)
+
+	// Restore moves the deleted node with `id` back to its parent along with
+	// the decendants which were deleted along with it. The node is placed
+	// after its siblings like a created node, as they may have been
+	// reordered since it was deleted. The node is returned along with its
+	// children.
+	//
+	// If the node is not in the trash an `ErrNotFound` error will be
+	// returned, and if its parent has been deleted too an `ErrNotFound` error
+	// for the parent. If the parent has gotten a child with the name of the
+	// node an `ErrNameTaken` will be returned. If the node no longer follows
+	// the type schema or the policy of the tree an `ErrSchemaViolation` or
+	// `ErrPolicyViolation` will be returned.
+	Restore(id int) (*Node, error)
+
+	// Purge permanently deletes the deleted node with `id` along with all its
+	// decendants, including the ones which were deleted before it.
+	//
+	// If the node is not in the trash an `ErrNotFound` error will be returned.
+	Purge(id int) error
+
+	// Copy creates a deep copy of the node with `id` and all its decendants
+	// under the node with `parentID`. If `parentID` is set to `0`, then the
+	// copy will be a root node. The copy is named `name`, or keeps the name
+	// of the original if `name` is empty. The copy is placed like a created
+	// node, while its decendants keep their positions. The copy is returned
+	// along with its children.
+	//
+	// If either of the nodes does not exist an `ErrNotFound` error will be
+	// returned. If the parent already has a child with the name of the copy
+	// an `ErrNameTaken` will be returned. If the type of the parent does not
+	// allow the type of the copy an `ErrSchemaViolation` will be returned, and
+	// if the copy would break the policy of the tree an `ErrPolicyViolation`.
+	// Either the whole subtree is copied or nothing is.
+	Copy(id, parentID int, name string) (*Node, error)
+
+	// Import creates the given trees of nodes under the node with
+	// `parentID`. If `parentID` is set to `0`, then the trees are created as
+	// root nodes. Only the names, types, positions, attributes and children
+	// of the given nodes are used, unless `opts.PreserveIDs` is set. Nodes
+	// with position 0 are placed like created nodes. The trees keep their
+	// positions relative to each other, after the existing children of the
+	// parent. The created trees are returned with all their decendants.
+	//
+	// If the parent could not be found an `ErrNotFound` error will be
+	// returned. If a node of the trees has a name which is already taken by
+	// one of its siblings an `ErrNameTaken` will be returned. If an id is
+	// preserved which is already in use an `ErrIDTaken` will be returned.
+	// If a node breaks the rules of the type schema an `ErrSchemaViolation`
+	// will be returned, and if a node breaks the policy of its tree an
+	// `ErrPolicyViolation`. Either all the trees are created or nothing is.
+	//
+	// If `opts.DeletedAt` is set the trees are created in the trash instead,
+	// as if each of them had been deleted at that time. The parent may then
+	// be in the trash as well, and the names of the trees are only checked
+	// against each other, as deleted nodes don't take up names. The type
+	// schema and the policy of the tree are checked when the trees are
+	// restored.
+	Import(parentID int, trees []*Node, opts ImportOptions) ([]*Node, error)
+
+	// CreatePath creates the nodes on the slash separated `path` which does
+	// not already exist, much like `mkdir -p`. The first name of the path is
+	// the name of a root node. The node at the end of the path is returned
+	// along with its children. The created nodes are untyped.
+	//
+	// If the path is empty an `ErrPathNotFound` will be returned. If the type
+	// schema does not allow untyped nodes where they would be created an
+	// `ErrSchemaViolation` will be returned. If the created nodes would break
+	// the policy of the tree an `ErrPolicyViolation` will be returned.
+	CreatePath(path string) (*Node, error)
+
+	// GetByPath gets the node at the end of the slash separated `path` along
+	// with its children.
+	//
+	// If the node could not be found an `ErrPathNotFound` will be returned.
+	GetByPath(path string) (*Node, error)
+
+	// DeleteByPath moves the node at the end of the slash separated `path`
+	// along with all its decendent children to the trash like `Delete`.
+	//
+	// If the node could not be found an `ErrPathNotFound` will be returned.
+	DeleteByPath(path string) error
+
+	// GetPolicy gets the policy of the tree with its root at the node with
+	// `rootID`. Trees without a policy have an empty policy.
+	//
+	// If the node could not be found an `ErrNotFound` error will be returned.
+	// If the node is not a root node an `ErrNotRoot` error will be returned.
+	GetPolicy(rootID int) (Policy, error)
+
+	// SetPolicy sets the policy of the tree with its root at the node with
+	// `rootID`. An empty policy removes the limits of the tree. Existing
+	// nodes are not checked, so the policy only limits later changes. The
+	// policy is removed if the root is moved into another tree.
+	//
+	// If the node could not be found an `ErrNotFound` error will be returned.
+	// If the node is not a root node an `ErrNotRoot` error will be returned.
+	SetPolicy(rootID int, policy Policy) error
+
+	// SetTypeSchema sets the schema which the types and attributes of the
+	// nodes must follow from now on. Existing nodes are not checked, but
+	// deleted nodes are checked when they are restored. A nil schema allows
+	// any tree, which is the default. It is safe to call while the storage
+	// is in use.
+	SetTypeSchema(schema *TypeSchema)
+
 	//Delete(node *Node) error
-	//GetByIDRec(id int) (*Node, error)
 	//GetByPathRec(path string) (*Node, error)
 }
+
+// CreateOptions controls how a node is created by `Storage.Create`
+type CreateOptions struct {
+	// Type is the type of the node, which must be registered in the type
+	// schema of the storage. The node is untyped if Type is empty.
+	Type string
+	// Attributes are the initial attributes of the node
+	Attributes map[string]interface{}
+	// Placement places the node among its siblings
+	Placement Placement
+}
+
+// ImportOptions controls how trees are created by `Storage.Import`
+type ImportOptions struct {
+	// PreserveIDs creates the nodes with the ids they are given instead of
+	// assigning new ones, which is used when restoring a dump. Nodes with an
+	// id of 0 are still assigned a new id.
+	PreserveIDs bool
+	// DeletedAt creates the trees in the trash as deleted at the given time
+	// if it is set, which is used when restoring the trash of a dump
+	DeletedAt time.Time
+}
+
+// ListOptions selects a page of siblings ordered by position and name for
+// `Storage.GetChildren`. Pages are found by the position and name of the last
+// node of the previous page rather than an offset, such that no siblings are
+// skipped or repeated when the siblings change between pages.
+type ListOptions struct {
+	// After is the name of the last node of the previous page. The page
+	// starts at the first sibling after it, or at the first sibling if After
+	// is empty.
+	After string
+	// AfterPosition is the position of the last node of the previous page
+	AfterPosition int
+	// Limit is the maximum number of nodes in the page. A Limit of 0 gets
+	// all the siblings after `After`.
+	Limit int
+	// Attributes filters the siblings to the ones having all of the
+	// attributes with equal values.
+	Attributes map[string]interface{}
+}
@@ -0,0 +1,1627 @@
+// Package storagetest provides a conformance test suite for implementations
+// of the `amznode.Storage` interface.
+//
+// The suite only relies on the behavior documented on `amznode.Storage`, so it
+// can be used to validate any backend:
+//
+//	func TestStorage(t *testing.T) {
+//		storagetest.Run(t, func(t *testing.T) amznode.Storage {
+//			return mybackend.New()
+//		})
+//	}
+package storagetest
+
+import (
+	"errors"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/blacksails/amznode"
+)
+
+// Run runs the conformance test suite. `newStorage` is called at the start of
+// every test and must return an empty storage.
+func Run(t *testing.T, newStorage func(t *testing.T) amznode.Storage) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, s amznode.Storage)
+	}{
+		{"Create", testCreate},
+		{"CreateNameTaken", testCreateNameTaken},
+		{"CreateParentNotFound", testCreateParentNotFound},
+		{"Get", testGet},
+		{"GetNotFound", testGetNotFound},
+		{"GetRec", testGetRec},
+		{"GetAncestors", testGetAncestors},
+		{"GetRoots", testGetRoots},
+		{"GetRootsRec", testGetRootsRec},
+		{"GetChildren", testGetChildren},
+		{"Search", testSearch},
+		{"WalkRec", testWalkRec},
+		{"WalkRootsRec", testWalkRootsRec},
+		{"ChangeParent", testChangeParent},
+		{"ChangeParentNotFound", testChangeParentNotFound},
+		{"ChangeParentCycle", testChangeParentCycle},
+		{"ChangeParentNameTaken", testChangeParentNameTaken},
+		{"Rename", testRename},
+		{"Position", testPosition},
+		{"PositionRestore", testPositionRestore},
+		{"PositionImport", testPositionImport},
+		{"Attributes", testAttributes},
+		{"AttributesFilter", testAttributesFilter},
+		{"Types", testTypes},
+		{"TypesAttributes", testTypesAttributes},
+		{"TypesRestore", testTypesRestore},
+		{"Policy", testPolicy},
+		{"Copy", testCopy},
+		{"Import", testImport},
+		{"ImportPreserveIDs", testImportPreserveIDs},
+		{"Delete", testDelete},
+		{"DeleteNotFound", testDeleteNotFound},
+		{"Trash", testTrash},
+		{"Purge", testPurge},
+		{"TrashRec", testTrashRec},
+		{"ImportTrash", testImportTrash},
+		{"CreatePath", testCreatePath},
+		{"GetByPath", testGetByPath},
+		{"DeleteByPath", testDeleteByPath},
+		{"ConcurrentCreate", testConcurrentCreate},
+		{"ConcurrentCreatePath", testConcurrentCreatePath},
+		{"ConcurrentChangeParent", testConcurrentChangeParent},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			test.test(t, newStorage(t))
+		})
+	}
+}
+
+// tree is a map from node names to ids. Node names are unique within the test
+// tree, which makes it easy to refer to the nodes in the tests.
+type tree map[string]int
+
+// createTree creates the following tree and returns the ids of the nodes
+//
+//	root
+//	  c1
+//	    c3
+//	    c4
+//	      c5
+//	        c6
+//	  c2
+//	other
+//	  c7
+func createTree(t *testing.T, s amznode.Storage) tree {
+	nodes := []struct {
+		parent string
+		name   string
+	}{
+		{parent: "", name: "root"},
+		{parent: "root", name: "c1"},
+		{parent: "root", name: "c2"},
+		{parent: "c1", name: "c3"},
+		{parent: "c1", name: "c4"},
+		{parent: "c4", name: "c5"},
+		{parent: "c5", name: "c6"},
+		{parent: "", name: "other"},
+		{parent: "other", name: "c7"},
+	}
+
+	ids := tree{}
+	for _, n := range nodes {
+		node, err := s.Create(n.name, ids[n.parent], amznode.CreateOptions{})
+		require.NoError(t, err, "could not create node %s", n.name)
+		ids[n.name] = node.ID
+	}
+	return ids
+}
+
+func testCreate(t *testing.T, s amznode.Storage) {
+	root, err := s.Create("root", 0, amznode.CreateOptions{})
+	require.NoError(t, err)
+	assert.NotZero(t, root.ID)
+	assert.Equal(t, amznode.Node{
+		ID:     root.ID,
+		Name:   "root",
+		RootID: root.ID,
+	}, *root)
+
+	child, err := s.Create("child", root.ID, amznode.CreateOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, amznode.Node{
+		ID:       child.ID,
+		ParentID: root.ID,
+		Name:     "child",
+		RootID:   root.ID,
+		Height:   1,
+	}, *child)
+
+	grandChild, err := s.Create("child", child.ID, amznode.CreateOptions{})
+	require.NoError(t, err, "names only have to be unique among siblings")
+	assert.Equal(t, amznode.Node{
+		ID:       grandChild.ID,
+		ParentID: child.ID,
+		Name:     "child",
+		RootID:   root.ID,
+		Height:   2,
+	}, *grandChild)
+
+	assert.NotEqual(t, root.ID, child.ID)
+	assert.NotEqual(t, child.ID, grandChild.ID)
+}
+
+func testCreateNameTaken(t *testing.T, s amznode.Storage) {
+	ids := createTree(t, s)
+
+	_, err := s.Create("c2", ids["root"], amznode.CreateOptions{})
+	assert.Equal(t, amznode.NewErrNameTaken("c2", ids["root"]), err)
+
+	_, err = s.Create("other", 0, amznode.CreateOptions{})
+	assert.Equal(t, amznode.NewErrNameTaken("other", 0), err, "root names must be unique")
+
+	node, err := s.Get(ids["root"])
+	require.NoError(t, err)
+	assert.Len(t, node.Children, 2, "no node must be created on errors")
+}
+
+func testCreateParentNotFound(t *testing.T, s amznode.Storage) {
+	ids := createTree(t, s)
+	require.NoError(t, s.Delete(ids["c2"]))
+
+	_, err := s.Create("c8", ids["c2"], amznode.CreateOptions{})
+	assert.Equal(t, amznode.NewErrNotFound(ids["c2"]), err)
+}
+
+func testGet(t *testing.T, s amznode.Storage) {
+	ids := createTree(t, s)
+
+	node, err := s.Get(ids["root"])
+	require.NoError(t, err)
+	assert.Equal(t, amznode.Node{
+		ID:     ids["root"],
+		Name:   "root",
+		RootID: ids["root"],
+		Children: []*amznode.Node{
+			{
+				ID:       ids["c1"],
+				ParentID: ids["root"],
+				Name:     "c1",
+				RootID:   ids["root"],
+				Height:   1,
+			},
+			{
+				ID:       ids["c2"],
+				ParentID: ids["root"],
+				Name:     "c2",
+				RootID:   ids["root"],
+				Height:   1,
+			},
+		},
+	}, *node, "only immediate children must be returned sorted by name")
+
+	node, err = s.Get(ids["c5"])
+	require.NoError(t, err)
+	assert.Equal(t, amznode.Node{
+		ID:       ids["c5"],
+		ParentID: ids["c4"],
+		Name:     "c5",
+		RootID:   ids["root"],
+		Height:   3,
+		Children: []*amznode.Node{
+			{
+				ID:       ids["c6"],
+				ParentID: ids["c5"],
+				Name:     "c6",
+				RootID:   ids["root"],
+				Height:   4,
+			},
+		},
+	}, *node)
+
+	node, err = s.Get(ids["c6"])
+	require.NoError(t, err)
+	assert.Empty(t, node.Children)
+}
+
+func testGetNotFound(t *testing.T, s amznode.Storage) {
+	_, err := s.Get(42)
+	assert.Equal(t, amznode.NewErrNotFound(42), err)
+
+	ids := createTree(t, s)
+	_, err = s.Get(ids["c7"] + 1000)
+	assert.Equal(t, amznode.NewErrNotFound(ids["c7"]+1000), err)
+}
+
+func testGetRoots(t *testing.T, s amznode.Storage) {
+	roots, err := s.GetRoots()
+	require.NoError(t, err)
+	assert.Empty(t, roots)
+
+	ids := createTree(t, s)
+	zroot, err := s.Create("zroot", 0, amznode.CreateOptions{})
+	require.NoError(t, err)
+	aroot, err := s.Create("aroot", 0, amznode.CreateOptions{})
+	require.NoError(t, err)
+
+	roots, err = s.GetRoots()
+	require.NoError(t, err)
+	names := []string{}
+	for _, root := range roots {
+		names = append(names, root.Name)
+		assert.Equal(t, root.ID, root.RootID)
+		assert.Zero(t, root.Height)
+		assert.Zero(t, root.ParentID)
+	}
+	assert.Equal(t, []string{"aroot", "other", "root", "zroot"}, names, "roots must be sorted by name")
+
+	assert.Empty(t, roots[0].Children)
+	assert.Equal(t, aroot.ID, roots[0].ID)
+	assert.Equal(t, []*amznode.Node{
+		{
+			ID:       ids["c7"],
+			ParentID: ids["other"],
+			Name:     "c7",
+			RootID:   ids["other"],
+			Height:   1,
+		},
+	}, roots[1].Children)
+	assert.Len(t, roots[2].Children, 2, "only immediate children must be returned")
+	assert.Equal(t, zroot.ID, roots[3].ID)
+}
+
+// names returns the names of the nodes in the tree in depth first order
+func names(nodes ...*amznode.Node) []string {
+	result := []string{}
+	for _, n := range nodes {
+		result = append(result, n.Name)
+		result = append(result, names(n.Children...)...)
+	}
+	return result
+}
+
+func testGetRec(t *testing.T, s amznode.Storage) {
+	ids := createTree(t, s)
+
+	node, err := s.GetRec(ids["c1"], 0)
+	require.NoError(t, err)
+	assert.Empty(t, node.Children)
+	assert.Equal(t, 1, node.Height)
+
+	node, err = s.GetRec(ids["c1"], 1)
+	require.NoError(t, err)
+	expected, err := s.Get(ids["c1"])
+	require.NoError(t, err)
+	assert.Equal(t, expected, node)
+
+	node, err = s.GetRec(ids["c1"], 2)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c1", "c3", "c4", "c5"}, names(node))
+
+	node, err = s.GetRec(ids["root"], -1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"root", "c1", "c3", "c4", "c5", "c6", "c2"}, names(node))
+	c6 := node.Children[0].Children[1].Children[0].Children[0]
+	assert.Equal(t, amznode.Node{
+		ID:       ids["c6"],
+		ParentID: ids["c5"],
+		Name:     "c6",
+		RootID:   ids["root"],
+		Height:   4,
+	}, *c6)
+
+	_, err = s.GetRec(ids["c7"]+1000, -1)
+	assert.Equal(t, amznode.NewErrNotFound(ids["c7"]+1000), err)
+}
+
+func testGetAncestors(t *testing.T, s amznode.Storage) {
+	ids := createTree(t, s)
+
+	ancestors, err := s.GetAncestors(ids["c5"])
+	require.NoError(t, err)
+	assert.Equal(t, []*amznode.Node{
+		{
+			ID:     ids["root"],
+			Name:   "root",
+			RootID: ids["root"],
+		},
+		{
+			ID:       ids["c1"],
+			ParentID: ids["root"],
+			Name:     "c1",
+			RootID:   ids["root"],
+			Height:   1,
+		},
+		{
+			ID:       ids["c4"],
+			ParentID: ids["c1"],
+			Name:     "c4",
+			RootID:   ids["root"],
+			Height:   2,
+		},
+		{
+			ID:       ids["c5"],
+			ParentID: ids["c4"],
+			Name:     "c5",
+			RootID:   ids["root"],
+			Height:   3,
+		},
+	}, ancestors)
+
+	ancestors, err = s.GetAncestors(ids["other"])
+	require.NoError(t, err)
+	assert.Equal(t, []string{"other"}, names(ancestors...))
+
+	_, err = s.GetAncestors(ids["c7"] + 1000)
+	assert.Equal(t, amznode.NewErrNotFound(ids["c7"]+1000), err)
+}
+
+func testGetRootsRec(t *testing.T, s amznode.Storage) {
+	roots, err := s.GetRootsRec(-1)
+	require.NoError(t, err)
+	assert.Empty(t, roots)
+
+	createTree(t, s)
+
+	roots, err = s.GetRootsRec(0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"other", "root"}, names(roots...))
+
+	roots, err = s.GetRootsRec(1)
+	require.NoError(t, err)
+	expected, err := s.GetRoots()
+	require.NoError(t, err)
+	assert.Equal(t, expected, roots)
+
+	roots, err = s.GetRootsRec(-1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"other", "c7", "root", "c1", "c3", "c4", "c5", "c6", "c2"}, names(roots...))
+}
+
+func testGetChildren(t *testing.T, s amznode.Storage) {
+	ids := createTree(t, s)
+
+	nodes, err := s.GetChildren(ids["root"], 0, amznode.ListOptions{Limit: 1})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c1"}, names(nodes...))
+	assert.Equal(t, ids["root"], nodes[0].ParentID)
+	assert.Equal(t, ids["root"], nodes[0].RootID)
+	assert.Equal(t, 1, nodes[0].Height)
+	nodes, err = s.GetChildren(ids["root"], 0, amznode.ListOptions{After: "c1", Limit: 1})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c2"}, names(nodes...))
+	nodes, err = s.GetChildren(ids["root"], 0, amznode.ListOptions{After: "c2", Limit: 1})
+	require.NoError(t, err)
+	assert.Empty(t, nodes)
+
+	nodes, err = s.GetChildren(ids["c1"], 1, amznode.ListOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c3", "c4", "c5"}, names(nodes...))
+
+	nodes, err = s.GetChildren(0, 0, amznode.ListOptions{Limit: 1})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"other"}, names(nodes...))
+	assert.True(t, nodes[0].IsRoot())
+	nodes, err = s.GetChildren(0, -1, amznode.ListOptions{After: "other"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"root", "c1", "c3", "c4", "c5", "c6", "c2"}, names(nodes...))
+
+	_, err = s.Create("C0", ids["root"], amznode.CreateOptions{})
+	require.NoError(t, err)
+	nodes, err = s.GetChildren(ids["root"], 0, amznode.ListOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"C0", "c1", "c2"}, names(nodes...),
+		"names must be ordered bytewise like the sorting of children")
+
+	missingID := ids["c7"] + 1000
+	_, err = s.GetChildren(missingID, 0, amznode.ListOptions{})
+	assert.Equal(t, amznode.NewErrNotFound(missingID), err)
+}
+
+func testSearch(t *testing.T, s amznode.Storage) {
+	ids := createTree(t, s)
+	paths := func(results []*amznode.SearchResult) []string {
+		paths := []string{}
+		for _, result := range results {
+			paths = append(paths, result.Path)
+		}
+		return paths
+	}
+
+	results, err := s.Search("c", amznode.SearchOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"other/c7",
+		"root/c1",
+		"root/c1/c3",
+		"root/c1/c4",
+		"root/c1/c4/c5",
+		"root/c1/c4/c5/c6",
+		"root/c2",
+	}, paths(results), "results must be ordered by path")
+
+	results, err = s.Search("c5", amznode.SearchOptions{Match: amznode.MatchExact})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, amznode.Node{
+		ID:       ids["c5"],
+		ParentID: ids["c4"],
+		Name:     "c5",
+		RootID:   ids["root"],
+		Height:   3,
+	}, results[0].Node)
+	assert.Equal(t, "root/c1/c4/c5", results[0].Path)
+
+	results, err = s.Search("ot", amznode.SearchOptions{Match: amznode.MatchPrefix})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"other"}, paths(results))
+	results, err = s.Search("oo", amznode.SearchOptions{Match: amznode.MatchPrefix})
+	require.NoError(t, err)
+	assert.Empty(t, results)
+	results, err = s.Search("oo", amznode.SearchOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"root"}, paths(results))
+
+	results, err = s.Search("c", amznode.SearchOptions{RootID: ids["other"]})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"other/c7"}, paths(results))
+	results, err = s.Search("c", amznode.SearchOptions{Limit: 2})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"other/c7", "root/c1"}, paths(results))
+
+	for _, name := range []string{"a_b", "axb"} {
+		_, err := s.Create(name, ids["c7"], amznode.CreateOptions{})
+		require.NoError(t, err)
+	}
+	results, err = s.Search("a_b", amznode.SearchOptions{Match: amznode.MatchExact})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"other/c7/a_b"}, paths(results), "wildcards must be matched literally")
+	results, err = s.Search("_", amznode.SearchOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"other/c7/a_b"}, paths(results))
+	results, err = s.Search("%", amznode.SearchOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, results)
+
+	missingID := ids["c7"] + 1000
+	_, err = s.Search("c", amznode.SearchOptions{RootID: missingID})
+	assert.Equal(t, amznode.NewErrNotFound(missingID), err)
+	_, err = s.Search("c", amznode.SearchOptions{RootID: ids["c1"]})
+	assert.Equal(t, amznode.NewErrNotRoot(ids["c1"]), err)
+}
+
+// walk collects the nodes visited by a walk
+func walk(walkFunc func(f func(*amznode.Node) error) error) ([]*amznode.Node, error) {
+	nodes := []*amznode.Node{}
+	err := walkFunc(func(node *amznode.Node) error {
+		nodes = append(nodes, node)
+		return nil
+	})
+	return nodes, err
+}
+
+func testWalkRec(t *testing.T, s amznode.Storage) {
+	ids := createTree(t, s)
+	_, err := s.Create("c0", ids["c4"], amznode.CreateOptions{})
+	require.NoError(t, err)
+
+	nodes, err := walk(func(f func(*amznode.Node) error) error {
+		return s.WalkRec(ids["c1"], -1, f)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c1", "c3", "c4", "c0", "c5", "c6"}, names(nodes...),
+		"nodes must be walked level by level")
+	for _, node := range nodes {
+		assert.Empty(t, node.Children)
+		assert.Equal(t, ids["root"], node.RootID)
+	}
+	assert.Equal(t, ids["c4"], nodes[3].ParentID)
+	assert.Equal(t, 3, nodes[3].Height)
+
+	nodes, err = walk(func(f func(*amznode.Node) error) error {
+		return s.WalkRec(ids["c1"], 1, f)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c1", "c3", "c4"}, names(nodes...))
+
+	missingID := ids["c7"] + 1000
+	err = s.WalkRec(missingID, -1, func(*amznode.Node) error { return nil })
+	assert.Equal(t, amznode.NewErrNotFound(missingID), err)
+
+	stop := errors.New("stop")
+	visited := 0
+	err = s.WalkRec(ids["root"], -1, func(*amznode.Node) error {
+		visited++
+		if visited == 2 {
+			return stop
+		}
+		return nil
+	})
+	assert.Equal(t, stop, err)
+	assert.Equal(t, 2, visited, "the walk must stop at the first error")
+}
+
+func testWalkRootsRec(t *testing.T, s amznode.Storage) {
+	nodes, err := walk(func(f func(*amznode.Node) error) error {
+		return s.WalkRootsRec(-1, f)
+	})
+	require.NoError(t, err)
+	assert.Empty(t, nodes)
+
+	ids := createTree(t, s)
+
+	nodes, err = walk(func(f func(*amznode.Node) error) error {
+		return s.WalkRootsRec(1, f)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"other", "root", "c1", "c2", "c7"}, names(nodes...))
+	assert.Equal(t, ids["other"], nodes[4].RootID)
+
+	nodes, err = walk(func(f func(*amznode.Node) error) error {
+		return s.WalkRootsRec(-1, f)
+	})
+	require.NoError(t, err)
+	assert.Equal(t,
+		[]string{"other", "root", "c1", "c2", "c7", "c3", "c4", "c5", "c6"}, names(nodes...))
+	assert.Equal(t, 4, nodes[8].Height)
+}
+
+func testChangeParent(t *testing.T, s amznode.Storage) {
+	ids := createTree(t, s)
+
+	require.NoError(t, s.ChangeParent(ids["c4"], ids["c7"], amznode.Placement{}))
+
+	node, err := s.Get(ids["c4"])
+	require.NoError(t, err)
+	assert.Equal(t, ids["c7"], node.ParentID)
+	assert.Equal(t, ids["other"], node.RootID)
+	assert.Equal(t, 2, node.Height)
+
+	node, err = s.Get(ids["c6"])
+	require.NoError(t, err)
+	assert.Equal(t, ids["other"], node.RootID, "decendants must follow the moved node")
+	assert.Equal(t, 4, node.Height)
+
+	node, err = s.Get(ids["c1"])
+	require.NoError(t, err)
+	assert.Len(t, node.Children, 1)
+
+	node, err = s.Get(ids["c7"])
+	require.NoError(t, err)
+	require.Len(t, node.Children, 1)
+	assert.Equal(t, ids["c4"], node.Children[0].ID)
+}
+
+func testChangeParentNotFound(t *testing.T, s amznode.Storage) {
+	ids := createTree(t, s)
+	missingID := ids["c7"] + 1000
+
+	err := s.ChangeParent(missingID, ids["root"], amznode.Placement{})
+	assert.Equal(t, amznode.NewErrNotFound(missingID), err)
+
+	err = s.ChangeParent(ids["c1"], missingID, amznode.Placement{})
+	assert.Equal(t, amznode.NewErrNotFound(missingID), err)
+}
+
+func testChangeParentCycle(t *testing.T, s amznode.Storage) {
+	ids := createTree(t, s)
+
+	err := s.ChangeParent(ids["c1"], ids["c6"], amznode.Placement{})
+	assert.Equal(t, amznode.NewErrNodeIsDecendant(ids["c1"], ids["c6"]), err)
+
+	err = s.ChangeParent(ids["c4"], ids["c4"], amznode.Placement{})
+	assert.Equal(t, amznode.NewErrNodeIsDecendant(ids["c4"], ids["c4"]), err)
+
+	node, err := s.Get(ids["c1"])
+	require.NoError(t, err)
+	assert.Equal(t, ids["root"], node.ParentID)
+}
+
+func testChangeParentNameTaken(t *testing.T, s amznode.Storage) {
+	ids := createTree(t, s)
+	_, err := s.Create("c2", ids["c1"], amznode.CreateOptions{})
+	require.NoError(t, err)
+
+	err = s.ChangeParent(ids["c2"], ids["c1"], amznode.Placement{})
+	assert.Equal(t, amznode.NewErrNameTaken("c2", ids["c1"]), err)
+
+	err = s.ChangeParent(ids["c2"], ids["root"], amznode.Placement{})
+	assert.NoError(t, err, "moving a node to its current parent must be allowed")
+
+	node, err := s.Get(ids["c2"])
+	require.NoError(t, err)
+	assert.Equal(t, ids["root"], node.ParentID)
+}
+
+func testRename(t *testing.T, s amznode.Storage) {
+	ids := createTree(t, s)
+
+	require.NoError(t, s.Rename(ids["c4"], "renamed"))
+	node, err := s.Get(ids["c4"])
+	require.NoError(t, err)
+	assert.Equal(t, "renamed", node.Name)
+	assert.Len(t, node.Children, 1, "the children must be kept")
+
+	node, err = s.GetByPath("root/c1/renamed/c5")
+	require.NoError(t, err)
+	assert.Equal(t, ids["c5"], node.ID)
+
+	require.NoError(t, s.Rename(ids["c3"], "c3"), "renaming to the same name must be allowed")
+
+	err = s.Rename(ids["c3"], "renamed")
+	assert.Equal(t, amznode.NewErrNameTaken("renamed", ids["c1"]), err)
+
+	err = s.Rename(ids["root"], "other")
+	assert.Equal(t, amznode.NewErrNameTaken("other", 0), err)
+
+	_, err = s.Create("c4", ids["c1"], amznode.CreateOptions{})
+	assert.NoError(t, err, "the old name must be available")
+
+	err = s.Rename(ids["c7"]+1000, "missing")
+	assert.Equal(t, amznode.NewErrNotFound(ids["c7"]+1000), err)
+}
+
+func testPosition(t *testing.T, s amznode.Storage) {
+	ids := createTree(t, s)
+	childNames := func(id int) []string {
+		node, err := s.Get(id)
+		require.NoError(t, err)
+		return names(node.Children...)
+	}
+
+	c0, err := s.Create("c0", ids["root"], amznode.CreateOptions{
+		Placement: amznode.Placement{After: ids["c1"]},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, c0.Position)
+	assert.Equal(t, []string{"c1", "c0", "c2"}, childNames(ids["root"]))
+
+	c8, err := s.Create("c8", ids["root"], amznode.CreateOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 4, c8.Position, "the siblings are ordered explicitly")
+	require.NoError(t, s.SetPosition(c8.ID, amznode.Placement{Before: ids["c1"]}))
+	assert.Equal(t, []string{"c8", "c1", "c0", "c2"}, childNames(ids["root"]))
+
+	nodes, err := s.GetChildren(ids["root"], 0, amznode.ListOptions{Limit: 2})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c8", "c1"}, names(nodes...))
+	nodes, err = s.GetChildren(ids["root"], 0, amznode.ListOptions{
+		After: nodes[1].Name, AfterPosition: nodes[1].Position, Limit: 2,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c0", "c2"}, names(nodes...))
+
+	nodes, err = walk(func(f func(*amznode.Node) error) error {
+		return s.WalkRec(ids["root"], 1, f)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"root", "c8", "c1", "c0", "c2"}, names(nodes...))
+
+	err = s.SetPosition(c8.ID, amznode.Placement{Before: ids["c3"]})
+	assert.Equal(t, amznode.NewErrNotSibling(ids["c3"], ids["root"]), err)
+	err = s.SetPosition(c8.ID, amznode.Placement{After: c8.ID})
+	assert.Equal(t, amznode.NewErrNotSibling(c8.ID, ids["root"]), err)
+	missingID := ids["c7"] + 1000
+	err = s.SetPosition(missingID, amznode.Placement{After: c8.ID})
+	assert.Equal(t, amznode.NewErrNotFound(missingID), err)
+	err = s.SetPosition(c8.ID, amznode.Placement{After: missingID})
+	assert.Equal(t, amznode.NewErrNotFound(missingID), err)
+	_, err = s.Create("c9", ids["root"], amznode.CreateOptions{
+		Placement: amznode.Placement{Before: ids["c3"]},
+	})
+	assert.Equal(t, amznode.NewErrNotSibling(ids["c3"], ids["root"]), err)
+	_, err = s.GetByPath("root/c9")
+	assert.Equal(t, amznode.NewErrPathNotFound("root/c9"), err)
+
+	require.NoError(t, s.ChangeParent(ids["c7"], ids["root"], amznode.Placement{After: c8.ID}))
+	require.NoError(t, s.ChangeParent(ids["c3"], ids["root"], amznode.Placement{}))
+	assert.Equal(t, []string{"c8", "c7", "c1", "c0", "c2", "c3"}, childNames(ids["root"]))
+	require.NoError(t, s.ChangeParent(ids["c2"], ids["root"], amznode.Placement{Before: c8.ID}))
+	assert.Equal(t, []string{"c2", "c8", "c7", "c1", "c0", "c3"}, childNames(ids["root"]))
+	err = s.ChangeParent(ids["c5"], ids["root"], amznode.Placement{After: ids["c6"]})
+	assert.Equal(t, amznode.NewErrNotSibling(ids["c6"], ids["root"]), err)
+
+	require.NoError(t, s.ChangeParent(c8.ID, ids["other"], amznode.Placement{}))
+	node, err := s.Get(c8.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 0, node.Position, "the children of other are ordered by name")
+
+	node, err = s.Copy(ids["root"], 0, "copy")
+	require.NoError(t, err)
+	assert.Equal(t, 0, node.Position)
+	assert.Equal(t, []string{"c2", "c7", "c1", "c0", "c3"}, childNames(node.ID),
+		"the decendants of a copy must keep their positions")
+
+	first, err := s.Create("first", 0, amznode.CreateOptions{
+		Placement: amznode.Placement{Before: ids["other"]},
+	})
+	require.NoError(t, err)
+	roots, err := s.GetRootsRec(0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"copy", "first", "other", "root"}, names(roots...))
+	assert.Equal(t, 2, first.Position)
+}
+
+func testPositionRestore(t *testing.T, s amznode.Storage) {
+	ids := createTree(t, s)
+	require.NoError(t, s.SetPosition(ids["c4"], amznode.Placement{Before: ids["c3"]}))
+	require.NoError(t, s.Delete(ids["c4"]))
+	c8, err := s.Create("c8", ids["c1"], amznode.CreateOptions{})
+	require.NoError(t, err)
+	require.NoError(t, s.SetPosition(c8.ID, amznode.Placement{Before: ids["c3"]}))
+
+	restored, err := s.Restore(ids["c4"])
+	require.NoError(t, err)
+	assert.Equal(t, 3, restored.Position, "the node must be placed after its siblings")
+	node, err := s.Get(ids["c1"])
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c8", "c3", "c4"}, names(node.Children...))
+	assert.Equal(t, []int{1, 2, 3}, positions(node.Children))
+}
+
+func testPositionImport(t *testing.T, s amznode.Storage) {
+	ids := createTree(t, s)
+	require.NoError(t, s.SetPosition(ids["c4"], amznode.Placement{Before: ids["c3"]}))
+
+	imported, err := s.Import(ids["c1"], []*amznode.Node{
+		{Name: "c9", Position: 2},
+		{Name: "c8", Position: 1, Children: []*amznode.Node{
+			{Name: "b", Position: 1},
+			{Name: "a", Position: 2},
+		}},
+	}, amznode.ImportOptions{})
+	require.NoError(t, err)
+	node, err := s.Get(ids["c1"])
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c4", "c3", "c8", "c9"}, names(node.Children...),
+		"the trees must be placed after the siblings in their own order")
+	assert.Equal(t, []int{1, 2, 3, 4}, positions(node.Children))
+	node, err = s.Get(imported[1].ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b", "a"}, names(node.Children...))
+}
+
+// positions returns the positions of the nodes
+func positions(nodes []*amznode.Node) []int {
+	positions := make([]int, len(nodes))
+	for i, node := range nodes {
+		positions[i] = node.Position
+	}
+	return positions
+}
+
+func testAttributes(t *testing.T, s amznode.Storage) {
+	ids := createTree(t, s)
+
+	attrs, err := s.SetAttributes(ids["c1"], map[string]interface{}{
+		"manager":  "alice",
+		"floor":    3,
+		"building": map[string]interface{}{"city": "Berlin"},
+	})
+	require.NoError(t, err)
+	expected := map[string]interface{}{
+		"manager":  "alice",
+		"floor":    float64(3),
+		"building": map[string]interface{}{"city": "Berlin"},
+	}
+	assert.Equal(t, expected, attrs, "numbers must be returned as float64")
+
+	node, err := s.Get(ids["c1"])
+	require.NoError(t, err)
+	assert.Equal(t, expected, node.Attributes)
+	node, err = s.Get(ids["root"])
+	require.NoError(t, err)
+	assert.Nil(t, node.Attributes, "nodes without attributes must have nil attributes")
+	assert.Equal(t, expected, node.Children[0].Attributes)
+
+	attrs, err = s.PatchAttributes(ids["c1"], map[string]interface{}{
+		"manager": "bob",
+		"floor":   nil,
+		"unknown": nil,
+		"cost":    "cc-42",
+	})
+	require.NoError(t, err)
+	expected = map[string]interface{}{
+		"manager":  "bob",
+		"cost":     "cc-42",
+		"building": map[string]interface{}{"city": "Berlin"},
+	}
+	assert.Equal(t, expected, attrs)
+
+	copied, err := s.Copy(ids["c1"], ids["c7"], "")
+	require.NoError(t, err)
+	assert.Equal(t, expected, copied.Attributes, "copies must keep the attributes")
+
+	nodes, err := s.Import(ids["c7"], []*amznode.Node{
+		{Name: "i1", Attributes: map[string]interface{}{"imported": true}},
+	}, amznode.ImportOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"imported": true}, nodes[0].Attributes)
+
+	attrs, err = s.PatchAttributes(ids["c1"], map[string]interface{}{
+		"manager": nil, "cost": nil, "building": nil,
+	})
+	require.NoError(t, err)
+	assert.Nil(t, attrs)
+	attrs, err = s.SetAttributes(ids["c7"], map[string]interface{}{"a": "b"})
+	require.NoError(t, err)
+	attrs, err = s.SetAttributes(ids["c7"], nil)
+	require.NoError(t, err)
+	assert.Nil(t, attrs)
+	node, err = s.Get(ids["c7"])
+	require.NoError(t, err)
+	assert.Nil(t, node.Attributes)
+
+	missingID := ids["c7"] + 1000
+	_, err = s.SetAttributes(missingID, map[string]interface{}{"a": "b"})
+	assert.Equal(t, amznode.NewErrNotFound(missingID), err)
+	_, err = s.PatchAttributes(missingID, map[string]interface{}{"a": "b"})
+	assert.Equal(t, amznode.NewErrNotFound(missingID), err)
+}
+
+func testAttributesFilter(t *testing.T, s amznode.Storage) {
+	ids := createTree(t, s)
+	for _, name := range []string{"c3", "c4"} {
+		_, err := s.SetAttributes(ids[name], map[string]interface{}{"city": "Berlin", "floor": 3})
+		require.NoError(t, err)
+	}
+	_, err := s.PatchAttributes(ids["c4"], map[string]interface{}{"floor": 4})
+	require.NoError(t, err)
+
+	filter := func(attrs map[string]interface{}) []string {
+		nodes, err := s.GetChildren(ids["c1"], 0, amznode.ListOptions{Attributes: attrs})
+		require.NoError(t, err)
+		return names(nodes...)
+	}
+	assert.Equal(t, []string{"c3", "c4"}, filter(map[string]interface{}{"city": "Berlin"}))
+	assert.Equal(t, []string{"c4"}, filter(map[string]interface{}{"city": "Berlin", "floor": 4}))
+	assert.Equal(t, []string{"c3"}, filter(map[string]interface{}{"floor": float64(3)}))
+	assert.Empty(t, filter(map[string]interface{}{"city": "Paris"}))
+	assert.Empty(t, filter(map[string]interface{}{"floor": "3"}), "values must have equal types")
+}
+
+// typeSchema is the schema used by the type tests
+const typeSchema = `{
+	"roots": ["company"],
+	"types": {
+		"company": {"children": ["division"]},
+		"division": {"children": ["team"]},
+		"team": {
+			"attributes": {
+				"manager": {"type": "string", "required": true},
+				"size": {"type": "integer", "minimum": 1}
+			}
+		}
+	}
+}`
+
+// setTypeSchema sets the type schema of the storage. The storage might be
+// reused by the next test, so the schema must be unset again.
+func setTypeSchema(t *testing.T, s amznode.Storage) {
+	schema, err := amznode.ReadTypeSchema(strings.NewReader(typeSchema))
+	require.NoError(t, err)
+	s.SetTypeSchema(schema)
+}
+
+func testTypes(t *testing.T, s amznode.Storage) {
+	setTypeSchema(t, s)
+	defer s.SetTypeSchema(nil)
+	team := amznode.CreateOptions{
+		Type:       "team",
+		Attributes: map[string]interface{}{"manager": "alice"},
+	}
+
+	acme, err := s.Create("acme", 0, amznode.CreateOptions{Type: "company"})
+	require.NoError(t, err)
+	assert.Equal(t, "company", acme.Type)
+	sales, err := s.Create("sales", acme.ID, amznode.CreateOptions{Type: "division"})
+	require.NoError(t, err)
+	emea, err := s.Create("emea", sales.ID, team)
+	require.NoError(t, err)
+	node, err := s.Get(sales.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "team", node.Children[0].Type)
+
+	_, err = s.Create("loose", 0, amznode.CreateOptions{Type: "team"})
+	assert.Equal(t, amznode.NewErrSchemaViolation(
+		"a node of type 'team' can't be a root node"), err)
+	_, err = s.Create("loose", 0, amznode.CreateOptions{})
+	assert.Equal(t, amznode.NewErrSchemaViolation(
+		"an untyped node can't be a root node"), err)
+	_, err = s.Create("apac", acme.ID, team)
+	assert.Equal(t, amznode.NewErrSchemaViolation(
+		"a node of type 'team' can't be a child of a node of type 'company'"), err)
+	_, err = s.Create("ops", acme.ID, amznode.CreateOptions{Type: "unit"})
+	assert.Equal(t, amznode.NewErrSchemaViolation(
+		"the node type 'unit' is not registered"), err)
+	_, err = s.Create("dev", emea.ID, amznode.CreateOptions{})
+	assert.Equal(t, amznode.NewErrSchemaViolation(
+		"an untyped node can't be a child of a node of type 'team'"), err)
+
+	err = s.ChangeParent(emea.ID, acme.ID, amznode.Placement{})
+	assert.Equal(t, amznode.NewErrSchemaViolation(
+		"a node of type 'team' can't be a child of a node of type 'company'"), err)
+	node, err = s.Get(emea.ID)
+	require.NoError(t, err)
+	assert.Equal(t, sales.ID, node.ParentID, "the node must not be moved")
+
+	_, err = s.Copy(emea.ID, acme.ID, "apac")
+	assert.Equal(t, amznode.NewErrSchemaViolation(
+		"a node of type 'team' can't be a child of a node of type 'company'"), err)
+	_, err = s.Import(acme.ID, []*amznode.Node{{
+		Name: "marketing",
+		Type: "division",
+		Children: []*amznode.Node{
+			{Name: "brand", Type: "division"},
+		},
+	}}, amznode.ImportOptions{})
+	assert.Equal(t, amznode.NewErrSchemaViolation(
+		"a node of type 'division' can't be a child of a node of type 'division'"), err)
+	_, err = s.GetByPath("acme/marketing")
+	assert.Equal(t, amznode.NewErrPathNotFound("acme/marketing"), err,
+		"nothing must be imported")
+
+	_, err = s.CreatePath("acme/sales/emea/dev")
+	assert.Equal(t, amznode.NewErrSchemaViolation(
+		"an untyped node can't be a child of a node of type 'team'"), err)
+	node, err = s.CreatePath("acme/sales/emea")
+	require.NoError(t, err, "existing nodes must not be checked")
+	assert.Equal(t, emea.ID, node.ID)
+
+	s.SetTypeSchema(nil)
+	_, err = s.Create("dev", emea.ID, amznode.CreateOptions{})
+	assert.NoError(t, err, "any tree must be allowed without a schema")
+}
+
+func testTypesAttributes(t *testing.T, s amznode.Storage) {
+	setTypeSchema(t, s)
+	defer s.SetTypeSchema(nil)
+	acme, err := s.Create("acme", 0, amznode.CreateOptions{Type: "company"})
+	require.NoError(t, err)
+	sales, err := s.Create("sales", acme.ID, amznode.CreateOptions{Type: "division"})
+	require.NoError(t, err)
+
+	_, err = s.Create("emea", sales.ID, amznode.CreateOptions{Type: "team"})
+	assert.Equal(t, amznode.NewErrSchemaViolation(
+		"the attribute 'manager' is required for a node of type 'team'"), err)
+	_, err = s.Create("emea", sales.ID, amznode.CreateOptions{
+		Type:       "team",
+		Attributes: map[string]interface{}{"manager": "alice", "size": 1.5},
+	})
+	assert.Equal(t, amznode.NewErrSchemaViolation(
+		"the attribute 'size' of a node of type 'team' must be of type integer"), err)
+	emea, err := s.Create("emea", sales.ID, amznode.CreateOptions{
+		Type:       "team",
+		Attributes: map[string]interface{}{"manager": "alice", "size": 4},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"manager": "alice", "size": float64(4)}, emea.Attributes)
+
+	_, err = s.PatchAttributes(emea.ID, map[string]interface{}{"manager": nil})
+	assert.Equal(t, amznode.NewErrSchemaViolation(
+		"the attribute 'manager' is required for a node of type 'team'"), err)
+	_, err = s.SetAttributes(emea.ID, map[string]interface{}{"manager": "bob", "size": 0})
+	assert.Equal(t, amznode.NewErrSchemaViolation(
+		"the attribute 'size' of a node of type 'team' must be at least 1"), err)
+	node, err := s.Get(emea.ID)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"manager": "alice", "size": float64(4)},
+		node.Attributes, "the attributes must not be changed")
+
+	attrs, err := s.PatchAttributes(emea.ID, map[string]interface{}{"size": nil, "cost": "cc-42"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"manager": "alice", "cost": "cc-42"}, attrs)
+}
+
+func testTypesRestore(t *testing.T, s amznode.Storage) {
+	setTypeSchema(t, s)
+	defer s.SetTypeSchema(nil)
+	acme, err := s.Create("acme", 0, amznode.CreateOptions{Type: "company"})
+	require.NoError(t, err)
+	sales, err := s.Create("sales", acme.ID, amznode.CreateOptions{Type: "division"})
+	require.NoError(t, err)
+	emea, err := s.Create("emea", sales.ID, amznode.CreateOptions{
+		Type:       "team",
+		Attributes: map[string]interface{}{"manager": "alice"},
+	})
+	require.NoError(t, err)
+	require.NoError(t, s.Delete(sales.ID))
+
+	changed, err := amznode.ReadTypeSchema(strings.NewReader(strings.Replace(typeSchema,
+		`"minimum": 1`, `"minimum": 1, "required": true`, 1)))
+	require.NoError(t, err)
+	s.SetTypeSchema(changed)
+	_, err = s.Restore(sales.ID)
+	assert.Equal(t, amznode.NewErrSchemaViolation(
+		"the attribute 'size' is required for a node of type 'team'"), err,
+		"the decendants must be checked against the current schema")
+	_, err = s.Get(emea.ID)
+	assert.Equal(t, amznode.NewErrNotFound(emea.ID), err, "nothing must be restored")
+
+	setTypeSchema(t, s)
+	node, err := s.Restore(sales.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"emea"}, names(node.Children...))
+}
+
+func testPolicy(t *testing.T, s amznode.Storage) {
+	ids := createTree(t, s)
+	policy := amznode.Policy{MaxHeight: 4, MaxChildren: 2}
+	heightErr := amznode.NewErrPolicyViolation(ids["root"], policy, "height")
+	childrenErr := amznode.NewErrPolicyViolation(ids["root"], policy, "children")
+
+	p, err := s.GetPolicy(ids["root"])
+	require.NoError(t, err)
+	assert.Equal(t, amznode.Policy{}, p)
+	err = s.SetPolicy(ids["c1"], policy)
+	assert.Equal(t, amznode.NewErrNotRoot(ids["c1"]), err)
+	missingID := ids["c7"] + 1000
+	err = s.SetPolicy(missingID, policy)
+	assert.Equal(t, amznode.NewErrNotFound(missingID), err)
+
+	require.NoError(t, s.SetPolicy(ids["root"], policy))
+	p, err = s.GetPolicy(ids["root"])
+	require.NoError(t, err)
+	assert.Equal(t, policy, p)
+
+	_, err = s.Create("c8", ids["root"], amznode.CreateOptions{})
+	assert.Equal(t, childrenErr, err, "root already has 2 children")
+	_, err = s.Create("c9", ids["c6"], amznode.CreateOptions{})
+	assert.Equal(t, heightErr, err, "c6 is at height 4")
+	c9, err := s.Create("c9", ids["c5"], amznode.CreateOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 4, c9.Height)
+
+	// the subtree of c4 has two levels below c4
+	err = s.ChangeParent(ids["c4"], ids["c3"], amznode.Placement{})
+	assert.Equal(t, heightErr, err)
+	_, err = s.Copy(ids["c4"], ids["c3"], "")
+	assert.Equal(t, heightErr, err)
+	require.NoError(t, s.ChangeParent(ids["c4"], ids["c2"], amznode.Placement{}))
+
+	// other has 3 children, which the policy of root doesn't allow
+	c10, err := s.Create("c10", ids["other"], amznode.CreateOptions{})
+	require.NoError(t, err)
+	_, err = s.Create("c11", ids["other"], amznode.CreateOptions{})
+	require.NoError(t, err)
+	err = s.ChangeParent(ids["other"], ids["c1"], amznode.Placement{})
+	assert.Equal(t, childrenErr, err)
+	require.NoError(t, s.Delete(c10.ID))
+
+	require.NoError(t, s.SetPolicy(ids["other"], amznode.Policy{MaxHeight: 1}))
+	require.NoError(t, s.ChangeParent(ids["other"], ids["c1"], amznode.Placement{}))
+	_, err = s.GetPolicy(ids["other"])
+	assert.Equal(t, amznode.NewErrNotRoot(ids["other"]), err,
+		"the policy must be lost along with being a root")
+
+	_, err = s.CreatePath("root/c1/other/c7/x")
+	assert.NoError(t, err)
+	_, err = s.CreatePath("root/c1/other/c11/y/z")
+	assert.Equal(t, heightErr, err)
+	_, err = s.GetByPath("root/c1/other/c11/y")
+	assert.Equal(t, amznode.NewErrPathNotFound("root/c1/other/c11/y"), err)
+
+	require.NoError(t, s.SetPolicy(ids["root"], amznode.Policy{}))
+	p, err = s.GetPolicy(ids["root"])
+	require.NoError(t, err)
+	assert.Equal(t, amznode.Policy{}, p)
+	_, err = s.Create("c12", ids["root"], amznode.CreateOptions{})
+	assert.NoError(t, err)
+}
+
+func testCopy(t *testing.T, s amznode.Storage) {
+	ids := createTree(t, s)
+
+	node, err := s.Copy(ids["c1"], ids["c7"], "")
+	require.NoError(t, err)
+	assert.NotEqual(t, ids["c1"], node.ID)
+	assert.Equal(t, "c1", node.Name)
+	assert.Equal(t, ids["c7"], node.ParentID)
+	assert.Equal(t, ids["other"], node.RootID)
+	assert.Equal(t, 2, node.Height)
+	assert.Equal(t, []string{"c1", "c3", "c4"}, names(node))
+
+	copied, err := s.GetRec(node.ID, -1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c1", "c3", "c4", "c5", "c6"}, names(copied))
+	c6 := copied.Children[1].Children[0].Children[0]
+	assert.NotEqual(t, ids["c6"], c6.ID)
+	assert.Equal(t, ids["other"], c6.RootID)
+	assert.Equal(t, 5, c6.Height)
+
+	original, err := s.GetRec(ids["root"], -1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"root", "c1", "c3", "c4", "c5", "c6", "c2"}, names(original),
+		"the original must be left untouched")
+
+	_, err = s.Copy(ids["c1"], ids["root"], "")
+	assert.Equal(t, amznode.NewErrNameTaken("c1", ids["root"]), err)
+
+	node, err = s.Copy(ids["c1"], ids["root"], "c1copy")
+	require.NoError(t, err)
+	assert.Equal(t, "c1copy", node.Name)
+
+	node, err = s.Copy(ids["c4"], ids["c6"], "")
+	require.NoError(t, err, "a node must be copyable into its own subtree")
+	copied, err = s.GetRec(node.ID, -1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c4", "c5", "c6"}, names(copied))
+
+	node, err = s.Copy(ids["c5"], 0, "")
+	require.NoError(t, err)
+	assert.True(t, node.IsRoot())
+	assert.Equal(t, node.ID, node.RootID)
+
+	_, err = s.Copy(ids["c7"], 0, "other")
+	assert.Equal(t, amznode.NewErrNameTaken("other", 0), err, "root names must be unique")
+
+	missingID := ids["c7"] + 1000
+	_, err = s.Copy(missingID, ids["root"], "")
+	assert.Equal(t, amznode.NewErrNotFound(missingID), err)
+	_, err = s.Copy(ids["c1"], missingID, "")
+	assert.Equal(t, amznode.NewErrNotFound(missingID), err)
+}
+
+func testImport(t *testing.T, s amznode.Storage) {
+	ids := createTree(t, s)
+
+	trees := []*amznode.Node{
+		{Name: "i1", Children: []*amznode.Node{
+			{Name: "i3", Children: []*amznode.Node{{Name: "i4"}}},
+			{Name: "i2"},
+		}},
+		{Name: "i5"},
+	}
+	nodes, err := s.Import(ids["c7"], trees, amznode.ImportOptions{})
+	require.NoError(t, err)
+	require.Len(t, nodes, 2)
+	assert.Equal(t, []string{"i1", "i2", "i3", "i4", "i5"}, names(nodes...))
+	i4 := nodes[0].Children[1].Children[0]
+	assert.NotZero(t, i4.ID)
+	assert.Equal(t, nodes[0].Children[1].ID, i4.ParentID)
+	assert.Equal(t, ids["other"], i4.RootID)
+	assert.Equal(t, 4, i4.Height)
+
+	other, err := s.GetRec(ids["other"], -1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"other", "c7", "i1", "i2", "i3", "i4", "i5"}, names(other))
+
+	iroot := &amznode.Node{Name: "iroot", Children: []*amznode.Node{{Name: "i1"}}}
+	nodes, err = s.Import(0, []*amznode.Node{iroot}, amznode.ImportOptions{})
+	require.NoError(t, err)
+	assert.True(t, nodes[0].IsRoot())
+	assert.Equal(t, nodes[0].ID, nodes[0].Children[0].RootID)
+
+	_, err = s.Import(0, []*amznode.Node{{Name: "other"}}, amznode.ImportOptions{})
+	assert.Equal(t, amznode.NewErrNameTaken("other", 0), err, "root names must be unique")
+
+	nodes, err = s.Import(ids["root"], nil, amznode.ImportOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, nodes)
+
+	_, err = s.Import(ids["root"], []*amznode.Node{{Name: "new"}, {Name: "c2"}}, amznode.ImportOptions{})
+	assert.Equal(t, amznode.NewErrNameTaken("c2", ids["root"]), err)
+	_, err = s.Import(ids["root"], []*amznode.Node{
+		{Name: "new", Children: []*amznode.Node{{Name: "dup"}, {Name: "dup"}}},
+	}, amznode.ImportOptions{})
+	assert.IsType(t, &amznode.ErrNameTaken{}, err)
+	root, err := s.GetRec(ids["root"], 1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"root", "c1", "c2"}, names(root),
+		"a failed import must not leave any nodes behind")
+
+	missingID := ids["c7"] + 1000
+	_, err = s.Import(missingID, []*amznode.Node{{Name: "new"}}, amznode.ImportOptions{})
+	assert.Equal(t, amznode.NewErrNotFound(missingID), err)
+}
+
+func testImportPreserveIDs(t *testing.T, s amznode.Storage) {
+	ids := createTree(t, s)
+	opts := amznode.ImportOptions{PreserveIDs: true}
+
+	original, err := s.GetRec(ids["root"], -1)
+	require.NoError(t, err)
+	// deleted nodes keep their ids until they are purged
+	require.NoError(t, s.Delete(ids["root"]))
+	_, err = s.Import(0, []*amznode.Node{original}, opts)
+	assert.Equal(t, amznode.NewErrIDTaken(ids["root"]), err)
+	require.NoError(t, s.Purge(ids["root"]))
+
+	nodes, err := s.Import(0, []*amznode.Node{original}, opts)
+	require.NoError(t, err)
+	assert.Equal(t, []*amznode.Node{original}, nodes)
+	restored, err := s.GetRec(ids["root"], -1)
+	require.NoError(t, err)
+	assert.Equal(t, original, restored)
+
+	node, err := s.Create("new", 0, amznode.CreateOptions{})
+	require.NoError(t, err)
+	assert.True(t, node.ID > ids["c7"], "new nodes must not reuse preserved ids")
+
+	nodes, err = s.Import(ids["c7"], []*amznode.Node{{Name: "i1"}}, opts)
+	require.NoError(t, err)
+	assert.NotZero(t, nodes[0].ID, "nodes without an id must be assigned one")
+
+	_, err = s.Import(ids["c7"], []*amznode.Node{
+		{ID: node.ID + 1000, Name: "i2", Children: []*amznode.Node{{ID: ids["c1"], Name: "i3"}}},
+	}, opts)
+	assert.Equal(t, amznode.NewErrIDTaken(ids["c1"]), err)
+	_, err = s.Get(node.ID + 1000)
+	assert.Equal(t, amznode.NewErrNotFound(node.ID+1000), err,
+		"a failed import must not leave any nodes behind")
+}
+
+func testDelete(t *testing.T, s amznode.Storage) {
+	ids := createTree(t, s)
+
+	require.NoError(t, s.Delete(ids["c6"]))
+	_, err := s.Get(ids["c6"])
+	assert.Equal(t, amznode.NewErrNotFound(ids["c6"]), err)
+
+	require.NoError(t, s.Delete(ids["c1"]))
+	for _, name := range []string{"c1", "c3", "c4", "c5"} {
+		_, err := s.Get(ids[name])
+		assert.Equal(t, amznode.NewErrNotFound(ids[name]), err, "%s must be deleted", name)
+	}
+
+	node, err := s.Get(ids["root"])
+	require.NoError(t, err)
+	require.Len(t, node.Children, 1)
+	assert.Equal(t, ids["c2"], node.Children[0].ID)
+
+	require.NoError(t, s.Delete(ids["root"]))
+	roots, err := s.GetRoots()
+	require.NoError(t, err)
+	require.Len(t, roots, 1)
+	assert.Equal(t, ids["other"], roots[0].ID)
+}
+
+func testDeleteNotFound(t *testing.T, s amznode.Storage) {
+	ids := createTree(t, s)
+
+	err := s.Delete(ids["c7"] + 1000)
+	assert.Equal(t, amznode.NewErrNotFound(ids["c7"]+1000), err)
+}
+
+func testTrash(t *testing.T, s amznode.Storage) {
+	ids := createTree(t, s)
+	trashNames := func() []string {
+		trash, err := s.GetTrash()
+		require.NoError(t, err)
+		result := []string{}
+		for _, node := range trash {
+			assert.False(t, node.DeletedAt.IsZero())
+			result = append(result, node.Name)
+		}
+		return result
+	}
+
+	require.NoError(t, s.Delete(ids["c5"]))
+	require.NoError(t, s.Delete(ids["c1"]))
+	trash, err := s.GetTrash()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c1", "c5"}, names(trashNodes(trash)...),
+		"the most recently deleted node must come first")
+	assert.Equal(t, ids["root"], trash[0].ParentID)
+	assert.Equal(t, 1, trash[0].Height)
+	assert.Equal(t, ids["root"], trash[1].RootID)
+	assert.Equal(t, 3, trash[1].Height)
+
+	err = s.Delete(ids["c1"])
+	assert.Equal(t, amznode.NewErrNotFound(ids["c1"]), err)
+	_, err = s.GetAncestors(ids["c6"])
+	assert.Equal(t, amznode.NewErrNotFound(ids["c6"]), err)
+	results, err := s.Search("c", amznode.SearchOptions{})
+	require.NoError(t, err)
+	assert.Len(t, results, 2, "only c2 and c7 must be found")
+	nodes, err := walk(func(f func(*amznode.Node) error) error {
+		return s.WalkRootsRec(-1, f)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"other", "root", "c2", "c7"}, names(nodes...))
+
+	// the name of a deleted node can be taken by a new node
+	c1, err := s.Create("c1", ids["root"], amznode.CreateOptions{})
+	require.NoError(t, err)
+	_, err = s.Restore(ids["c1"])
+	assert.Equal(t, amznode.NewErrNameTaken("c1", ids["root"]), err)
+	require.NoError(t, s.Delete(c1.ID))
+
+	node, err := s.Restore(ids["c1"])
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c1", "c3", "c4"}, names(node))
+	node, err = s.GetRec(ids["c4"], -1)
+	require.NoError(t, err)
+	assert.Empty(t, node.Children, "c5 was deleted before c1, so it must stay deleted")
+	_, err = s.Restore(ids["c5"])
+	require.NoError(t, err)
+	node, err = s.GetRec(ids["root"], -1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"root", "c1", "c3", "c4", "c5", "c6", "c2"}, names(node))
+	assert.Equal(t, []string{"c1"}, trashNames())
+
+	_, err = s.Restore(ids["c3"])
+	assert.Equal(t, amznode.NewErrNotFound(ids["c3"]), err)
+	_, err = s.Restore(ids["c6"])
+	assert.Equal(t, amznode.NewErrNotFound(ids["c6"]), err,
+		"nodes deleted along with another node must be restored along with it")
+
+	require.NoError(t, s.Delete(ids["c5"]))
+	require.NoError(t, s.DeleteByPath("root/c1/c4"))
+	_, err = s.Restore(ids["c5"])
+	assert.Equal(t, amznode.NewErrNotFound(ids["c4"]), err)
+	_, err = s.Restore(ids["c4"])
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c5", "c1"}, trashNames())
+}
+
+// trashNodes returns the nodes of the trash
+func trashNodes(trash []*amznode.TrashedNode) []*amznode.Node {
+	nodes := make([]*amznode.Node, len(trash))
+	for i, trashed := range trash {
+		nodes[i] = &trashed.Node
+	}
+	return nodes
+}
+
+func testPurge(t *testing.T, s amznode.Storage) {
+	ids := createTree(t, s)
+
+	require.NoError(t, s.Delete(ids["c5"]))
+	require.NoError(t, s.Delete(ids["c1"]))
+	require.NoError(t, s.Delete(ids["c7"]))
+
+	require.NoError(t, s.Purge(ids["c1"]))
+	trash, err := s.GetTrash()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c7"}, names(trashNodes(trash)...),
+		"c5 must be purged along with c1")
+	for _, name := range []string{"c1", "c5"} {
+		_, err := s.Restore(ids[name])
+		assert.Equal(t, amznode.NewErrNotFound(ids[name]), err, "%s must be purged", name)
+	}
+
+	err = s.Purge(ids["c2"])
+	assert.Equal(t, amznode.NewErrNotFound(ids["c2"]), err, "live nodes can't be purged")
+	err = s.Purge(ids["c6"])
+	assert.Equal(t, amznode.NewErrNotFound(ids["c6"]), err)
+
+	require.NoError(t, s.Purge(ids["c7"]))
+	node, err := s.Get(ids["other"])
+	require.NoError(t, err)
+	assert.Empty(t, node.Children)
+}
+
+func testTrashRec(t *testing.T, s amznode.Storage) {
+	ids := createTree(t, s)
+
+	require.NoError(t, s.Delete(ids["c5"]))
+	require.NoError(t, s.Delete(ids["c1"]))
+
+	trash, err := s.GetTrashRec()
+	require.NoError(t, err)
+	require.Len(t, trash, 2)
+	assert.Equal(t, []string{"c1", "c3", "c4", "c5", "c6"}, names(trashNodes(trash)...),
+		"c5 was deleted before c1, so it must not be a child of c4")
+	c4 := trash[0].Children[1]
+	assert.Equal(t, ids["c1"], c4.ParentID)
+	assert.Equal(t, ids["root"], c4.RootID)
+	assert.Equal(t, 2, c4.Height)
+	c6 := trash[1].Children[0]
+	assert.Equal(t, ids["root"], c6.RootID)
+	assert.Equal(t, 4, c6.Height)
+
+	flat, err := s.GetTrash()
+	require.NoError(t, err)
+	for i := range flat {
+		assert.Equal(t, flat[i].ID, trash[i].ID)
+		assert.True(t, flat[i].DeletedAt.Equal(trash[i].DeletedAt))
+	}
+}
+
+func testImportTrash(t *testing.T, s amznode.Storage) {
+	ids := createTree(t, s)
+	deletedAt := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	trees := []*amznode.Node{{Name: "c1", Children: []*amznode.Node{{Name: "x"}}}}
+	nodes, err := s.Import(ids["root"], trees, amznode.ImportOptions{DeletedAt: deletedAt})
+	require.NoError(t, err, "deleted nodes don't take up names")
+	require.Len(t, nodes, 1)
+	assert.Equal(t, []string{"c1", "x"}, names(nodes...))
+	assert.Equal(t, ids["root"], nodes[0].ParentID)
+	assert.Equal(t, 2, nodes[0].Children[0].Height)
+	trashedID := nodes[0].ID
+
+	// a node deleted before the imported node is imported under it
+	older := deletedAt.Add(-time.Hour)
+	_, err = s.Import(trashedID, []*amznode.Node{{Name: "y"}}, amznode.ImportOptions{DeletedAt: older})
+	require.NoError(t, err, "the parent may be in the trash")
+
+	trash, err := s.GetTrashRec()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c1", "x", "y"}, names(trashNodes(trash)...))
+	assert.True(t, deletedAt.Equal(trash[0].DeletedAt))
+	assert.True(t, older.Equal(trash[1].DeletedAt))
+
+	_, err = s.Import(ids["root"], []*amznode.Node{{Name: "d"}, {Name: "d"}},
+		amznode.ImportOptions{DeletedAt: deletedAt})
+	assert.Equal(t, amznode.NewErrNameTaken("d", ids["root"]), err)
+	missingID := ids["c7"] + 1000
+	_, err = s.Import(missingID, []*amznode.Node{{Name: "d"}}, amznode.ImportOptions{DeletedAt: deletedAt})
+	assert.Equal(t, amznode.NewErrNotFound(missingID), err)
+
+	_, err = s.Restore(trashedID)
+	assert.Equal(t, amznode.NewErrNameTaken("c1", ids["root"]), err)
+	require.NoError(t, s.Delete(ids["c1"]))
+	node, err := s.Restore(trashedID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c1", "x"}, names(node), "y was deleted before c1")
+}
+
+func testCreatePath(t *testing.T, s amznode.Storage) {
+	ids := createTree(t, s)
+
+	node, err := s.CreatePath("root/c1/c4/new1/new2")
+	require.NoError(t, err)
+	assert.Equal(t, "new2", node.Name)
+	assert.Equal(t, ids["root"], node.RootID)
+	assert.Equal(t, 4, node.Height)
+
+	parent, err := s.Get(node.ParentID)
+	require.NoError(t, err)
+	assert.Equal(t, "new1", parent.Name)
+	assert.Equal(t, ids["c4"], parent.ParentID)
+
+	node, err = s.CreatePath("/newRoot/child/")
+	require.NoError(t, err)
+	assert.Equal(t, "child", node.Name)
+	assert.Equal(t, 1, node.Height)
+
+	node, err = s.CreatePath("root/c1")
+	require.NoError(t, err, "existing paths must not be an error")
+	assert.Equal(t, ids["c1"], node.ID)
+	assert.Len(t, node.Children, 2)
+
+	_, err = s.CreatePath("")
+	assert.Equal(t, amznode.NewErrPathNotFound(""), err)
+}
+
+func testGetByPath(t *testing.T, s amznode.Storage) {
+	ids := createTree(t, s)
+
+	node, err := s.GetByPath("root/c1/c4")
+	require.NoError(t, err)
+	expected, err := s.Get(ids["c4"])
+	require.NoError(t, err)
+	assert.Equal(t, expected, node)
+
+	node, err = s.GetByPath("other")
+	require.NoError(t, err)
+	assert.Equal(t, ids["other"], node.ID)
+
+	for _, path := range []string{"", "root/c3", "c1", "root/c1/c4/c5/c6/c7"} {
+		_, err = s.GetByPath(path)
+		assert.Equal(t, amznode.NewErrPathNotFound(path), err)
+	}
+}
+
+func testDeleteByPath(t *testing.T, s amznode.Storage) {
+	ids := createTree(t, s)
+
+	require.NoError(t, s.DeleteByPath("root/c1/c4"))
+	for _, name := range []string{"c4", "c5", "c6"} {
+		_, err := s.Get(ids[name])
+		assert.Equal(t, amznode.NewErrNotFound(ids[name]), err, "%s must be deleted", name)
+	}
+	_, err := s.Get(ids["c3"])
+	assert.NoError(t, err)
+
+	err = s.DeleteByPath("root/c1/c4")
+	assert.Equal(t, amznode.NewErrPathNotFound("root/c1/c4"), err)
+}
+
+// concurrency is the number of goroutines used by the concurrency tests
+const concurrency = 8
+
+func testConcurrentCreate(t *testing.T, s amznode.Storage) {
+	ids := createTree(t, s)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, concurrency)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := s.Create("new", ids["c2"], amznode.CreateOptions{})
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	created := 0
+	for err := range errs {
+		if err == nil {
+			created++
+			continue
+		}
+		assert.Equal(t, amznode.NewErrNameTaken("new", ids["c2"]), err)
+	}
+	assert.Equal(t, 1, created, "exactly one node must be created")
+}
+
+func testConcurrentCreatePath(t *testing.T, s amznode.Storage) {
+	createTree(t, s)
+
+	var wg sync.WaitGroup
+	nodes := make(chan *amznode.Node, concurrency)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			node, err := s.CreatePath("root/c2/new1/new2")
+			assert.NoError(t, err)
+			nodes <- node
+		}()
+	}
+	wg.Wait()
+	close(nodes)
+
+	expected, err := s.GetByPath("root/c2/new1/new2")
+	require.NoError(t, err)
+	for node := range nodes {
+		if assert.NotNil(t, node) {
+			assert.Equal(t, expected.ID, node.ID)
+		}
+	}
+}
+
+// testConcurrentChangeParent moves nodes around at random from multiple
+// goroutines and checks that no cycles has been introduced afterwards. A
+// cycle detaches the nodes in it from the roots, so it is enough to check
+// that all nodes can still be reached from the roots.
+func testConcurrentChangeParent(t *testing.T, s amznode.Storage) {
+	const nodeCount = 10
+	const moves = 50
+
+	root, err := s.Create("root", 0, amznode.CreateOptions{})
+	require.NoError(t, err)
+	ids := []int{}
+	for i := 0; i < nodeCount; i++ {
+		node, err := s.Create("n"+strconv.Itoa(i), root.ID, amznode.CreateOptions{})
+		require.NoError(t, err)
+		ids = append(ids, node.ID)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rnd := rand.New(rand.NewSource(seed))
+			for j := 0; j < moves; j++ {
+				id := ids[rnd.Intn(len(ids))]
+				newParentID := ids[rnd.Intn(len(ids))]
+				err := s.ChangeParent(id, newParentID, amznode.Placement{})
+				switch err.(type) {
+				case nil, *amznode.ErrNodeIsDecendant:
+				default:
+					t.Errorf("unexpected error: %s", err)
+				}
+			}
+		}(int64(i))
+	}
+	wg.Wait()
+
+	roots, err := s.GetRootsRec(-1)
+	require.NoError(t, err)
+	assert.Len(t, names(roots...), nodeCount+1, "all nodes must be reachable from the root")
+}
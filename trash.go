@@ -0,0 +1,11 @@
+package amznode
+
+import "time"
+
+// TrashedNode is a node which has been deleted along with its decendants. It
+// stays in the trash, from where it can be restored, until it is purged.
+type TrashedNode struct {
+	Node `yaml:",inline"`
+	// DeletedAt is the time the node was deleted
+	DeletedAt time.Time `json:"deleted_at" yaml:"deleted_at" xml:"deleted_at,attr"`
+}
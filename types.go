@@ -0,0 +1,312 @@
+package amznode
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+)
+
+// TypeSchema registers the types of nodes along with the types of children
+// they allow and rules for their attributes. It is read from json like:
+//
+//	{
+//	  "roots": ["company"],
+//	  "types": {
+//	    "company": {"children": ["division"]},
+//	    "division": {"children": ["team"]},
+//	    "team": {
+//	      "attributes": {
+//	        "manager": {"type": "string", "required": true},
+//	        "size": {"type": "integer", "minimum": 1}
+//	      }
+//	    }
+//	  }
+//	}
+//
+// Untyped nodes are not covered by the schema. They can have children of any
+// type, and can only be children of typed nodes if their type allows it.
+//
+// The methods of a nil TypeSchema allow any tree, such that storages without
+// a schema don't have to check for one.
+type TypeSchema struct {
+	// Roots are the types of nodes which can be root nodes. Nodes of any type
+	// can be roots if Roots is empty.
+	Roots []string             `json:"roots,omitempty"`
+	Types map[string]*NodeType `json:"types"`
+}
+
+// NodeType describes the nodes of a type in a `TypeSchema`
+type NodeType struct {
+	// Children are the types of the children allowed under nodes of the
+	// type. Nodes of a type without Children can't have any children.
+	Children []string `json:"children,omitempty"`
+	// Attributes are the rules of the attributes of the nodes by key
+	Attributes map[string]*AttributeRule `json:"attributes,omitempty"`
+	// AdditionalAttributes allows attributes without a rule, which is the
+	// default. Set it to false to only allow the attributes with rules.
+	AdditionalAttributes *bool `json:"additionalAttributes,omitempty"`
+}
+
+// AttributeRule is a rule for the value of an attribute, modelled after the
+// keywords of json schema
+type AttributeRule struct {
+	// Type is one of "string", "number", "integer", "boolean", "object" or
+	// "array". Values of any type are allowed if Type is empty.
+	Type     string        `json:"type,omitempty"`
+	Required bool          `json:"required,omitempty"`
+	Enum     []interface{} `json:"enum,omitempty"`
+	// Pattern is a regular expression which string values must match
+	Pattern string   `json:"pattern,omitempty"`
+	Minimum *float64 `json:"minimum,omitempty"`
+	Maximum *float64 `json:"maximum,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+var attributeRuleTypes = map[string]bool{
+	"string": true, "number": true, "integer": true, "boolean": true,
+	"object": true, "array": true,
+}
+
+// ReadTypeSchema reads a schema as json from r and checks that it is
+// consistent, e.g. that all the child types are registered
+func ReadTypeSchema(r io.Reader) (*TypeSchema, error) {
+	var schema TypeSchema
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&schema); err != nil {
+		return nil, err
+	}
+	if err := schema.compile(); err != nil {
+		return nil, err
+	}
+	return &schema, nil
+}
+
+// LoadTypeSchema reads a schema from the json file at `path`
+func LoadTypeSchema(path string) (*TypeSchema, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadTypeSchema(f)
+}
+
+// compile checks the schema and compiles the patterns of its rules
+func (ts *TypeSchema) compile() error {
+	if len(ts.Types) == 0 {
+		return fmt.Errorf("the schema must register at least one type")
+	}
+	for _, root := range ts.Roots {
+		if _, ok := ts.Types[root]; !ok {
+			return fmt.Errorf("the root type '%s' is not registered", root)
+		}
+	}
+	for name, nodeType := range ts.Types {
+		if !validName(name) {
+			return fmt.Errorf("the type name '%s' must match the regex /%s/", name, validNameRegexpStr)
+		}
+		if nodeType == nil {
+			return fmt.Errorf("the type '%s' must be an object", name)
+		}
+		for _, child := range nodeType.Children {
+			if _, ok := ts.Types[child]; !ok {
+				return fmt.Errorf("the child type '%s' of the type '%s' is not registered", child, name)
+			}
+		}
+		for key, rule := range nodeType.Attributes {
+			if rule == nil {
+				return fmt.Errorf("the rule of the attribute '%s' of the type '%s' must be an object", key, name)
+			}
+			if rule.Type != "" && !attributeRuleTypes[rule.Type] {
+				return fmt.Errorf("the attribute '%s' of the type '%s' has the unknown type '%s'", key, name, rule.Type)
+			}
+			if rule.Pattern != "" {
+				pattern, err := regexp.Compile(rule.Pattern)
+				if err != nil {
+					return fmt.Errorf("the attribute '%s' of the type '%s' has an invalid pattern: %s", key, name, err)
+				}
+				rule.pattern = pattern
+			}
+		}
+	}
+	return nil
+}
+
+// ValidatePlacement checks that a node of type `typ` can be a child of a node
+// of type `parentType`, or a root node if `parentID` is 0
+func (ts *TypeSchema) ValidatePlacement(typ string, parentID int, parentType string) error {
+	if ts == nil {
+		return nil
+	}
+	if err := ts.validateType(typ); err != nil {
+		return err
+	}
+	if parentID == 0 {
+		if len(ts.Roots) > 0 && !contains(ts.Roots, typ) {
+			return NewErrSchemaViolation(fmt.Sprintf("%s can't be a root node", describeType(typ)))
+		}
+		return nil
+	}
+	parent, ok := ts.Types[parentType]
+	if !ok || contains(parent.Children, typ) {
+		return nil
+	}
+	return NewErrSchemaViolation(fmt.Sprintf(
+		"%s can't be a child of %s", describeType(typ), describeType(parentType)))
+}
+
+// ValidateAttributes checks the attributes of a node of type `typ` against
+// the rules of the type
+func (ts *TypeSchema) ValidateAttributes(typ string, attrs map[string]interface{}) error {
+	if ts == nil {
+		return nil
+	}
+	if err := ts.validateType(typ); err != nil {
+		return err
+	}
+	nodeType, ok := ts.Types[typ]
+	if !ok {
+		return nil
+	}
+
+	// the values are checked as they would be decoded from json, such that
+	// e.g. all numbers are float64
+	b, err := json.Marshal(attrs)
+	if err != nil {
+		return err
+	}
+	var values map[string]interface{}
+	if err := json.Unmarshal(b, &values); err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(nodeType.Attributes))
+	for key := range nodeType.Attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value, ok := values[key]
+		if !ok || value == nil {
+			if nodeType.Attributes[key].Required {
+				return NewErrSchemaViolation(fmt.Sprintf(
+					"the attribute '%s' is required for %s", key, describeType(typ)))
+			}
+			continue
+		}
+		if reason := nodeType.Attributes[key].check(value); reason != "" {
+			return NewErrSchemaViolation(fmt.Sprintf(
+				"the attribute '%s' of %s %s", key, describeType(typ), reason))
+		}
+	}
+	if nodeType.AdditionalAttributes != nil && !*nodeType.AdditionalAttributes {
+		for key := range values {
+			if _, ok := nodeType.Attributes[key]; !ok {
+				return NewErrSchemaViolation(fmt.Sprintf(
+					"the attribute '%s' is not allowed for %s", key, describeType(typ)))
+			}
+		}
+	}
+	return nil
+}
+
+func (ts *TypeSchema) validateType(typ string) error {
+	if _, ok := ts.Types[typ]; typ != "" && !ok {
+		return NewErrSchemaViolation(fmt.Sprintf("the node type '%s' is not registered", typ))
+	}
+	return nil
+}
+
+// check returns the reason the value breaks the rule, or an empty string if
+// it follows the rule
+func (rule *AttributeRule) check(value interface{}) string {
+	if rule.Type != "" && !hasRuleType(value, rule.Type) {
+		return "must be of type " + rule.Type
+	}
+	if len(rule.Enum) > 0 {
+		found := false
+		for _, allowed := range rule.Enum {
+			if reflect.DeepEqual(value, allowed) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return "must be one of the values of its enum"
+		}
+	}
+	if s, ok := value.(string); ok && rule.pattern != nil && !rule.pattern.MatchString(s) {
+		return fmt.Sprintf("must match the regex /%s/", rule.Pattern)
+	}
+	if f, ok := value.(float64); ok {
+		if rule.Minimum != nil && f < *rule.Minimum {
+			return fmt.Sprintf("must be at least %v", *rule.Minimum)
+		}
+		if rule.Maximum != nil && f > *rule.Maximum {
+			return fmt.Sprintf("must be at most %v", *rule.Maximum)
+		}
+	}
+	return ""
+}
+
+func hasRuleType(value interface{}, typ string) bool {
+	switch v := value.(type) {
+	case string:
+		return typ == "string"
+	case float64:
+		return typ == "number" || (typ == "integer" && v == float64(int64(v)))
+	case bool:
+		return typ == "boolean"
+	case map[string]interface{}:
+		return typ == "object"
+	case []interface{}:
+		return typ == "array"
+	}
+	return false
+}
+
+func describeType(typ string) string {
+	if typ == "" {
+		return "an untyped node"
+	}
+	return fmt.Sprintf("a node of type '%s'", typ)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+var errInvalidType = fmt.Errorf("type must match the regex /%s/", validNameRegexpStr)
+
+// urlParamCreateOptions reads the type and placement of a node to create from
+// the `type`, `before` and `after` query parameters, and its attributes from
+// the request body if it has one
+func urlParamCreateOptions(r *http.Request) (CreateOptions, error) {
+	opts := CreateOptions{Type: r.URL.Query().Get("type")}
+	if opts.Type != "" && !validName(opts.Type) {
+		return opts, errInvalidType
+	}
+	placement, err := urlParamPlacement(r)
+	if err != nil {
+		return opts, err
+	}
+	opts.Placement = placement
+	attrs, err := decodeAttributes(r.Body)
+	if err == errEmptyBody {
+		return opts, nil
+	}
+	opts.Attributes = attrs
+	return opts, err
+}
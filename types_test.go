@@ -0,0 +1,124 @@
+package amznode_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/blacksails/amznode"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadTypeSchema(t *testing.T) {
+	cases := map[string]struct {
+		schema string
+		err    string
+	}{
+		"valid": {
+			schema: `{"roots": ["a"], "types": {"a": {"children": ["b"]}, "b": {}}}`,
+		},
+		"no types": {
+			schema: `{"types": {}}`,
+			err:    "the schema must register at least one type",
+		},
+		"unknown root": {
+			schema: `{"roots": ["b"], "types": {"a": {}}}`,
+			err:    "the root type 'b' is not registered",
+		},
+		"unknown child": {
+			schema: `{"types": {"a": {"children": ["b"]}}}`,
+			err:    "the child type 'b' of the type 'a' is not registered",
+		},
+		"invalid name": {
+			schema: `{"types": {"a b": {}}}`,
+			err:    "the type name 'a b' must match the regex /^[a-zA-Z\\d-_]+$/",
+		},
+		"unknown rule type": {
+			schema: `{"types": {"a": {"attributes": {"x": {"type": "date"}}}}}`,
+			err:    "the attribute 'x' of the type 'a' has the unknown type 'date'",
+		},
+		"invalid pattern": {
+			schema: `{"types": {"a": {"attributes": {"x": {"pattern": "("}}}}}`,
+			err: "the attribute 'x' of the type 'a' has an invalid pattern: " +
+				"error parsing regexp: missing closing ): `(`",
+		},
+		"unknown field": {
+			schema: `{"types": {"a": {"parents": ["b"]}}}`,
+			err:    `json: unknown field "parents"`,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := amznode.ReadTypeSchema(strings.NewReader(c.schema))
+			if c.err == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, c.err)
+		})
+	}
+}
+
+func TestTypeSchemaValidateAttributes(t *testing.T) {
+	schema, err := amznode.ReadTypeSchema(strings.NewReader(`{
+		"types": {
+			"team": {
+				"attributes": {
+					"code": {"type": "string", "pattern": "^cc-\\d+$"},
+					"level": {"enum": ["junior", "senior"]},
+					"size": {"type": "number", "minimum": 1, "maximum": 10}
+				},
+				"additionalAttributes": false
+			}
+		}
+	}`))
+	require.NoError(t, err)
+
+	cases := map[string]struct {
+		attrs map[string]interface{}
+		err   string
+	}{
+		"valid": {
+			attrs: map[string]interface{}{"code": "cc-42", "level": "senior", "size": 10},
+		},
+		"empty": {},
+		"pattern": {
+			attrs: map[string]interface{}{"code": "42"},
+			err:   "the attribute 'code' of a node of type 'team' must match the regex /^cc-\\d+$/",
+		},
+		"enum": {
+			attrs: map[string]interface{}{"level": "lead"},
+			err:   "the attribute 'level' of a node of type 'team' must be one of the values of its enum",
+		},
+		"maximum": {
+			attrs: map[string]interface{}{"size": 10.5},
+			err:   "the attribute 'size' of a node of type 'team' must be at most 10",
+		},
+		"type": {
+			attrs: map[string]interface{}{"size": "10"},
+			err:   "the attribute 'size' of a node of type 'team' must be of type number",
+		},
+		"additional": {
+			attrs: map[string]interface{}{"manager": "alice"},
+			err:   "the attribute 'manager' is not allowed for a node of type 'team'",
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			err := schema.ValidateAttributes("team", c.attrs)
+			if c.err == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, c.err)
+		})
+	}
+
+	assert.NoError(t, schema.ValidateAttributes("", map[string]interface{}{"manager": "alice"}),
+		"untyped nodes must not be checked")
+	var none *amznode.TypeSchema
+	assert.NoError(t, none.ValidateAttributes("team", map[string]interface{}{"code": 42}),
+		"a nil schema must allow any attributes")
+}
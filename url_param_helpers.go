@@ -15,6 +15,11 @@ const validNameRegexpStr = `^[a-zA-Z\d-_]+$`
 var validNameRegexp = regexp.MustCompile(validNameRegexpStr)
 var errInvalidName = fmt.Errorf("name must match the regex /%s/", validNameRegexpStr)
 var errInvalidID = errors.New("ids must be greater than or equal 0")
+var errInvalidDepth = errors.New("depth must be greater than or equal 0")
+var errInvalidPath = fmt.Errorf(
+	"paths must consist of names matching the regex /%s/ separated by '%s'",
+	validNameRegexpStr, PathSeparator,
+)
 
 func urlOrQueryParam(r *http.Request, paramName string) string {
 	paramStr := chi.URLParam(r, paramName)
@@ -47,6 +52,55 @@ func urlParamName(r *http.Request, paramName string) (string, error) {
 	return name, nil
 }
 
+// urlParamDepth returns the depth to which decendants should be fetched. The
+// `r` parameter fetches all decendants, while the `depth` parameter fetches a
+// fixed number of levels. By default only the immediate children are fetched.
+func urlParamDepth(r *http.Request) (int, error) {
+	query := r.URL.Query()
+	if depthStr := query.Get("depth"); depthStr != "" {
+		depth, err := strconv.Atoi(depthStr)
+		if err != nil {
+			return depth, err
+		}
+		if depth < 0 {
+			return depth, errInvalidDepth
+		}
+		return depth, nil
+	}
+	rec, err := urlParamBool(r, "r")
+	if err != nil {
+		return 0, err
+	}
+	if rec {
+		return -1, nil
+	}
+	return 1, nil
+}
+
+// urlParamBool parses the query parameter as a boolean, which is false if the
+// parameter is left out.
+func urlParamBool(r *http.Request, paramName string) (bool, error) {
+	str := r.URL.Query().Get(paramName)
+	if str == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(str)
+}
+
+func urlParamPath(r *http.Request) (string, error) {
+	path := chi.URLParam(r, "*")
+	names := SplitPath(path)
+	if len(names) == 0 {
+		return path, errInvalidPath
+	}
+	for _, name := range names {
+		if !validName(name) {
+			return path, errInvalidPath
+		}
+	}
+	return JoinPath(names...), nil
+}
+
 func validName(name string) bool {
 	return validNameRegexp.MatchString(name)
 }